@@ -2,102 +2,273 @@ package main
 
 import (
 	"big_go/config"
-	"big_go/internal/models"
-	"bytes"
-	"encoding/json"
+	"big_go/internal/codec"
+	"big_go/internal/repository"
+	"big_go/internal/services"
+	"big_go/internal/services/collector"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/streadway/amqp"
 )
 
 func main() {
-	// Инициализация конфигурации RabbitMQ
-	rabbitConfig, err := config.LoadRabbitMQConfig("config_rabbitmq.json")
-	if err != nil {
-		log.Fatalf("Ошибка загрузки конфигурации RabbitMQ: %v", err)
+	// Инициализация конфигурации: значения по умолчанию < файлы < окружение < флаги
+	rabbitConfig := &config.RabbitMQConfig{}
+	postgresConfig := &config.PostgresConfig{}
+	redisConfig := &config.RedisConfig{}
+	opentsdbConfig := &config.OpentsdbConfig{}
+	collectorConfig := &config.CollectorConfig{}
+	loader := config.NewLoader("collector")
+	loader.Add("rabbitmq", rabbitConfig, "config_rabbitmq.json")
+	loader.Add("postgres", postgresConfig, "config_postgresql.json")
+	loader.Add("redis", redisConfig, "config_redis.json")
+	loader.Add("opentsdb", opentsdbConfig, "config_opentsdb.json")
+	loader.Add("collector", collectorConfig, "config_collector.json")
+	if err := loader.Load(os.Args[1:]); err != nil {
+		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
 	}
+	loader.LogReport()
 
-	// Подключение к RabbitMQ
-	conn, err := amqp.Dial(
-		"amqp://" + rabbitConfig.User + ":" + rabbitConfig.Password +
-			"@" + rabbitConfig.Host + ":" + fmt.Sprintf("%d", rabbitConfig.Port) + "/" + rabbitConfig.VHost,
-	)
-	if err != nil {
-		log.Fatalf("Ошибка подключения к RabbitMQ: %v", err)
+	// Форматы тел для получателей проверяются при запуске
+	for recipient, contentType := range collectorConfig.ContentTypes {
+		if _, err := codec.ForContentType(contentType); err != nil {
+			log.Fatalf("Получатель %s: %v", recipient, err)
+		}
+	}
+	if _, err := codec.ForContentType(collectorConfig.DefaultContentType); err != nil {
+		log.Fatalf("Формат по умолчанию: %v", err)
 	}
-	defer conn.Close()
 
-	// Создание канала
-	ch, err := conn.Channel()
-	if err != nil {
-		log.Fatalf("Ошибка создания канала: %v", err)
-	}
-	defer ch.Close()
-
-	// Объявление очереди
-	q, err := ch.QueueDeclare(
-		"sensor_data", // имя очереди
-		true,          // durable
-		false,         // delete when unused
-		false,         // exclusive
-		false,         // no-wait
-		nil,           // arguments
-	)
+	// Таблица маршрутов перечитывается при изменении файла и по SIGHUP
+	router, err := collector.LoadRouter(collectorConfig.RoutingFile)
 	if err != nil {
-		log.Fatalf("Ошибка объявления очереди: %v", err)
-	}
-
-	// Настройка потребителя сообщений
-	msgs, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		true,   // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
-	)
+		log.Fatalf("Ошибка загрузки таблицы маршрутов: %v", err)
+	}
+	log.Printf("Таблица маршрутов загружена из %s: получатели %v, правила %v",
+		collectorConfig.RoutingFile, router.Recipients(), router.Rules())
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go router.Watch(context.Background(), collectorConfig.RoutingFile, collectorConfig.RoutingReload.Duration, hup)
+
+	// Показания доставляются через журнал на диске: получатель, который
+	// был недоступен, получит их после восстановления
+	outbox, err := collector.OpenOutbox(collectorConfig.OutboxDir, router, collector.OutboxOptions{
+		RetryMin:    collectorConfig.OutboxRetryMin.Duration,
+		RetryMax:    collectorConfig.OutboxRetryMax.Duration,
+		MaxAge:      collectorConfig.OutboxMaxAge.Duration,
+		MaxAttempts: collectorConfig.OutboxMaxAttempts,
+		Sync:        collectorConfig.OutboxSync,
+	})
 	if err != nil {
-		log.Fatalf("Ошибка регистрации потребителя: %v", err)
+		log.Fatalf("Ошибка открытия outbox: %v", err)
 	}
 
-	log.Println("Коллектор запущен. Ожидание сообщений...")
+	opts := collector.Options{
+		ContentType: collectorConfig.ContentType,
+		// Окно дедупликации: повторно доставленные брокером и повторно
+		// опубликованные генератором показания пересылаются один раз
+		Dedup: services.NewDeduplicator(collectorConfig.DedupWindow.Duration, collectorConfig.DedupCapacity),
+	}
 
-	// Обработка сообщений
-	for d := range msgs {
-		var sensorData models.SensorData
-		err := json.Unmarshal(d.Body, &sensorData)
+	// Сообщения, не прошедшие проверку схемы, и показания с недопустимыми
+	// значениями, неизвестных постов или с неверным временем не
+	// пересылаются, а ждут решения оператора в карантине
+	opts.Quarantine, err = collector.OpenQuarantine(collectorConfig.QuarantineFile, collectorConfig.QuarantineCapacity)
+	if err != nil {
+		log.Fatalf("Ошибка открытия карантина: %v", err)
+	}
+	defer opts.Quarantine.Close()
+	log.Printf("Карантин %s: %d показаний", collectorConfig.QuarantineFile, opts.Quarantine.Stats().Pending)
+	if collectorConfig.ValidationFile != "" {
+		opts.Validator, err = collector.LoadValidator(collectorConfig.ValidationFile)
 		if err != nil {
-			log.Printf("Ошибка десериализации сообщения: %v", err)
-			continue
+			log.Fatalf("Ошибка загрузки правил проверки: %v", err)
 		}
+		log.Printf("Проверка показаний по %s: постов в реестре %d",
+			collectorConfig.ValidationFile, opts.Validator.Posts())
+		// Реестр постов перечитывается так же, как таблица маршрутов: при
+		// изменении файла и по SIGHUP
+		registryHup := make(chan os.Signal, 1)
+		signal.Notify(registryHup, syscall.SIGHUP)
+		go opts.Validator.Watch(context.Background(), collectorConfig.RoutingReload.Duration, registryHup)
+	}
 
-		log.Printf("Получено сообщение: %+v", sensorData)
-
-		// Определяем, куда отправить данные
-		var endpoint string
-		if sensorData.Meta.Recipient == "User1" {
-			endpoint = "http://user1:8082/data"
-		} else {
-			endpoint = "http://user2:8083/data"
+	// История показаний пишется в PostgreSQL в фоне; пока база недоступна,
+	// показания копятся в буфере
+	var repo *repository.PostgresRepository
+	var history *repository.Writer
+	if collectorConfig.HistoryEnabled {
+		repo, err = repository.NewPostgresRepository(postgresConfig)
+		if err != nil {
+			log.Fatalf("Ошибка настройки PostgreSQL: %v", err)
 		}
+		defer repo.Close()
+		history = repository.NewWriter(repo, repository.WriterOptions{
+			BatchSize:     collectorConfig.HistoryBatchSize,
+			FlushInterval: collectorConfig.HistoryFlushInterval.Duration,
+			Buffer:        collectorConfig.HistoryBuffer,
+			RetryMin:      rabbitConfig.ReconnectMin.Duration,
+			RetryMax:      rabbitConfig.ReconnectMax.Duration,
+		})
+		opts.Stores = append(opts.Stores, history)
+		opts.RollupStores = append(opts.RollupStores, history)
+	}
 
-		// Отправляем данные соответствующему пользователю
-		jsonData, err := json.Marshal(sensorData)
+	// Последние показания постов и адресов, история адресатов и
+	// уведомления в Redis
+	var cache *services.RedisCache
+	if collectorConfig.CacheEnabled {
+		cache = services.NewRedisCache(redisConfig, services.RedisCacheOptions{
+			Buffer:   collectorConfig.CacheBuffer,
+			RetryMin: rabbitConfig.ReconnectMin.Duration,
+			RetryMax: rabbitConfig.ReconnectMax.Duration,
+		})
+		defer cache.Close()
+		opts.Stores = append(opts.Stores, cache)
+	}
+
+	// Измерения как временные ряды OpenTSDB
+	var tsdb *services.OpentsdbWriter
+	if collectorConfig.TSDBEnabled {
+		tsdb, err = services.NewOpentsdbWriter(opentsdbConfig, services.OpentsdbOptions{
+			BatchSize:     collectorConfig.TSDBBatchSize,
+			FlushInterval: collectorConfig.TSDBFlushInterval.Duration,
+			Buffer:        collectorConfig.TSDBBuffer,
+			RetryMin:      rabbitConfig.ReconnectMin.Duration,
+			RetryMax:      rabbitConfig.ReconnectMax.Duration,
+		})
 		if err != nil {
-			log.Printf("Ошибка сериализации данных: %v", err)
-			continue
+			log.Fatalf("Ошибка настройки OpenTSDB: %v", err)
 		}
+		opts.Stores = append(opts.Stores, tsdb)
+		opts.RollupStores = append(opts.RollupStores, tsdb)
+	}
+
+	// Сводки показаний по окнам времени для каждого поста и адреса
+	windows, err := collectorConfig.RollupDurations()
+	if err != nil {
+		log.Fatalf("Ошибка настройки сводок: %v", err)
+	}
+	if len(windows) > 0 {
+		opts.Aggregator = collector.NewAggregator(collector.AggregatorOptions{
+			Windows:   windows,
+			Lateness:  collectorConfig.RollupLateness.Duration,
+			MaxSeries: collectorConfig.RollupMaxSeries,
+		})
+		log.Printf("Сводки по окнам %v, ожидание опоздавших показаний %s", windows, collectorConfig.RollupLateness.Duration)
+	}
 
-		resp, err := http.Post(endpoint, "application/json", bytes.NewBuffer(jsonData))
+	// Тревоги по порогам и скорости изменения; уведомления доставляются
+	// адресатам из раздела notifiers таблицы маршрутов и получателям
+	if collectorConfig.AlertingFile != "" {
+		opts.Alerts, err = collector.LoadAlertEngine(collectorConfig.AlertingFile)
 		if err != nil {
-			log.Printf("Ошибка отправки данных пользователю: %v", err)
-			continue
+			log.Fatalf("Ошибка загрузки правил тревог: %v", err)
 		}
-		resp.Body.Close()
+		for _, name := range opts.Alerts.Notifiers() {
+			if _, ok := router.Lookup(name); !ok {
+				log.Fatalf("Адресата уведомлений %s нет в разделе notifiers таблицы маршрутов", name)
+			}
+		}
+		log.Printf("Правила тревог загружены из %s: %d, адресаты уведомлений %v",
+			collectorConfig.AlertingFile, opts.Alerts.Stats().Rules, opts.Alerts.Notifiers())
+	}
+	c := collector.NewCollector(router, outbox, opts)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Маршруты с резервным адресатом dead_letter публикуют показания в
+	// очередь недоставленных, пока адреса отключены автоматом
+	deadLetters := services.NewExchangePublisher(rabbitConfig, services.CollectorTopology(), services.DeadLetterExchange, "")
+	go deadLetters.Run(ctx)
+	router.SetDeadLetter(func(msg collector.Message) error {
+		return deadLetters.Publish(amqp.Publishing{
+			ContentType: msg.ContentType,
+			MessageId:   msg.ID,
+			Headers: amqp.Table{
+				services.HeaderDeadLetterReason: services.DeadLetterCircuitOpen,
+				services.HeaderDeadLetterError:  msg.Error,
+				services.HeaderRoute:            msg.Route,
+			},
+			Timestamp: time.Now(),
+			Body:      msg.Body,
+		})
+	})
+
+	// Потребитель сам переподключается к RabbitMQ и заново объявляет
+	// очередь показаний и очередь недоставленных
+	consumer := services.NewConsumer(rabbitConfig, services.CollectorTopology(), services.SensorDataQueue, collectorConfig.Prefetch)
+
+	// GET /status, GET /metrics и запросы к истории, кэшу, карантину и тревогам
+	if collectorConfig.Port > 0 {
+		api := collector.NewAPI(c, consumer, collector.APIOptions{
+			Repository: repo,
+			History:    history,
+			Cache:      cache,
+			TSDB:       tsdb,
+		})
+		go serveStatus(ctx, collectorConfig.Port, api)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.Run(ctx)
+	}()
+	if history != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			history.Run(ctx)
+		}()
+	}
+	if cache != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Run(ctx)
+		}()
+	}
+	if tsdb != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tsdb.Run(ctx)
+		}()
+	}
+
+	log.Println("Коллектор запущен. Ожидание сообщений...")
+	consumer.Run(ctx, collector.NewDeliveryHandler(c, collectorConfig.MaxRetries).Handle)
+	wg.Wait()
+	log.Println("Коллектор остановлен")
+}
+
+// serveStatus обслуживает API состояния коллектора до отмены ctx
+func serveStatus(ctx context.Context, port int, api *collector.API) {
+	r := gin.Default()
+	api.Register(r)
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: r}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
 
-		log.Printf("Данные успешно отправлены на %s", endpoint)
+	log.Printf("Состояние коллектора на порту %d", port)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Ошибка сервера состояния: %v", err)
 	}
 }
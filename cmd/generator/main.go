@@ -2,89 +2,227 @@ package main
 
 import (
 	"big_go/config"
+	"big_go/internal/codec"
+	"big_go/internal/services"
 	"big_go/internal/services/generator"
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
-	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/streadway/amqp"
 )
 
 func main() {
 
-	// Инициализация конфигурации RabbitMQ
-	rabbitConfig, err := config.LoadRabbitMQConfig("config_rabbitmq.json")
-	if err != nil {
-		log.Fatalf("Ошибка загрузки конфигурации RabbitMQ: %v", err)
+	// Инициализация конфигурации: значения по умолчанию < файлы < окружение < флаги
+	rabbitConfig := &config.RabbitMQConfig{}
+	generatorConfig := &config.GeneratorConfig{}
+	loader := config.NewLoader("generator")
+	loader.Add("rabbitmq", rabbitConfig, "config_rabbitmq.json")
+	loader.Add("generator", generatorConfig, "config_generator.json")
+	if err := loader.Load(os.Args[1:]); err != nil {
+		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+	loader.LogReport()
+
+	// Публикатор с подтверждениями брокера: неподтвержденные сообщения
+	// хранятся в буфере и отправляются повторно после переподключения
+	publisher := services.NewPublisher(rabbitConfig, services.SensorDataTopology(), services.SensorDataQueue)
+	pubCtx, stopPublisher := context.WithCancel(context.Background())
+	defer stopPublisher()
+	go publisher.Run(pubCtx)
+
+	// publish помещает сообщение в буфер публикатора; неисправности отмечены в заголовках
+	publish := func(msg generator.Message) error {
+		return publisher.Publish(amqp.Publishing{
+			ContentType: msg.ContentType,
+			MessageId:   msg.ID,
+			Headers:     amqp.Table(msg.Headers),
+			Body:        msg.Body,
+		})
 	}
 
-	// Подключение к RabbitMQ
-	conn, err := amqp.Dial(
-		"amqp://" + rabbitConfig.User + ":" + rabbitConfig.Password +
-			"@" + rabbitConfig.Host + ":" + fmt.Sprintf("%d", rabbitConfig.Port) + "/" + rabbitConfig.VHost,
-	)
+	// Режим повторной публикации записанного потока: запись публикуется
+	// целиком, поэтому при заполненном буфере публикация ждет подтверждений
+	if generatorConfig.Replay != "" {
+		var renewer *generator.IDRenewer
+		if generatorConfig.ReplayNewIDs {
+			renewer = generator.NewIDRenewer()
+			log.Printf("Показаниям записи назначаются новые идентификаторы")
+		}
+		replay(generatorConfig.Replay, generatorConfig.ReplaySpeed, func(msg generator.Message) error {
+			if renewer != nil {
+				var err error
+				if msg, err = renewer.Renew(msg); err != nil {
+					return err
+				}
+			}
+			return publisher.PublishWait(pubCtx, amqp.Publishing{
+				ContentType: msg.ContentType,
+				MessageId:   msg.ID,
+				Headers:     amqp.Table(msg.Headers),
+				Body:        msg.Body,
+			})
+		})
+		flush(publisher)
+		return
+	}
+
+	// Загрузка топологии постов
+	topology, err := generatorConfig.LoadTopology()
 	if err != nil {
-		log.Fatalf("Ошибка подключения к RabbitMQ: %v", err)
+		log.Fatalf("Ошибка загрузки топологии: %v", err)
 	}
-	defer conn.Close()
+	log.Printf("Топология: %d адресов, %d постов", len(topology.Addresses), topology.PostCount())
 
-	// Создание канала
-	ch, err := conn.Channel()
+	// Инициализация генератора данных
+	gen, err := generator.NewGenerator(topology, generator.Options{
+		Seed:      generatorConfig.Seed,
+		StartTime: generatorConfig.StartTime,
+	})
 	if err != nil {
-		log.Fatalf("Ошибка создания канала: %v", err)
-	}
-	defer ch.Close()
-
-	// Объявление очереди
-	q, err := ch.QueueDeclare(
-		"sensor_data", // имя очереди
-		true,          // durable
-		false,         // delete when unused
-		false,         // exclusive
-		false,         // no-wait
-		nil,           // arguments
-	)
+		log.Fatalf("Ошибка инициализации генератора: %v", err)
+	}
+	log.Printf("Зерно генерации: %d (повтор запуска: -seed %d)", gen.Seed(), gen.Seed())
+	// Параметры неисправностей нужны и сценариям API управления
+	gen.ConfigureFaults(generatorConfig.Faults)
+	if generatorConfig.Faults.Enabled {
+		log.Printf("Включено внесение неисправностей: %+v", generatorConfig.Faults)
+	}
+
+	// Формат тела сообщений
+	bodyCodec, err := codec.ForContentType(generatorConfig.ContentType)
 	if err != nil {
-		log.Fatalf("Ошибка объявления очереди: %v", err)
+		log.Fatalf("Ошибка выбора формата сообщений: %v (поддерживаются %v)", err, codec.ContentTypes())
 	}
+	gen.SetCodec(bodyCodec)
 
-	// Инициализация генератора данных
-	gen := generator.NewGenerator()
+	// Запись опубликованного потока
+	var recorder *generator.Recorder
+	if generatorConfig.Record != "" {
+		file, err := os.Create(generatorConfig.Record)
+		if err != nil {
+			log.Fatalf("Ошибка создания файла записи: %v", err)
+		}
+		defer file.Close()
+		recorder = generator.NewRecorder(file)
+		log.Printf("Запись потока в %s", generatorConfig.Record)
+	}
 
-	// Запуск генерации данных
-	for {
-		// Генерация случайного интервала от 1 до 5 секунд
-		interval := time.Duration(rand.Intn(4)+1) * time.Second
+	// Запуск постов: каждый пост работает в своей горутине
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		// Генерация данных
-		data := gen.GenerateData()
+	if err := generatorConfig.Rate.Validate(); err != nil {
+		log.Fatalf("Ошибка параметров темпа: %v", err)
+	}
+	scheduler := generator.NewScheduler(gen, generatorConfig.Rate)
+	if generatorConfig.Rate.Target > 0 {
+		log.Printf("Целевой темп %.1f сообщений/с, интервалы постов ускорены в %.2f раза",
+			generatorConfig.Rate.Target, scheduler.Scale())
+	}
+	emissions := make(chan generator.Emission, 1000)
+	go scheduler.Run(ctx, emissions)
 
-		// Сериализация данных в JSON
-		jsonData, err := json.Marshal(data)
-		if err != nil {
-			log.Printf("Ошибка сериализации данных: %v", err)
+	// API управления работающим генератором
+	if generatorConfig.ControlPort > 0 {
+		controller := generator.NewController(scheduler, generatorConfig)
+		controller.AddStatus("publisher", func() interface{} { return publisher.Stats() })
+		go serveControl(ctx, generatorConfig.ControlPort, controller)
+	}
+
+	// Публикация показаний постов
+	for e := range emissions {
+		if e.Err != nil {
+			log.Printf("Ошибка сериализации данных: %v", e.Err)
+			continue
+		}
+		if len(e.Messages) == 0 {
+			log.Printf("Пропуск показания поста %d адреса %d (%s)", e.Post.PostID, e.Post.Address, generator.FaultDropout)
 			continue
 		}
 
-		// Публикация сообщения
-		err = ch.Publish(
-			"",     // exchange
-			q.Name, // routing key
-			false,  // mandatory
-			false,  // immediate
-			amqp.Publishing{
-				ContentType: "application/json",
-				Body:        jsonData,
-			})
-		if err != nil {
-			log.Printf("Ошибка публикации сообщения: %v", err)
-		} else {
-			log.Printf("Отправлено сообщение: %s", string(jsonData))
+		for _, msg := range e.Messages {
+			err := publish(msg)
+			scheduler.Published(err)
+			if err != nil {
+				log.Printf("Ошибка публикации сообщения: %v", err)
+				continue
+			}
+			if faults := msg.Faults(); len(faults) > 0 {
+				log.Printf("Отправлено сообщение с неисправностями %v: %s", faults, describe(msg))
+			} else {
+				log.Printf("Отправлено сообщение: %s", describe(msg))
+			}
+
+			if recorder != nil {
+				if err := recorder.Write(msg, time.Now()); err != nil {
+					log.Printf("Ошибка записи потока: %v", err)
+				}
+			}
 		}
+	}
+
+	flush(publisher)
+	log.Println("Генератор остановлен")
+}
+
+// flush ожидает подтверждения сообщений, оставшихся в буфере публикатора
+func flush(publisher *services.Publisher) {
+	if left := publisher.Flush(10 * time.Second); left > 0 {
+		log.Printf("Не подтверждено брокером %d сообщений", left)
+	}
+	stats := publisher.Stats()
+	log.Printf("Публикация: принято %d, подтверждено %d, отклонено %d, переподключений %d",
+		stats.Accepted, stats.Confirmed, stats.Rejected, stats.Reconnects)
+}
+
+// serveControl обслуживает API управления до отмены ctx
+func serveControl(ctx context.Context, port int, controller *generator.Controller) {
+	r := gin.Default()
+	controller.Register(r)
 
-		// Ожидание перед следующей генерацией
-		time.Sleep(interval)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: r}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("API управления генератором на порту %d", port)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Ошибка API управления: %v", err)
+	}
+}
+
+// describe возвращает описание сообщения для лога: тело JSON как есть,
+// для двоичных форматов - размер и содержимое показания
+func describe(msg generator.Message) string {
+	if msg.ContentType == codec.ContentTypeJSON {
+		return string(msg.Body)
+	}
+	return fmt.Sprintf("%s, %d байт: %+v", msg.ContentType, len(msg.Body), msg.Data)
+}
+
+// replay республикует записанный поток и завершает работу
+func replay(filename string, speed float64, publish func(generator.Message) error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("Ошибка открытия записи: %v", err)
+	}
+	defer file.Close()
+
+	log.Printf("Повторная публикация %s, скорость %gx (0 - без пауз)", filename, speed)
+	count, err := generator.Replay(context.Background(), file, speed, publish)
+	if err != nil {
+		log.Fatalf("Ошибка повторной публикации после %d сообщений: %v", count, err)
 	}
+	log.Printf("Повторная публикация завершена: %d сообщений", count)
 }
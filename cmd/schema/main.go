@@ -0,0 +1,29 @@
+package main
+
+import (
+	"big_go/internal/models"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+)
+
+// Выводит JSON Schema сообщений текущей версии, построенную по типам Go
+func main() {
+	output := flag.String("o", "", "файл для записи схемы (по умолчанию - стандартный вывод)")
+	flag.Parse()
+
+	schema, err := json.MarshalIndent(models.SensorDataSchema(), "", "  ")
+	if err != nil {
+		log.Fatalf("Ошибка сериализации схемы: %v", err)
+	}
+	schema = append(schema, '\n')
+
+	if *output == "" {
+		os.Stdout.Write(schema)
+		return
+	}
+	if err := os.WriteFile(*output, schema, 0644); err != nil {
+		log.Fatalf("Ошибка записи схемы: %v", err)
+	}
+}
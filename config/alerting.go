@@ -0,0 +1,162 @@
+// config/alerting.go
+package config
+
+import (
+	"big_go/internal/filter"
+	"fmt"
+	"time"
+)
+
+// Виды условий правила тревоги
+const (
+	AlertThreshold = "threshold" // значение метрики за границей
+	AlertRate      = "rate"      // скорость изменения метрики (в единицах в минуту) за границей
+)
+
+// Области правила тревоги: для каждой ведется отдельная тревога
+const (
+	AlertScopePost      = "post"      // каждый пост
+	AlertScopeAddress   = "address"   // каждый адрес получателя (все его посты)
+	AlertScopeRecipient = "recipient" // каждый получатель (все его посты)
+)
+
+// DefaultAlertStale - сколько пост может не присылать метрику правила,
+// прежде чем его условие снимется, если в правиле не задано stale
+const DefaultAlertStale = 10 * time.Minute
+
+// NotifyRecipient - в списке уведомлений означает маршрут получателя
+// показаний, то есть его панель
+const NotifyRecipient = "recipient"
+
+// AlertingConfig описывает тревоги коллектора. Правила проверяются на
+// каждом принятом показании; уведомления о срабатывании и снятии тревоги
+// отправляются адресатам из раздела notifiers таблицы маршрутов или
+// получателю показаний (NotifyRecipient).
+type AlertingConfig struct {
+	Notify []string    `json:"notify"` // уведомления для правил без собственного списка
+	Rules  []AlertRule `json:"rules"`
+}
+
+// AlertRule - правило тревоги. Условие выполняется для поста, если
+// значение (threshold) или скорость его изменения за Window (rate) больше
+// Above или меньше Below; условие области выполняется, если оно выполняется
+// хотя бы для одного ее поста. Выполнившееся условие снимается, только
+// когда значение вернется за границу на Hysteresis. Тревога срабатывает,
+// когда условие выполняется непрерывно в течение For (до этого она
+// ожидает - pending). Условие поста, не присылавшего метрику дольше Stale,
+// снимается.
+type AlertRule struct {
+	Name       string   `json:"name"`
+	Metric     string   `json:"metric"`
+	Kind       string   `json:"kind"`       // AlertThreshold (по умолчанию) или AlertRate
+	Scope      string   `json:"scope"`      // AlertScope*, по умолчанию post
+	When       string   `json:"when"`       // какие показания учитываются (см. internal/filter); пусто - все
+	Above      *float64 `json:"above"`      // верхняя граница
+	Below      *float64 `json:"below"`      // нижняя граница
+	Hysteresis float64  `json:"hysteresis"` // на сколько значение должно вернуться за границу, чтобы условие снялось
+	Window     Duration `json:"window"`     // окно скорости изменения (только rate)
+	For        Duration `json:"for"`        // сколько условие должно выполняться до срабатывания (0 - сразу)
+	Stale      Duration `json:"stale"`      // через сколько без показаний условие поста снимается; по умолчанию DefaultAlertStale, но не меньше Window
+	Severity   string   `json:"severity"`   // например warning или critical; передается в уведомлении
+	Notify     []string `json:"notify"`     // адресаты уведомлений; пусто - AlertingConfig.Notify
+}
+
+// LoadAlerting загружает правила тревог из файла (JSON, YAML или TOML),
+// заполняет значения по умолчанию и проверяет их
+func LoadAlerting(filename string) (*AlertingConfig, error) {
+	cfg := &AlertingConfig{}
+	if err := DecodeFile(filename, cfg); err != nil {
+		return nil, err
+	}
+	cfg.Normalize()
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid alerting rules %s: %v", filename, err)
+	}
+	return cfg, nil
+}
+
+// Normalize задает вид, область и срок устаревания правил по умолчанию и
+// переносит в правила общий список уведомлений
+func (c *AlertingConfig) Normalize() {
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if rule.Kind == "" {
+			rule.Kind = AlertThreshold
+		}
+		if rule.Scope == "" {
+			rule.Scope = AlertScopePost
+		}
+		if len(rule.Notify) == 0 {
+			rule.Notify = c.Notify
+		}
+		if rule.Stale.Duration == 0 {
+			rule.Stale.Duration = DefaultAlertStale
+			if rule.Kind == AlertRate && rule.Window.Duration > rule.Stale.Duration {
+				rule.Stale.Duration = rule.Window.Duration
+			}
+		}
+	}
+}
+
+// Validate проверяет правила
+func (c *AlertingConfig) Validate() error {
+	seen := make(map[string]bool, len(c.Rules))
+	for i, rule := range c.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d: name is required", i+1)
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("rule %s: duplicate name", rule.Name)
+		}
+		seen[rule.Name] = true
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r AlertRule) validate() error {
+	if r.Metric == "" {
+		return fmt.Errorf("metric is required")
+	}
+	switch r.Kind {
+	case AlertThreshold:
+	case AlertRate:
+		if r.Window.Duration <= 0 {
+			return fmt.Errorf("kind %s requires a positive window", AlertRate)
+		}
+	default:
+		return fmt.Errorf("kind must be %s or %s, got %q", AlertThreshold, AlertRate, r.Kind)
+	}
+	switch r.Scope {
+	case AlertScopePost, AlertScopeAddress, AlertScopeRecipient:
+	default:
+		return fmt.Errorf("scope must be one of %s, %s, %s, got %q",
+			AlertScopePost, AlertScopeAddress, AlertScopeRecipient, r.Scope)
+	}
+	if r.Above == nil && r.Below == nil {
+		return fmt.Errorf("above or below is required")
+	}
+	if r.Above != nil && r.Below != nil && *r.Below > *r.Above {
+		return fmt.Errorf("below %g is greater than above %g", *r.Below, *r.Above)
+	}
+	if r.Hysteresis < 0 || r.For.Duration < 0 || r.Stale.Duration < 0 {
+		return fmt.Errorf("hysteresis, for and stale must not be negative")
+	}
+	// Иначе выполнившееся условие с одной стороны сразу выполнялось бы и
+	// с другой и не снималось бы никогда
+	if r.Above != nil && r.Below != nil && r.Hysteresis >= (*r.Above-*r.Below)/2 {
+		return fmt.Errorf("hysteresis %g must be less than half of the range between below %g and above %g",
+			r.Hysteresis, *r.Below, *r.Above)
+	}
+	if r.When != "" {
+		if _, err := filter.Compile(r.When); err != nil {
+			return fmt.Errorf("condition %q: %v", r.When, err)
+		}
+	}
+	if len(r.Notify) == 0 {
+		return fmt.Errorf("no notifiers")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// config/collector.go
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// CollectorConfig contains configuration data for the collector service
+type CollectorConfig struct {
+	// Port of the status and metrics HTTP endpoint (0 - disabled)
+	Port int `json:"port" env:"COLLECTOR_PORT" flag:"port" default:"8081"`
+
+	// Preferred body content type per route (recipient or routing rule name),
+	// e.g. {"User2": "application/msgpack"}; routes not listed receive DefaultContentType
+	ContentTypes       map[string]string `json:"content_types" env:"COLLECTOR_CONTENT_TYPES"`
+	DefaultContentType string            `json:"default_content_type" env:"COLLECTOR_DEFAULT_CONTENT_TYPE" flag:"content-type" default:"application/json"`
+
+	// Messages whose ID was seen within DedupWindow are dropped; at most
+	// DedupCapacity IDs are remembered (0 - unlimited)
+	DedupWindow   Duration `json:"dedup_window" env:"COLLECTOR_DEDUP_WINDOW" flag:"dedup-window" default:"10m"`
+	DedupCapacity int      `json:"dedup_capacity" env:"COLLECTOR_DEDUP_CAPACITY" flag:"dedup-capacity" default:"100000"`
+
+	// Unacknowledged deliveries per consumer (QoS prefetch) and the number of
+	// times a failed delivery is requeued before it is dead-lettered
+	Prefetch   int `json:"prefetch" env:"COLLECTOR_PREFETCH" flag:"prefetch" default:"32"`
+	MaxRetries int `json:"max_retries" env:"COLLECTOR_MAX_RETRIES" flag:"max-retries" default:"5"`
+
+	// Routing table file (see RoutingTable); it is re-read when modified,
+	// checked every RoutingReload (0 - only on SIGHUP). The post registry
+	// of the validation rules (ValidationConfig.TopologyFile) is re-read
+	// the same way.
+	RoutingFile   string   `json:"routing_file" env:"COLLECTOR_ROUTING_FILE" flag:"routing" default:"config_routing.json"`
+	RoutingReload Duration `json:"routing_reload" env:"COLLECTOR_ROUTING_RELOAD" flag:"routing-reload" default:"5s"`
+
+	// Validation rules file (see ValidationConfig; "" - no validation).
+	// Readings that fail validation and messages that fail the schema check
+	// are kept in QuarantineFile until an operator releases or discards
+	// them; at most QuarantineCapacity are kept, the oldest are dropped first
+	ValidationFile     string `json:"validation_file" env:"COLLECTOR_VALIDATION_FILE" flag:"validation" default:"config_validation.json"`
+	QuarantineFile     string `json:"quarantine_file" env:"COLLECTOR_QUARANTINE_FILE" flag:"quarantine" default:"data/quarantine.jsonl"`
+	QuarantineCapacity int    `json:"quarantine_capacity" env:"COLLECTOR_QUARANTINE_CAPACITY" flag:"quarantine-capacity" default:"10000"`
+
+	// Tumbling windows of per-post and per-address rollups (empty - no
+	// rollups). A window closes RollupLateness after its end by reading
+	// time; at most RollupMaxSeries windows are open at once, the least
+	// recently updated one is closed early when the limit is reached
+	RollupWindows   []string `json:"rollup_windows" env:"COLLECTOR_ROLLUP_WINDOWS" flag:"rollup-windows" default:"1m,5m,1h"`
+	RollupLateness  Duration `json:"rollup_lateness" env:"COLLECTOR_ROLLUP_LATENESS" flag:"rollup-lateness" default:"30s"`
+	RollupMaxSeries int      `json:"rollup_max_series" env:"COLLECTOR_ROLLUP_MAX_SERIES" flag:"rollup-max-series" default:"10000"`
+
+	// Alert rules evaluated on every forwarded reading (empty - no alerts)
+	AlertingFile string `json:"alerting_file" env:"COLLECTOR_ALERTING_FILE" flag:"alerting" default:"config_alerting.json"`
+
+	// Directory of the per-route delivery journals. A failed delivery is
+	// retried with a delay growing from OutboxRetryMin to OutboxRetryMax; a
+	// reading older than OutboxMaxAge or tried OutboxMaxAttempts times is
+	// moved to the route's .failed file (0 - no limit). OutboxSync flushes
+	// the journal to disk on every write.
+	OutboxDir         string   `json:"outbox_dir" env:"COLLECTOR_OUTBOX_DIR" flag:"outbox-dir" default:"data/outbox"`
+	OutboxRetryMin    Duration `json:"outbox_retry_min" env:"COLLECTOR_OUTBOX_RETRY_MIN" flag:"outbox-retry-min" default:"1s"`
+	OutboxRetryMax    Duration `json:"outbox_retry_max" env:"COLLECTOR_OUTBOX_RETRY_MAX" flag:"outbox-retry-max" default:"5m"`
+	OutboxMaxAge      Duration `json:"outbox_max_age" env:"COLLECTOR_OUTBOX_MAX_AGE" flag:"outbox-max-age" default:"24h"`
+	OutboxMaxAttempts int      `json:"outbox_max_attempts" env:"COLLECTOR_OUTBOX_MAX_ATTEMPTS" flag:"outbox-max-attempts" default:"0"`
+	OutboxSync        bool     `json:"outbox_sync" env:"COLLECTOR_OUTBOX_SYNC" flag:"outbox-sync" default:"true"`
+
+	// Every accepted reading is written to PostgreSQL (see PostgresConfig) in
+	// batches of up to HistoryBatchSize, at least every HistoryFlushInterval;
+	// at most HistoryBuffer readings wait while the database is unavailable
+	HistoryEnabled       bool     `json:"history_enabled" env:"COLLECTOR_HISTORY" flag:"history" default:"true"`
+	HistoryBatchSize     int      `json:"history_batch_size" env:"COLLECTOR_HISTORY_BATCH_SIZE" flag:"history-batch-size" default:"500"`
+	HistoryFlushInterval Duration `json:"history_flush_interval" env:"COLLECTOR_HISTORY_FLUSH_INTERVAL" flag:"history-flush-interval" default:"1s"`
+	HistoryBuffer        int      `json:"history_buffer" env:"COLLECTOR_HISTORY_BUFFER" flag:"history-buffer" default:"100000"`
+
+	// Every accepted reading updates the latest values, recipient history and
+	// notification channels in Redis (see RedisConfig); at most CacheBuffer
+	// readings wait while Redis is unavailable
+	CacheEnabled bool `json:"cache_enabled" env:"COLLECTOR_CACHE" flag:"cache" default:"true"`
+	CacheBuffer  int  `json:"cache_buffer" env:"COLLECTOR_CACHE_BUFFER" flag:"cache-buffer" default:"10000"`
+
+	// Every measurement of an accepted reading is written to OpenTSDB (see
+	// OpentsdbConfig) in batches of up to TSDBBatchSize data points, at least
+	// every TSDBFlushInterval; at most TSDBBuffer points wait while OpenTSDB
+	// is unavailable
+	TSDBEnabled       bool     `json:"tsdb_enabled" env:"COLLECTOR_TSDB" flag:"tsdb" default:"false"`
+	TSDBBatchSize     int      `json:"tsdb_batch_size" env:"COLLECTOR_TSDB_BATCH_SIZE" flag:"tsdb-batch-size" default:"100"`
+	TSDBFlushInterval Duration `json:"tsdb_flush_interval" env:"COLLECTOR_TSDB_FLUSH_INTERVAL" flag:"tsdb-flush-interval" default:"1s"`
+	TSDBBuffer        int      `json:"tsdb_buffer" env:"COLLECTOR_TSDB_BUFFER" flag:"tsdb-buffer" default:"100000"`
+}
+
+// RollupDurations parses RollupWindows
+func (c *CollectorConfig) RollupDurations() ([]time.Duration, error) {
+	windows := make([]time.Duration, 0, len(c.RollupWindows))
+	for _, w := range c.RollupWindows {
+		d, err := time.ParseDuration(w)
+		if err != nil {
+			return nil, fmt.Errorf("rollup window %q: %v", w, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("rollup window %q must be positive", w)
+		}
+		windows = append(windows, d)
+	}
+	return windows, nil
+}
+
+// ContentType returns the preferred body content type of the route
+func (c *CollectorConfig) ContentType(route string) string {
+	if ct, ok := c.ContentTypes[route]; ok && ct != "" {
+		return ct
+	}
+	return c.DefaultContentType
+}
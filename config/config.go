@@ -7,13 +7,116 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 )
 
-// GeneratorConfig содержит конфигурационные данные для генератора
+// GeneratorConfig содержит конфигурационные данные для генератора.
+// Если задан TopologyFile, посты берутся из него; иначе топология строится
+// из PostNumber, AddressNumber и RecipientNumber.
 type GeneratorConfig struct {
-	PostNumber            int `json:"post_number"`
-	GenerationIntervalMin int `json:"address_numbers"`
-	GenerationIntervalMax int `json:"recipient_numbers"`
+	PostNumber            int         `json:"post_numbers" env:"GENERATOR_POST_NUMBERS" flag:"post-numbers" default:"4"`
+	AddressNumber         int         `json:"address_numbers" env:"GENERATOR_ADDRESS_NUMBERS" flag:"address-numbers" default:"10"`
+	RecipientNumber       int         `json:"recipient_numbers" env:"GENERATOR_RECIPIENT_NUMBERS" flag:"recipient-numbers" default:"2"`
+	GenerationIntervalMin Duration    `json:"interval_min" env:"GENERATOR_INTERVAL_MIN" flag:"interval-min" default:"1s"`
+	GenerationIntervalMax Duration    `json:"interval_max" env:"GENERATOR_INTERVAL_MAX" flag:"interval-max" default:"4s"`
+	TopologyFile          string      `json:"topology_file" env:"GENERATOR_TOPOLOGY" flag:"topology"`
+	ContentType           string      `json:"content_type" env:"GENERATOR_CONTENT_TYPE" flag:"content-type" default:"application/json"`
+	Faults                FaultConfig `json:"faults"`
+	Rate                  RateConfig  `json:"rate"`
+
+	// Воспроизводимость: одинаковые seed и start_time дают одинаковую
+	// последовательность сообщений
+	Seed      int64     `json:"seed" env:"GENERATOR_SEED" flag:"seed"`
+	StartTime time.Time `json:"start_time" env:"GENERATOR_START_TIME" flag:"start-time"`
+
+	// Запись опубликованного потока в JSONL и его повторная публикация
+	Record      string  `json:"record" env:"GENERATOR_RECORD" flag:"record"`
+	Replay      string  `json:"replay" env:"GENERATOR_REPLAY" flag:"replay"`
+	ReplaySpeed float64 `json:"replay_speed" env:"GENERATOR_REPLAY_SPEED" flag:"replay-speed" default:"1"`
+	// Назначать показаниям записи новые идентификаторы, иначе коллектор
+	// отбросит повтор как дубликаты, пока они в окне дедупликации
+	ReplayNewIDs bool `json:"replay_new_ids" env:"GENERATOR_REPLAY_NEW_IDS" flag:"replay-new-ids"`
+
+	// HTTP API управления работающим генератором; 0 - отключено
+	ControlPort int `json:"control_port" env:"GENERATOR_CONTROL_PORT" flag:"control-port" default:"8080"`
+	// Именованные сценарии неисправностей, которые можно внести через API
+	// управления, в дополнение к встроенным (по одному на вид неисправности)
+	Scenarios map[string][]ScenarioStep `json:"scenarios" env:"GENERATOR_SCENARIOS"`
+}
+
+// ScenarioStep - шаг сценария: неисправность Fault вносится в Count
+// ближайших показаний поста
+type ScenarioStep struct {
+	Fault string `json:"fault"`
+	Count int    `json:"count"`
+}
+
+// RateConfig управляет общим темпом генерации. Каждый пост публикует
+// показания в своем ритме; если задан Target, интервалы всех постов
+// масштабируются так, чтобы в сумме получить Target сообщений в секунду.
+type RateConfig struct {
+	Target   float64        `json:"target" env:"GENERATOR_RATE" flag:"rate"`
+	Burst    int            `json:"burst" env:"GENERATOR_RATE_BURST" flag:"rate-burst" default:"10"`
+	RampUp   Duration       `json:"ramp_up" env:"GENERATOR_RAMP_UP" flag:"ramp-up"`
+	RampFrom float64        `json:"ramp_from" env:"GENERATOR_RAMP_FROM" flag:"ramp-from" default:"0.1"`
+	Bursts   []BurstProfile `json:"bursts" env:"GENERATOR_BURSTS" flag:"bursts"`
+}
+
+// BurstProfile - периодический всплеск: каждые Every в течение Duration
+// темп умножается на Factor
+type BurstProfile struct {
+	Every    Duration `json:"every"`
+	Duration Duration `json:"duration"`
+	Factor   float64  `json:"factor"`
+}
+
+// Validate проверяет параметры темпа: отрицательные значения и доля
+// разгона вне (0, 1] дали бы отрицательные или бесконечные интервалы
+func (c RateConfig) Validate() error {
+	if c.Target < 0 || c.Burst < 0 || c.RampUp.Duration < 0 {
+		return fmt.Errorf("rate target, burst and ramp_up must not be negative")
+	}
+	if c.RampFrom <= 0 || c.RampFrom > 1 {
+		return fmt.Errorf("ramp_from must be in (0, 1], got %g", c.RampFrom)
+	}
+	for i, b := range c.Bursts {
+		if b.Every.Duration < 0 || b.Duration.Duration < 0 || b.Factor < 0 {
+			return fmt.Errorf("burst %d: every, duration and factor must not be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// FaultConfig задает вероятности (0..1 на одно показание) неисправностей,
+// которые генератор вносит намеренно
+type FaultConfig struct {
+	Enabled         bool     `json:"enabled" env:"GENERATOR_FAULTS" flag:"faults"`
+	Spike           float64  `json:"spike" env:"GENERATOR_FAULT_SPIKE" flag:"fault-spike"`
+	SpikeFactor     float64  `json:"spike_factor" env:"GENERATOR_FAULT_SPIKE_FACTOR" flag:"fault-spike-factor" default:"10"`
+	Stuck           float64  `json:"stuck" env:"GENERATOR_FAULT_STUCK" flag:"fault-stuck"`
+	StuckFor        int      `json:"stuck_for" env:"GENERATOR_FAULT_STUCK_FOR" flag:"fault-stuck-for" default:"10"`
+	Dropout         float64  `json:"dropout" env:"GENERATOR_FAULT_DROPOUT" flag:"fault-dropout"`
+	DropoutFor      int      `json:"dropout_for" env:"GENERATOR_FAULT_DROPOUT_FOR" flag:"fault-dropout-for" default:"5"`
+	Duplicate       float64  `json:"duplicate" env:"GENERATOR_FAULT_DUPLICATE" flag:"fault-duplicate"`
+	OutOfOrder      float64  `json:"out_of_order" env:"GENERATOR_FAULT_OUT_OF_ORDER" flag:"fault-out-of-order"`
+	OutOfOrderShift Duration `json:"out_of_order_shift" env:"GENERATOR_FAULT_OUT_OF_ORDER_SHIFT" flag:"fault-out-of-order-shift" default:"30s"`
+	Future          float64  `json:"future" env:"GENERATOR_FAULT_FUTURE" flag:"fault-future"`
+	FutureShift     Duration `json:"future_shift" env:"GENERATOR_FAULT_FUTURE_SHIFT" flag:"fault-future-shift" default:"1h"`
+	MissingField    float64  `json:"missing_field" env:"GENERATOR_FAULT_MISSING_FIELD" flag:"fault-missing-field"`
+	Malformed       float64  `json:"malformed" env:"GENERATOR_FAULT_MALFORMED" flag:"fault-malformed"`
+}
+
+// LoadTopology возвращает топологию генератора
+func (c *GeneratorConfig) LoadTopology() (*Topology, error) {
+	if c.TopologyFile != "" {
+		return LoadTopology(c.TopologyFile)
+	}
+
+	topology := defaultTopology(c)
+	if err := topology.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid generator config: %v", err)
+	}
+	return topology, nil
 }
 
 // LoadGeneratorConfig загружает конфигурацию генератора из файла
@@ -41,8 +144,9 @@ func InitGeneratorConfig(pathConf string) (*GeneratorConfig, error) {
 		return nil, fmt.Errorf("error loading generator config: %v", err)
 	}
 
-	log.Printf("Generator config: Post Number: %d, Interval Min: %d, Interval Max: %d",
-		generatorConfig.PostNumber, generatorConfig.GenerationIntervalMin, generatorConfig.GenerationIntervalMax)
+	log.Printf("Generator config: Post Number: %d, Address Number: %d, Recipient Number: %d, Interval Min: %s, Interval Max: %s, Topology: %q",
+		generatorConfig.PostNumber, generatorConfig.AddressNumber, generatorConfig.RecipientNumber,
+		generatorConfig.GenerationIntervalMin, generatorConfig.GenerationIntervalMax, generatorConfig.TopologyFile)
 
 	return generatorConfig, nil
 }
@@ -0,0 +1,51 @@
+// config/duration.go
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration - интервал времени, который задается в конфигурации строкой
+// ("500ms", "1s", "5m") или числом секунд
+type Duration struct {
+	time.Duration
+}
+
+// NewDuration оборачивает time.Duration
+func NewDuration(d time.Duration) Duration {
+	return Duration{Duration: d}
+}
+
+// MarshalText реализует encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// UnmarshalText реализует encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// UnmarshalJSON принимает как строку, так и число секунд
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		d.Duration = time.Duration(value * float64(time.Second))
+		return nil
+	case string:
+		return d.UnmarshalText([]byte(value))
+	default:
+		return fmt.Errorf("invalid duration: %s", string(data))
+	}
+}
@@ -0,0 +1,478 @@
+// config/loader.go
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Source обозначает слой конфигурации, из которого взято итоговое значение.
+// Слои применяются в порядке возрастания приоритета:
+// default < file < env < flag
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// Origin описывает происхождение значения одного параметра
+type Origin struct {
+	Source Source
+	Detail string // имя файла, переменной окружения или флага
+}
+
+func (o Origin) String() string {
+	if o.Detail == "" {
+		return string(o.Source)
+	}
+	return fmt.Sprintf("%s %s", o.Source, o.Detail)
+}
+
+// section - зарегистрированный раздел конфигурации
+type section struct {
+	name     string
+	target   reflect.Value
+	files    []string
+	fileFlag *string
+	flags    map[string]string // имя флага -> путь параметра
+}
+
+// Loader собирает конфигурацию из значений по умолчанию, файлов
+// (JSON, YAML, TOML), переменных окружения и флагов командной строки
+// и запоминает, откуда взято каждое итоговое значение.
+//
+// Теги полей, которые понимает загрузчик:
+//
+//	json:"host"               ключ параметра в файле (и в отчете)
+//	env:"RABBITMQ_HOST"       переменная окружения
+//	flag:"rabbitmq-host"      флаг командной строки
+//	default:"localhost"       значение по умолчанию для пустого поля
+//	secret:"true"             значение не выводится в отчет
+type Loader struct {
+	flags    *flag.FlagSet
+	sections []*section
+	origins  map[string]Origin
+	values   map[string]string
+}
+
+// NewLoader создает загрузчик с собственным набором флагов
+func NewLoader(name string) *Loader {
+	return &Loader{
+		flags:   flag.NewFlagSet(name, flag.ExitOnError),
+		origins: make(map[string]Origin),
+		values:  make(map[string]string),
+	}
+}
+
+// FlagSet возвращает набор флагов загрузчика, чтобы сервис мог
+// зарегистрировать собственные флаги до вызова Load
+func (l *Loader) FlagSet() *flag.FlagSet {
+	return l.flags
+}
+
+// Add регистрирует раздел конфигурации. target должен быть указателем на
+// структуру; уже заполненные поля считаются значениями по умолчанию.
+// files - файлы по умолчанию; их можно переопределить флагом
+// -<name>-config или переменной окружения <NAME>_CONFIG.
+func (l *Loader) Add(name string, target interface{}, files ...string) {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("config: section %s: target must be a pointer to struct", name))
+	}
+
+	s := &section{
+		name:   name,
+		target: v.Elem(),
+		files:  files,
+		flags:  make(map[string]string),
+	}
+
+	walkFields(s.target, name, func(path string, f reflect.StructField, fv reflect.Value) {
+		if def, ok := f.Tag.Lookup("default"); ok && fv.IsZero() {
+			if err := setFromString(fv, def); err != nil {
+				panic(fmt.Sprintf("config: bad default for %s: %v", path, err))
+			}
+		}
+		if !fv.IsZero() {
+			l.origins[path] = Origin{Source: SourceDefault}
+		}
+		if name := f.Tag.Get("flag"); name != "" {
+			usage := formatValue(fv)
+			if f.Tag.Get("secret") == "true" {
+				usage = ""
+			}
+			l.flags.String(name, usage, fmt.Sprintf("%s (%s)", path, f.Type))
+			s.flags[name] = path
+		}
+	})
+
+	s.fileFlag = l.flags.String(name+"-config", "",
+		fmt.Sprintf("config file(s) for %s, comma separated (default %q)", name, strings.Join(files, ",")))
+
+	l.sections = append(l.sections, s)
+}
+
+// Load разбирает аргументы командной строки и применяет слои
+// конфигурации ко всем зарегистрированным разделам
+func (l *Loader) Load(args []string) error {
+	if err := l.flags.Parse(args); err != nil {
+		return err
+	}
+
+	set := make(map[string]string)
+	l.flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	for _, s := range l.sections {
+		if err := l.applyFiles(s); err != nil {
+			return err
+		}
+		if err := l.applyEnv(s); err != nil {
+			return err
+		}
+		for name, path := range s.flags {
+			value, ok := set[name]
+			if !ok {
+				continue
+			}
+			fv, _ := lookupField(s.target, s.name, path)
+			if err := setFromString(fv, value); err != nil {
+				return fmt.Errorf("invalid value %q for flag -%s: %v", value, name, err)
+			}
+			l.origins[path] = Origin{Source: SourceFlag, Detail: "-" + name}
+		}
+	}
+
+	for _, s := range l.sections {
+		walkFields(s.target, s.name, func(path string, f reflect.StructField, fv reflect.Value) {
+			if f.Tag.Get("secret") == "true" && !fv.IsZero() {
+				l.values[path] = "******"
+				return
+			}
+			l.values[path] = formatValue(fv)
+		})
+	}
+
+	return nil
+}
+
+// applyFiles накладывает файлы раздела в порядке перечисления
+func (l *Loader) applyFiles(s *section) error {
+	files, explicit := s.files, false
+	if env := os.Getenv(strings.ToUpper(s.name) + "_CONFIG"); env != "" {
+		files, explicit = splitList(env), true
+	}
+	if *s.fileFlag != "" {
+		files, explicit = splitList(*s.fileFlag), true
+	}
+
+	for _, filename := range files {
+		m, err := readFile(filename)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && !explicit {
+				log.Printf("Config file %s not found, skipping", filename)
+				continue
+			}
+			return err
+		}
+		origin := Origin{Source: SourceFile, Detail: filename}
+		if err := l.applyMap(s.target, s.name, m, origin); err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+
+	return nil
+}
+
+// applyMap переносит значения из разобранного файла в поля структуры
+func (l *Loader) applyMap(v reflect.Value, prefix string, m map[string]interface{}, origin Origin) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, ok := fieldKey(f)
+		if !ok {
+			continue
+		}
+		raw, ok := m[key]
+		if !ok {
+			continue
+		}
+		path := prefix + "." + key
+		fv := v.Field(i)
+
+		if nested, isMap := raw.(map[string]interface{}); isMap && isSection(fv) {
+			if err := l.applyMap(fv, path, nested, origin); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if err := json.Unmarshal(data, fv.Addr().Interface()); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		l.origins[path] = origin
+	}
+
+	return nil
+}
+
+// applyEnv применяет переменные окружения, указанные в тегах env
+func (l *Loader) applyEnv(s *section) error {
+	var err error
+	walkFields(s.target, s.name, func(path string, f reflect.StructField, fv reflect.Value) {
+		name := f.Tag.Get("env")
+		if name == "" || err != nil {
+			return
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if e := setFromString(fv, value); e != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", value, name, e)
+			return
+		}
+		l.origins[path] = Origin{Source: SourceEnv, Detail: name}
+	})
+	return err
+}
+
+// Origins возвращает происхождение каждого параметра, у которого есть значение
+func (l *Loader) Origins() map[string]Origin {
+	origins := make(map[string]Origin, len(l.origins))
+	for k, v := range l.origins {
+		origins[k] = v
+	}
+	return origins
+}
+
+// Report возвращает итоговые значения параметров с указанием их источника
+func (l *Loader) Report() string {
+	paths := make([]string, 0, len(l.values))
+	for path := range l.values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, path := range paths {
+		origin, ok := l.origins[path]
+		if !ok {
+			origin = Origin{Source: SourceDefault}
+		}
+		fmt.Fprintf(&b, "%s = %s (%s)\n", path, l.values[path], origin)
+	}
+	return b.String()
+}
+
+// LogReport выводит отчет о конфигурации в лог
+func (l *Loader) LogReport() {
+	for _, line := range strings.Split(strings.TrimSpace(l.Report()), "\n") {
+		log.Printf("Config: %s", line)
+	}
+}
+
+// DecodeFile декодирует файл в v; формат определяется по расширению
+// (.json, .yaml, .yml, .toml)
+func DecodeFile(filename string, v interface{}) error {
+	m, err := readFile(filename)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("could not convert %s: %v", filename, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("could not decode %s: %v", filename, err)
+	}
+	return nil
+}
+
+// readFile читает файл конфигурации в обобщенное представление
+func readFile(filename string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not open config file: %w", err)
+	}
+
+	m := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json", "":
+		err = json.Unmarshal(data, &m)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &m)
+	case ".toml":
+		err = toml.Unmarshal(data, &m)
+	default:
+		return nil, fmt.Errorf("unsupported config format: %s", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decode config %s: %v", filename, err)
+	}
+
+	return m, nil
+}
+
+// walkFields обходит листовые поля структуры, спускаясь во вложенные разделы
+func walkFields(v reflect.Value, prefix string, fn func(path string, f reflect.StructField, fv reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, ok := fieldKey(f)
+		if !ok {
+			continue
+		}
+		path := prefix + "." + key
+		if isSection(v.Field(i)) {
+			walkFields(v.Field(i), path, fn)
+			continue
+		}
+		fn(path, f, v.Field(i))
+	}
+}
+
+// lookupField находит поле по пути параметра
+func lookupField(v reflect.Value, prefix, path string) (reflect.Value, bool) {
+	var found reflect.Value
+	walkFields(v, prefix, func(p string, _ reflect.StructField, fv reflect.Value) {
+		if p == path {
+			found = fv
+		}
+	})
+	return found, found.IsValid()
+}
+
+// fieldKey возвращает ключ поля по тегу json
+func fieldKey(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return strings.ToLower(f.Name), true
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+)
+
+// isSection сообщает, нужно ли обходить поле как вложенный раздел
+func isSection(fv reflect.Value) bool {
+	if fv.Kind() != reflect.Struct {
+		return false
+	}
+	pt := reflect.PtrTo(fv.Type())
+	return !pt.Implements(textUnmarshalerType) && !pt.Implements(jsonUnmarshalerType) &&
+		fv.Type() != reflect.TypeOf(time.Time{})
+}
+
+// setFromString присваивает полю значение из строки (env, флаг, default)
+func setFromString(fv reflect.Value, s string) error {
+	if fv.Addr().Type().Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(s), "[") {
+			fv.Set(reflect.ValueOf(splitList(s)).Convert(fv.Type()))
+			return nil
+		}
+		return json.Unmarshal([]byte(s), fv.Addr().Interface())
+	default:
+		// Составные значения (карты, структуры) задаются в виде JSON
+		return json.Unmarshal([]byte(s), fv.Addr().Interface())
+	}
+
+	return nil
+}
+
+// formatValue возвращает строковое представление значения поля для отчета
+func formatValue(fv reflect.Value) string {
+	if s, ok := fv.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	switch fv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct:
+		data, err := json.Marshal(fv.Interface())
+		if err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprintf("%v", fv.Interface())
+}
+
+// splitList разбивает список через запятую
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
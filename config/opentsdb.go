@@ -1,16 +1,44 @@
 // config/opentsdb.go
 package config
 
+import (
+	"net"
+	"strconv"
+	"time"
+)
+
+// OpenTSDB write protocols
+const (
+	OpentsdbHTTP   = "http"   // POST /api/put with a JSON array of data points
+	OpentsdbTelnet = "telnet" // "put" lines over a TCP connection
+)
+
 // OpentsdbConfig holds configuration for OpenTSDB
 type OpentsdbConfig struct {
-	Host string
-	Port int
+	Host     string `json:"host" env:"OPENTSDB_HOST" flag:"opentsdb-host" default:"localhost"`
+	Port     int    `json:"port" env:"OPENTSDB_PORT" flag:"opentsdb-port" default:"4242"`
+	Protocol string `json:"protocol" env:"OPENTSDB_PROTOCOL" flag:"opentsdb-protocol" default:"http"`
+
+	// Every measurement is written as the metric <MetricPrefix>.<name>,
+	// e.g. big_go.temperature, tagged with address, post and recipient
+	MetricPrefix string `json:"metric_prefix" env:"OPENTSDB_METRIC_PREFIX" flag:"opentsdb-metric-prefix" default:"big_go"`
+
+	// Timeout of an HTTP request or a TCP connect/write
+	Timeout Duration `json:"timeout" env:"OPENTSDB_TIMEOUT" flag:"opentsdb-timeout" default:"10s"`
 }
 
 // NewOpentsdbConfig creates a new instance of OpentsdbConfig with default values
 func NewOpentsdbConfig() *OpentsdbConfig {
 	return &OpentsdbConfig{
-		Host: "localhost",
-		Port: 4242,
+		Host:         "localhost",
+		Port:         4242,
+		Protocol:     OpentsdbHTTP,
+		MetricPrefix: "big_go",
+		Timeout:      NewDuration(10 * time.Second),
 	}
 }
+
+// Addr returns the host:port address of the server
+func (c *OpentsdbConfig) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
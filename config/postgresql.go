@@ -5,15 +5,31 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
 )
 
 type PostgresConfig struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	User     string `json:"user"`
-	Password string `json:"password"`
-	Name     string `json:"name"`
+	Host     string `json:"host" env:"POSTGRES_HOST" flag:"postgres-host" default:"localhost"`
+	Port     int    `json:"port" env:"POSTGRES_PORT" flag:"postgres-port" default:"5432"`
+	User     string `json:"user" env:"POSTGRES_USER" flag:"postgres-user" default:"postgres"`
+	Password string `json:"password" env:"POSTGRES_PASSWORD" flag:"postgres-password" secret:"true"`
+	Name     string `json:"name" env:"POSTGRES_DB" flag:"postgres-db" default:"big_go"`
+	SSLMode  string `json:"sslmode" env:"POSTGRES_SSLMODE" flag:"postgres-sslmode" default:"disable"`
+}
+
+// DSN returns the connection URL for database/sql (lib/pq)
+func (c *PostgresConfig) DSN() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     "/" + c.Name,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+	}
+	return u.String()
 }
 
 func LoadPostgresConfig(filename string) (*PostgresConfig, error) {
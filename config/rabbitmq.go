@@ -4,16 +4,37 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
 )
 
 // RabbitMQConfig contains configuration data for connecting to RabbitMQ
 type RabbitMQConfig struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	User     string `json:"user"`
-	Password string `json:"password"`
-	VHost    string `json:"vhost"`
+	Host     string `json:"host" env:"RABBITMQ_HOST" flag:"rabbitmq-host" default:"localhost"`
+	Port     int    `json:"port" env:"RABBITMQ_PORT" flag:"rabbitmq-port" default:"5672"`
+	User     string `json:"user" env:"RABBITMQ_USER" flag:"rabbitmq-user" default:"guest"`
+	Password string `json:"password" env:"RABBITMQ_PASSWORD" flag:"rabbitmq-password" default:"guest" secret:"true"`
+	VHost    string `json:"vhost" env:"RABBITMQ_VHOST" flag:"rabbitmq-vhost" default:"/"`
+
+	// Reconnection backoff and the size of the buffer of unconfirmed messages
+	ReconnectMin  Duration `json:"reconnect_min" env:"RABBITMQ_RECONNECT_MIN" flag:"rabbitmq-reconnect-min" default:"500ms"`
+	ReconnectMax  Duration `json:"reconnect_max" env:"RABBITMQ_RECONNECT_MAX" flag:"rabbitmq-reconnect-max" default:"30s"`
+	PublishBuffer int      `json:"publish_buffer" env:"RABBITMQ_PUBLISH_BUFFER" flag:"rabbitmq-publish-buffer" default:"10000"`
+}
+
+// URL returns the AMQP connection URL
+func (c *RabbitMQConfig) URL() string {
+	u := url.URL{
+		Scheme: "amqp",
+		User:   url.UserPassword(c.User, c.Password),
+		Host:   net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		// Виртуальный хост экранируется целиком: "/" передается как %2F
+		Path:    "/" + c.VHost,
+		RawPath: "/" + url.PathEscape(c.VHost),
+	}
+	return u.String()
 }
 
 // LoadRabbitMQConfig loads the RabbitMQ configuration from a JSON file
@@ -4,15 +4,29 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"strconv"
 )
 
 // RedisConfig contains configuration data for connecting to Redis
 type RedisConfig struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	Password string `json:"password"`
-	DB       int    `json:"db"`
+	Host     string `json:"host" env:"REDIS_HOST" flag:"redis-host" default:"localhost"`
+	Port     int    `json:"port" env:"REDIS_PORT" flag:"redis-port" default:"6379"`
+	Password string `json:"password" env:"REDIS_PASSWORD" flag:"redis-password" secret:"true"`
+	DB       int    `json:"db" env:"REDIS_DB" flag:"redis-db"`
+
+	// Keys and channels start with KeyPrefix. The latest reading of each post
+	// and address expires after TTL without updates; HistoryLength recent
+	// readings are kept per recipient.
+	KeyPrefix     string   `json:"key_prefix" env:"REDIS_KEY_PREFIX" flag:"redis-key-prefix" default:"big_go"`
+	TTL           Duration `json:"ttl" env:"REDIS_TTL" flag:"redis-ttl" default:"24h"`
+	HistoryLength int      `json:"history_length" env:"REDIS_HISTORY_LENGTH" flag:"redis-history-length" default:"100"`
+}
+
+// Addr returns the host:port address of the server
+func (c *RedisConfig) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
 }
 
 // LoadRedisConfig loads the Redis configuration from a JSON file
@@ -0,0 +1,284 @@
+// config/routing.go
+package config
+
+import (
+	"big_go/internal/filter"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Политики для показаний получателей, которых нет в таблице маршрутов
+const (
+	UnknownReject     = "reject"      // показание отбрасывается
+	UnknownDeadLetter = "dead_letter" // показание помещается в очередь недоставленных
+	UnknownDefault    = "default"     // показание отправляется по маршруту default
+)
+
+// DefaultRouteTimeout - таймаут запроса, если он не задан ни маршрутом, ни таблицей
+const DefaultRouteTimeout = 5 * time.Second
+
+// Параметры автомата отключения по умолчанию
+const (
+	DefaultBreakerFailures = 5
+	DefaultBreakerCooldown = 30 * time.Second
+	DefaultBreakerProbes   = 1
+)
+
+// Адресаты маршрута, кроме адреса http(s)
+const (
+	SinkStdout         = "stdout"      // стандартный вывод
+	SinkFilePrefix     = "file:"       // файл JSON Lines, например file:data/fallback/user2.jsonl
+	SinkMemoryPrefix   = "memory:"     // последние показания в памяти коллектора, например memory:debug
+	FallbackDeadLetter = "dead_letter" // очередь недоставленных, только как резервный адресат
+)
+
+// RoutingTable описывает, куда коллектор пересылает показания получателей
+type RoutingTable struct {
+	Routes  map[string]RouteConfig `json:"routes"`  // получатель -> маршрут
+	Unknown string                 `json:"unknown"` // политика для неизвестных получателей (Unknown*)
+	Default *RouteConfig           `json:"default"` // маршрут для политики default
+	Timeout Duration               `json:"timeout"` // таймаут по умолчанию для всех маршрутов
+	Headers map[string]string      `json:"headers"` // заголовки для всех маршрутов
+	Rules   []RuleConfig           `json:"rules"`   // маршрутизация по содержимому
+	Breaker BreakerConfig          `json:"breaker"` // автоматы отключения адресов
+	// Адресаты уведомлений о тревогах (см. AlertingConfig): имя -> адреса
+	Notifiers map[string]RouteConfig `json:"notifiers"`
+}
+
+// BreakerConfig - параметры автомата отключения, общие для всех адресов:
+// после Failures неудач подряд запросы к адресу не выполняются в течение
+// Cooldown, затем Probes удачных пробных запросов возвращают его в работу
+type BreakerConfig struct {
+	Failures int      `json:"failures"`
+	Cooldown Duration `json:"cooldown"`
+	Probes   int      `json:"probes"`
+}
+
+// RouteConfig описывает маршрут: показание отправляется каждому адресату
+// из Endpoints - на адрес http(s) с таймаутом Timeout и дополнительными
+// заголовками Headers, в файл (file:путь), в стандартный вывод (stdout)
+// или в память (memory:имя). Пока автомат отключения адреса разомкнут,
+// показание направляется резервному адресату Fallback - любому из
+// перечисленных или в очередь недоставленных (dead_letter); без него
+// доставка повторяется.
+type RouteConfig struct {
+	Endpoints []string          `json:"endpoints"`
+	Timeout   Duration          `json:"timeout"`
+	Headers   map[string]string `json:"headers"`
+	Fallback  string            `json:"fallback"`
+}
+
+// RuleConfig - правило маршрутизации по содержимому: показание, для
+// которого истинно выражение When (см. internal/filter), отправляется по
+// маршрутам получателей Targets и на собственные адреса правила Endpoints.
+// Показание, подходящее под несколько правил, получают все их адресаты.
+type RuleConfig struct {
+	Name        string   `json:"name"`
+	When        string   `json:"when"`
+	Targets     []string `json:"targets"`
+	RouteConfig          // собственные адреса, таймаут и заголовки правила
+}
+
+// LoadRoutingTable загружает таблицу маршрутов из файла (JSON, YAML или
+// TOML), заполняет унаследованные поля и проверяет результат
+func LoadRoutingTable(filename string) (*RoutingTable, error) {
+	table := &RoutingTable{}
+	if err := DecodeFile(filename, table); err != nil {
+		return nil, err
+	}
+	table.Normalize()
+	if err := table.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid routing table %s: %v", filename, err)
+	}
+	return table, nil
+}
+
+// Normalize задает политику по умолчанию (reject) и переносит в маршруты
+// общие таймаут и заголовки; заголовки маршрута важнее общих
+func (t *RoutingTable) Normalize() {
+	if t.Unknown == "" {
+		t.Unknown = UnknownReject
+	}
+	if t.Timeout.Duration <= 0 {
+		t.Timeout = NewDuration(DefaultRouteTimeout)
+	}
+	if t.Breaker.Failures <= 0 {
+		t.Breaker.Failures = DefaultBreakerFailures
+	}
+	if t.Breaker.Cooldown.Duration <= 0 {
+		t.Breaker.Cooldown = NewDuration(DefaultBreakerCooldown)
+	}
+	if t.Breaker.Probes <= 0 {
+		t.Breaker.Probes = DefaultBreakerProbes
+	}
+	for name, route := range t.Routes {
+		t.inherit(&route)
+		t.Routes[name] = route
+	}
+	if t.Default != nil {
+		t.inherit(t.Default)
+	}
+	for i := range t.Rules {
+		t.inherit(&t.Rules[i].RouteConfig)
+	}
+	for name, notifier := range t.Notifiers {
+		t.inherit(&notifier)
+		t.Notifiers[name] = notifier
+	}
+}
+
+func (t *RoutingTable) inherit(r *RouteConfig) {
+	if r.Timeout.Duration <= 0 {
+		r.Timeout = t.Timeout
+	}
+	headers := make(map[string]string, len(t.Headers)+len(r.Headers))
+	for k, v := range t.Headers {
+		headers[k] = v
+	}
+	for k, v := range r.Headers {
+		headers[k] = v
+	}
+	r.Headers = headers
+}
+
+// Validate проверяет политику, адреса маршрутов и условия правил
+func (t *RoutingTable) Validate() error {
+	switch t.Unknown {
+	case UnknownReject, UnknownDeadLetter:
+	case UnknownDefault:
+		if t.Default == nil {
+			return fmt.Errorf("unknown policy %q requires a default route", UnknownDefault)
+		}
+	default:
+		return fmt.Errorf("unknown policy must be one of %s, %s, %s, got %q",
+			UnknownReject, UnknownDeadLetter, UnknownDefault, t.Unknown)
+	}
+
+	names := make([]string, 0, len(t.Routes))
+	for name := range t.Routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("route with empty recipient")
+		}
+		if err := t.Routes[name].validate(); err != nil {
+			return fmt.Errorf("route %s: %v", name, err)
+		}
+	}
+	if t.Default != nil {
+		if err := t.Default.validate(); err != nil {
+			return fmt.Errorf("default route: %v", err)
+		}
+	}
+
+	seen := make(map[string]bool, len(t.Rules))
+	for i, rule := range t.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d: name is required", i+1)
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("rule %s: duplicate name", rule.Name)
+		}
+		seen[rule.Name] = true
+		if err := t.validateRule(rule); err != nil {
+			return fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+	}
+
+	// Уведомления доставляются через outbox по имени адресата, поэтому
+	// имя не должно совпадать с маршрутом получателя или правила
+	names = names[:0]
+	for name := range t.Notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, route := t.Routes[name]
+		switch {
+		case name == "":
+			return fmt.Errorf("notifier with empty name")
+		case name == NotifyRecipient || name == "default":
+			return fmt.Errorf("notifier %s: reserved name", name)
+		case route || seen[name]:
+			return fmt.Errorf("notifier %s: name is already used by a route or rule", name)
+		}
+		if err := t.Notifiers[name].validate(); err != nil {
+			return fmt.Errorf("notifier %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (t *RoutingTable) validateRule(rule RuleConfig) error {
+	if rule.When == "" {
+		return fmt.Errorf("condition (when) is required")
+	}
+	if _, err := filter.Compile(rule.When); err != nil {
+		return fmt.Errorf("condition %q: %v", rule.When, err)
+	}
+	if len(rule.Targets) == 0 && len(rule.Endpoints) == 0 {
+		return fmt.Errorf("no targets or endpoints")
+	}
+	for _, target := range rule.Targets {
+		if _, ok := t.Routes[target]; !ok {
+			return fmt.Errorf("target %s is not in routes", target)
+		}
+	}
+	if len(rule.Endpoints) > 0 {
+		// Собственные адреса правила - маршрут с именем правила: его очередь
+		// outbox и счетчики совпали бы с маршрутом получателя или default
+		if _, ok := t.Routes[rule.Name]; ok || rule.Name == "default" {
+			return fmt.Errorf("rule with endpoints must not be named after a route or %q", "default")
+		}
+		return rule.RouteConfig.validate()
+	}
+	return nil
+}
+
+func (r RouteConfig) validate() error {
+	if len(r.Endpoints) == 0 {
+		return fmt.Errorf("no endpoints")
+	}
+	for _, endpoint := range r.Endpoints {
+		if endpoint == FallbackDeadLetter {
+			return fmt.Errorf("endpoint %q: only allowed as a fallback", endpoint)
+		}
+		if err := validateSink(endpoint); err != nil {
+			return fmt.Errorf("endpoint %v", err)
+		}
+	}
+	if r.Fallback != "" && r.Fallback != FallbackDeadLetter {
+		if err := validateSink(r.Fallback); err != nil {
+			return fmt.Errorf("fallback %v", err)
+		}
+	}
+	return nil
+}
+
+func validateSink(target string) error {
+	for _, prefix := range []string{SinkFilePrefix, SinkMemoryPrefix} {
+		if strings.HasPrefix(target, prefix) {
+			if strings.TrimPrefix(target, prefix) == "" {
+				return fmt.Errorf("%q: name expected after %s", target, prefix)
+			}
+			return nil
+		}
+	}
+	if target == SinkStdout {
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("%q: %v", target, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q: absolute http(s) URL, %s, %spath or %sname expected",
+			target, SinkStdout, SinkFilePrefix, SinkMemoryPrefix)
+	}
+	return nil
+}
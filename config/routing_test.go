@@ -0,0 +1,49 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoutingTableValidateRuleNames(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RuleConfig
+		want string // пусто - таблица корректна
+	}{
+		{
+			name: "own name",
+			rule: RuleConfig{Name: "humid", When: "humidity > 70", RouteConfig: RouteConfig{Endpoints: []string{"stdout"}}},
+		},
+		{
+			name: "route name with targets only",
+			rule: RuleConfig{Name: "user1", When: "humidity > 70", Targets: []string{"user1"}},
+		},
+		{
+			name: "route name with endpoints",
+			rule: RuleConfig{Name: "user1", When: "humidity > 70", RouteConfig: RouteConfig{Endpoints: []string{"stdout"}}},
+			want: "rule user1: rule with endpoints must not be named after a route",
+		},
+		{
+			name: "default with endpoints",
+			rule: RuleConfig{Name: "default", When: "humidity > 70", RouteConfig: RouteConfig{Endpoints: []string{"stdout"}}},
+			want: "rule default: rule with endpoints must not be named after a route",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &RoutingTable{
+				Routes: map[string]RouteConfig{"user1": {Endpoints: []string{"http://user1:8081/data"}}},
+				Rules:  []RuleConfig{tt.rule},
+			}
+			table.Normalize()
+			err := table.Validate()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("Validate: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("Validate error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
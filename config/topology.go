@@ -0,0 +1,244 @@
+// config/topology.go
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Topology описывает площадки (адреса) и посты, которые имитирует генератор
+type Topology struct {
+	Interval  Duration                `json:"interval"` // интервал по умолчанию для всех постов
+	Jitter    Duration                `json:"jitter"`   // случайная добавка к интервалу
+	Metrics   []string                `json:"metrics"`  // метрики по умолчанию для всех постов
+	Signals   map[string]SignalConfig `json:"signals"`  // модели сигналов по метрикам
+	Addresses []AddressTopology       `json:"addresses"`
+}
+
+// AddressTopology описывает один адрес и его посты
+type AddressTopology struct {
+	Address   int                     `json:"address"`
+	Recipient string                  `json:"recipient"` // владелец постов адреса по умолчанию
+	Interval  Duration                `json:"interval"`
+	Jitter    Duration                `json:"jitter"`
+	Metrics   []string                `json:"metrics"`
+	Signals   map[string]SignalConfig `json:"signals"`
+	Posts     []PostTopology          `json:"posts"`
+}
+
+// PostTopology описывает один пост; незаданные поля наследуются от адреса
+type PostTopology struct {
+	PostID    int                     `json:"post_id"`
+	Recipient string                  `json:"recipient"`
+	Interval  Duration                `json:"interval"`
+	Jitter    Duration                `json:"jitter"`
+	Metrics   []string                `json:"metrics"`
+	Signals   map[string]SignalConfig `json:"signals"`
+}
+
+// SignalConfig описывает модель временного ряда одной метрики.
+// Поддерживаемые модели:
+//
+//	random_walk  случайное блуждание с шагом step в границах min..max
+//	sine         суточный (period) цикл base ± amplitude с максимумом в момент peak
+//	drift        линейный дрейф от base со скоростью rate единиц в час
+//	gaussian     base с нормальным шумом stddev
+//	correlated   base + factor*(значение метрики source - reference)
+//
+// К любой модели добавляется нормальный шум stddev, а если min < max,
+// результат ограничивается этими границами. Unit задает единицу измерения
+// метрики, если она не входит в число известных.
+type SignalConfig struct {
+	Model     string   `json:"model"`
+	Unit      string   `json:"unit"`
+	Base      float64  `json:"base"`
+	Min       float64  `json:"min"`
+	Max       float64  `json:"max"`
+	Step      float64  `json:"step"`
+	Amplitude float64  `json:"amplitude"`
+	Period    Duration `json:"period"`
+	Peak      Duration `json:"peak"`
+	Rate      float64  `json:"rate"`
+	StdDev    float64  `json:"stddev"`
+	Source    string   `json:"source"`
+	Reference float64  `json:"reference"`
+	Factor    float64  `json:"factor"`
+}
+
+// LoadTopology загружает топологию из файла (JSON, YAML или TOML),
+// заполняет унаследованные поля и проверяет результат
+func LoadTopology(filename string) (*Topology, error) {
+	topology := &Topology{}
+	if err := DecodeFile(filename, topology); err != nil {
+		return nil, err
+	}
+	topology.Normalize()
+	if err := topology.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid topology %s: %v", filename, err)
+	}
+	return topology, nil
+}
+
+// Normalize переносит значения по умолчанию топологии и адресов на посты
+func (t *Topology) Normalize() {
+	for i := range t.Addresses {
+		a := &t.Addresses[i]
+		if a.Interval.Duration == 0 {
+			a.Interval = t.Interval
+		}
+		if a.Jitter.Duration == 0 {
+			a.Jitter = t.Jitter
+		}
+		if len(a.Metrics) == 0 {
+			a.Metrics = t.Metrics
+		}
+		a.Signals = mergeSignals(t.Signals, a.Signals)
+		for j := range a.Posts {
+			p := &a.Posts[j]
+			if p.Recipient == "" {
+				p.Recipient = a.Recipient
+			}
+			if p.Interval.Duration == 0 {
+				p.Interval = a.Interval
+			}
+			if p.Jitter.Duration == 0 {
+				p.Jitter = a.Jitter
+			}
+			if len(p.Metrics) == 0 {
+				p.Metrics = a.Metrics
+			}
+			p.Signals = mergeSignals(a.Signals, p.Signals)
+		}
+	}
+}
+
+// mergeSignals дополняет модели сигналов унаследованными
+func mergeSignals(parent, own map[string]SignalConfig) map[string]SignalConfig {
+	if len(parent) == 0 {
+		return own
+	}
+	merged := make(map[string]SignalConfig, len(parent)+len(own))
+	for metric, signal := range parent {
+		merged[metric] = signal
+	}
+	for metric, signal := range own {
+		merged[metric] = signal
+	}
+	return merged
+}
+
+// Validate проверяет нормализованную топологию
+func (t *Topology) Validate() error {
+	if len(t.Addresses) == 0 {
+		return fmt.Errorf("no addresses defined")
+	}
+
+	addresses := make(map[int]bool)
+	for _, a := range t.Addresses {
+		if addresses[a.Address] {
+			return fmt.Errorf("duplicate address %d", a.Address)
+		}
+		addresses[a.Address] = true
+
+		if len(a.Posts) == 0 {
+			return fmt.Errorf("address %d: no posts defined", a.Address)
+		}
+		posts := make(map[int]bool)
+		for _, p := range a.Posts {
+			if posts[p.PostID] {
+				return fmt.Errorf("address %d: duplicate post %d", a.Address, p.PostID)
+			}
+			posts[p.PostID] = true
+
+			if p.Recipient == "" {
+				return fmt.Errorf("address %d, post %d: recipient is required", a.Address, p.PostID)
+			}
+			if p.Interval.Duration <= 0 {
+				return fmt.Errorf("address %d, post %d: interval must be positive", a.Address, p.PostID)
+			}
+			if p.Jitter.Duration < 0 {
+				return fmt.Errorf("address %d, post %d: jitter must not be negative", a.Address, p.PostID)
+			}
+			if len(p.Metrics) == 0 {
+				return fmt.Errorf("address %d, post %d: no metrics defined", a.Address, p.PostID)
+			}
+			for _, metric := range p.Metrics {
+				signal, ok := p.Signals[metric]
+				if !ok {
+					continue
+				}
+				if err := signal.validate(p.Metrics); err != nil {
+					return fmt.Errorf("address %d, post %d, metric %s: %v", a.Address, p.PostID, metric, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// validate проверяет модель сигнала поста, измеряющего метрики metrics.
+// Модели по умолчанию проверяет генератор
+func (s SignalConfig) validate(metrics []string) error {
+	switch s.Model {
+	case "random_walk":
+		if s.Min >= s.Max {
+			return fmt.Errorf("random_walk requires min < max")
+		}
+		// Блуждание начинается с base, середина диапазона не подставляется
+		if s.Base < s.Min || s.Base > s.Max {
+			return fmt.Errorf("random_walk base %g is outside %g..%g", s.Base, s.Min, s.Max)
+		}
+	case "correlated":
+		if s.Source == "" {
+			return fmt.Errorf("correlated requires source")
+		}
+		for _, m := range metrics {
+			if m == s.Source {
+				return nil
+			}
+		}
+		return fmt.Errorf("correlated source %s is not reported by the post", s.Source)
+	}
+	return nil
+}
+
+// PostCount возвращает общее число постов топологии
+func (t *Topology) PostCount() int {
+	n := 0
+	for _, a := range t.Addresses {
+		n += len(a.Posts)
+	}
+	return n
+}
+
+// defaultTopology строит топологию из старых параметров генератора:
+// address_numbers адресов по post_numbers постов, получатели назначаются
+// по кругу из recipient_numbers пользователей
+func defaultTopology(c *GeneratorConfig) *Topology {
+	topology := &Topology{
+		Interval: c.GenerationIntervalMin,
+		Jitter:   NewDuration(c.GenerationIntervalMax.Duration - c.GenerationIntervalMin.Duration),
+		Metrics:  []string{"temperature", "pressure", "humidity"},
+	}
+	if topology.Interval.Duration <= 0 {
+		topology.Interval = NewDuration(time.Second)
+	}
+	if topology.Jitter.Duration < 0 {
+		topology.Jitter = Duration{}
+	}
+
+	n := 0
+	for address := 1; address <= c.AddressNumber; address++ {
+		a := AddressTopology{Address: address}
+		for post := 1; post <= c.PostNumber; post++ {
+			recipient := fmt.Sprintf("User%d", n%max(c.RecipientNumber, 1)+1)
+			a.Posts = append(a.Posts, PostTopology{PostID: post, Recipient: recipient})
+			n++
+		}
+		topology.Addresses = append(topology.Addresses, a)
+	}
+
+	topology.Normalize()
+	return topology
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopologyValidateSignals(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics []string
+		signal  SignalConfig
+		want    string // пусто - топология корректна
+	}{
+		{
+			name:    "random walk",
+			metrics: []string{"noise"},
+			signal:  SignalConfig{Model: "random_walk", Base: 0, Step: 1, Min: -10, Max: 10},
+		},
+		{
+			name:    "random walk without base",
+			metrics: []string{"noise"},
+			signal:  SignalConfig{Model: "random_walk", Step: 1, Min: 30, Max: 90},
+			want:    "address 1, post 1, metric noise: random_walk base 0 is outside 30..90",
+		},
+		{
+			name:    "random walk without range",
+			metrics: []string{"noise"},
+			signal:  SignalConfig{Model: "random_walk", Base: 50, Step: 1},
+			want:    "random_walk requires min < max",
+		},
+		{
+			name:    "correlated",
+			metrics: []string{"temperature", "noise"},
+			signal:  SignalConfig{Model: "correlated", Source: "temperature", Factor: 2},
+		},
+		{
+			name:    "correlated without source",
+			metrics: []string{"noise"},
+			signal:  SignalConfig{Model: "correlated", Factor: 2},
+			want:    "correlated requires source",
+		},
+		{
+			name:    "correlated source not reported",
+			metrics: []string{"noise"},
+			signal:  SignalConfig{Model: "correlated", Source: "temperature", Factor: 2},
+			want:    "address 1, post 1, metric noise: correlated source temperature is not reported by the post",
+		},
+		{
+			name:    "signal of a metric the post does not report",
+			metrics: []string{"temperature"},
+			signal:  SignalConfig{Model: "correlated", Source: "humidity"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topology := &Topology{
+				Interval: NewDuration(time.Second),
+				Signals:  map[string]SignalConfig{"noise": tt.signal},
+				Addresses: []AddressTopology{{
+					Address: 1,
+					Posts:   []PostTopology{{PostID: 1, Recipient: "user1", Metrics: tt.metrics}},
+				}},
+			}
+			topology.Normalize()
+			err := topology.Validate()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("Validate: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("Validate error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
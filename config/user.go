@@ -0,0 +1,15 @@
+// config/user.go
+package config
+
+// UserConfig contains configuration data for the user services (User1, User2)
+type UserConfig struct {
+	Name          string `json:"name" env:"USER_NAME" flag:"name"`
+	ServerPort    int    `json:"server_port" env:"USER_PORT" flag:"port"`
+	Templates     string `json:"templates" env:"USER_TEMPLATES" flag:"templates" default:"internal/templates/*.html"`
+	CollectorHost string `json:"collector_host" env:"COLLECTOR_HOST" flag:"collector-host" default:"collector"`
+	CollectorPort int    `json:"collector_port" env:"COLLECTOR_PORT" flag:"collector-port" default:"8081"`
+
+	// Readings whose ID was seen within DedupWindow are acknowledged but not shown again
+	DedupWindow   Duration `json:"dedup_window" env:"USER_DEDUP_WINDOW" flag:"dedup-window" default:"10m"`
+	DedupCapacity int      `json:"dedup_capacity" env:"USER_DEDUP_CAPACITY" flag:"dedup-capacity" default:"10000"`
+}
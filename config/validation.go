@@ -0,0 +1,70 @@
+// config/validation.go
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ValidationConfig описывает проверку показаний в коллекторе. Показание,
+// не прошедшее проверку, не пересылается, а помещается в карантин.
+type ValidationConfig struct {
+	Ranges         map[string]MetricRange `json:"ranges"`          // физически допустимые значения по метрикам; остальные метрики не проверяются
+	TopologyFile   string                 `json:"topology_file"`   // реестр адресов и постов (см. Topology), перечитывается при изменении; пусто - не проверяется
+	CheckRecipient bool                   `json:"check_recipient"` // получатель показания должен совпадать с владельцем поста по реестру
+	MaxAge         Duration               `json:"max_age"`         // насколько время показания может отставать от времени приема (0 - без ограничения)
+	MaxFuture      Duration               `json:"max_future"`      // насколько оно может опережать время приема (0 - без ограничения)
+}
+
+// MetricRange - допустимые значения метрики; незаданная граница не проверяется
+type MetricRange struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+}
+
+// Contains сообщает, входит ли значение в диапазон
+func (r MetricRange) Contains(v float64) bool {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return false
+	}
+	return (r.Min == nil || v >= *r.Min) && (r.Max == nil || v <= *r.Max)
+}
+
+// LoadValidation загружает правила проверки из файла (JSON, YAML или TOML)
+// и проверяет их
+func LoadValidation(filename string) (*ValidationConfig, error) {
+	cfg := &ValidationConfig{}
+	if err := DecodeFile(filename, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid validation rules %s: %v", filename, err)
+	}
+	return cfg, nil
+}
+
+// Validate проверяет диапазоны и ограничения времени
+func (c *ValidationConfig) Validate() error {
+	names := make([]string, 0, len(c.Ranges))
+	for name := range c.Ranges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := c.Ranges[name]
+		if r.Min == nil && r.Max == nil {
+			return fmt.Errorf("range %s: min or max is required", name)
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return fmt.Errorf("range %s: min %g is greater than max %g", name, *r.Min, *r.Max)
+		}
+	}
+	if c.MaxAge.Duration < 0 || c.MaxFuture.Duration < 0 {
+		return fmt.Errorf("max_age and max_future must not be negative")
+	}
+	if c.CheckRecipient && c.TopologyFile == "" {
+		return fmt.Errorf("check_recipient requires topology_file")
+	}
+	return nil
+}
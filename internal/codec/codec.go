@@ -0,0 +1,257 @@
+// Package codec кодирует показания в форматы передачи (JSON, MessagePack,
+// CBOR, Protobuf) и выбирает формат по типу содержимого AMQP и заголовкам
+// HTTP Content-Type и Accept.
+package codec
+
+import (
+	"big_go/internal/models"
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Типы содержимого поддерживаемых кодеков
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeMsgpack  = "application/msgpack"
+	ContentTypeCBOR     = "application/cbor"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Типы содержимого сводок (models.Rollup) и уведомлений о тревогах
+// (models.Alert); они передаются только в JSON и не разбираются кодеками
+// показаний
+const (
+	ContentTypeRollup = "application/vnd.big-go.rollup+json"
+	ContentTypeAlert  = "application/vnd.big-go.alert+json"
+)
+
+// Codec кодирует показания и разбирает их обратно
+type Codec interface {
+	// ContentType возвращает тип содержимого кодека
+	ContentType() string
+	// Encode кодирует models.SensorData или документ сообщения в JSON-модели
+	// (см. Document)
+	Encode(v interface{}) ([]byte, error)
+	// DecodeDocument разбирает тело в документ в JSON-модели
+	DecodeDocument(body []byte) (map[string]interface{}, error)
+}
+
+var (
+	codecs  = map[string]Codec{}
+	aliases = map[string]string{}
+)
+
+// Register регистрирует кодек под его типом содержимого и синонимами
+func Register(c Codec, alias ...string) {
+	codecs[c.ContentType()] = c
+	for _, a := range alias {
+		aliases[a] = c.ContentType()
+	}
+}
+
+func init() {
+	Register(JSON{}, "text/json")
+	Register(newMsgpack(), "application/x-msgpack", "application/vnd.msgpack")
+	Register(newCBOR())
+	Register(Protobuf{}, "application/protobuf", "application/vnd.google.protobuf")
+}
+
+// Default - кодек по умолчанию
+func Default() Codec {
+	return codecs[ContentTypeJSON]
+}
+
+// ContentTypes возвращает типы содержимого зарегистрированных кодеков
+func ContentTypes() []string {
+	types := make([]string, 0, len(codecs))
+	for t := range codecs {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// ForContentType возвращает кодек для типа содержимого; пустой тип означает JSON
+func ForContentType(contentType string) (Codec, error) {
+	if strings.TrimSpace(contentType) == "" {
+		return Default(), nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный тип содержимого %q: %v", contentType, err)
+	}
+	if canonical, ok := aliases[mediaType]; ok {
+		mediaType = canonical
+	}
+	c, ok := codecs[mediaType]
+	if !ok {
+		return nil, fmt.Errorf("неподдерживаемый тип содержимого %q", mediaType)
+	}
+	return c, nil
+}
+
+// Negotiate выбирает кодек по заголовку Accept с учетом весов q.
+// Пустой заголовок, */* и application/* означают кодек по умолчанию;
+// если ни один тип не поддерживается, возвращается ошибка.
+func Negotiate(accept string) (Codec, error) {
+	if strings.TrimSpace(accept) == "" {
+		return Default(), nil
+	}
+
+	type candidate struct {
+		codec Codec
+		q     float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		var c Codec
+		switch mediaType {
+		case "*/*", "application/*":
+			c = Default()
+		default:
+			c, _ = ForContentType(mediaType)
+		}
+		if c != nil {
+			candidates = append(candidates, candidate{codec: c, q: q})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("ни один из типов %q не поддерживается", accept)
+	}
+
+	// При равных весах сохраняется порядок заголовка
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].codec, nil
+}
+
+// Decode разбирает тело с типом содержимого contentType: декодирует его
+// кодеком и передает документ в models.DecodeDocument (преобразование
+// старых версий и проверка схемы)
+func Decode(contentType string, body []byte) (models.SensorData, error) {
+	c, err := ForContentType(contentType)
+	if err != nil {
+		return models.SensorData{}, &models.DecodeError{Reason: models.ReasonMalformed, Problems: []string{err.Error()}}
+	}
+	doc, err := c.DecodeDocument(body)
+	if err != nil {
+		return models.SensorData{}, &models.DecodeError{Reason: models.ReasonMalformed, Problems: []string{err.Error()}}
+	}
+	return models.DecodeDocument(doc)
+}
+
+// Document переводит показание в документ в JSON-модели: объекты -
+// map[string]interface{}, числа - float64, время - строка RFC 3339
+func Document(data models.SensorData) map[string]interface{} {
+	metrics := make(map[string]interface{}, len(data.Data))
+	for name, m := range data.Data {
+		metric := map[string]interface{}{"value": m.Value}
+		if m.Unit != "" {
+			metric["unit"] = m.Unit
+		}
+		if m.Quality != "" {
+			metric["quality"] = m.Quality
+		}
+		metrics[name] = metric
+	}
+	return map[string]interface{}{
+		"schema_version": float64(data.SchemaVersion),
+		"id":             data.ID,
+		"meta": map[string]interface{}{
+			"recipient": data.Meta.Recipient,
+			"post_id":   float64(data.Meta.PostID),
+			"address":   float64(data.Meta.Address),
+			"timestamp": data.Meta.Timestamp.Format(time.RFC3339Nano),
+		},
+		"data": metrics,
+	}
+}
+
+// normalize приводит результат декодирования двоичного формата к JSON-модели
+func normalize(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, bool, string, float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case []byte:
+		return string(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			n, err := normalize(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = n
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			n, err := normalize(item)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = n
+		}
+		return out, nil
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			key, ok := k.(string)
+			if !ok {
+				if b, isBytes := k.([]byte); isBytes {
+					key = string(b)
+				} else {
+					return nil, fmt.Errorf("ключ объекта %v не является строкой", k)
+				}
+			}
+			n, err := normalize(item)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = n
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("неподдерживаемое значение %T", v)
+}
+
+// document проверяет, что декодированное значение - объект, и нормализует его
+func document(v interface{}) (map[string]interface{}, error) {
+	n, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := n.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("тело не является объектом")
+	}
+	return doc, nil
+}
@@ -0,0 +1,78 @@
+package codec
+
+import (
+	"big_go/internal/models"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	ugorji "github.com/ugorji/go/codec"
+)
+
+// JSON - кодек по умолчанию
+type JSON struct{}
+
+func (JSON) ContentType() string { return ContentTypeJSON }
+
+func (JSON) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSON) DecodeDocument(body []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("тело не является объектом")
+	}
+	return doc, nil
+}
+
+// ugorjiCodec - кодек на основе github.com/ugorji/go/codec (MessagePack, CBOR).
+// Поля структур берутся по тегам json.
+type ugorjiCodec struct {
+	contentType string
+	handle      ugorji.Handle
+	document    bool // кодировать показание через Document
+}
+
+var mapType = reflect.TypeOf(map[string]interface{}(nil))
+
+func newMsgpack() *ugorjiCodec {
+	h := &ugorji.MsgpackHandle{WriteExt: true}
+	h.RawToString = true
+	h.MapType = mapType
+	h.TypeInfos = ugorji.NewTypeInfos([]string{"json"})
+	return &ugorjiCodec{contentType: ContentTypeMsgpack, handle: h}
+}
+
+func newCBOR() *ugorjiCodec {
+	h := &ugorji.CborHandle{}
+	h.MapType = mapType
+	h.TypeInfos = ugorji.NewTypeInfos([]string{"json"})
+	// Время в CBOR (теги 0 и 1) при разборе округляется до микросекунд,
+	// поэтому метка времени передается строкой RFC 3339 без тега
+	return &ugorjiCodec{contentType: ContentTypeCBOR, handle: h, document: true}
+}
+
+func (c *ugorjiCodec) ContentType() string { return c.contentType }
+
+func (c *ugorjiCodec) Encode(v interface{}) ([]byte, error) {
+	if data, ok := v.(models.SensorData); ok && c.document {
+		v = Document(data)
+	}
+	var body []byte
+	if err := ugorji.NewEncoderBytes(&body, c.handle).Encode(v); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (c *ugorjiCodec) DecodeDocument(body []byte) (map[string]interface{}, error) {
+	var v interface{}
+	if err := ugorji.NewDecoderBytes(body, c.handle).Decode(&v); err != nil {
+		return nil, err
+	}
+	return document(v)
+}
@@ -0,0 +1,217 @@
+package codec
+
+import (
+	"big_go/internal/models"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf кодирует показания по описанию sensor_data.proto. В тело
+// попадают только поля, присутствующие в документе, поэтому при разборе
+// отсутствующее поле обнаруживается проверкой схемы.
+type Protobuf struct{}
+
+func (Protobuf) ContentType() string { return ContentTypeProtobuf }
+
+func (Protobuf) Encode(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case models.SensorData:
+		return encodeSensorData(Document(v))
+	case map[string]interface{}:
+		return encodeSensorData(v)
+	}
+	return nil, fmt.Errorf("protobuf: неподдерживаемое значение %T", v)
+}
+
+func encodeSensorData(doc map[string]interface{}) ([]byte, error) {
+	var b []byte
+	if v, ok := doc["schema_version"].(float64); ok {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v))
+	}
+
+	if v, ok := doc["id"].(string); ok {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	}
+
+	if meta, ok := doc["meta"].(map[string]interface{}); ok {
+		var m []byte
+		if v, ok := meta["recipient"].(string); ok {
+			m = protowire.AppendTag(m, 1, protowire.BytesType)
+			m = protowire.AppendString(m, v)
+		}
+		if v, ok := meta["post_id"].(float64); ok {
+			m = protowire.AppendTag(m, 2, protowire.VarintType)
+			m = protowire.AppendVarint(m, uint64(int64(v)))
+		}
+		if v, ok := meta["address"].(float64); ok {
+			m = protowire.AppendTag(m, 3, protowire.VarintType)
+			m = protowire.AppendVarint(m, uint64(int64(v)))
+		}
+		if v, ok := meta["timestamp"].(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, fmt.Errorf("protobuf: метка времени: %v", err)
+			}
+			m = protowire.AppendTag(m, 4, protowire.Fixed64Type)
+			m = protowire.AppendFixed64(m, uint64(t.UnixNano()))
+		}
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, m)
+	}
+
+	if data, ok := doc["data"].(map[string]interface{}); ok {
+		for name, value := range data {
+			metric, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("protobuf: метрика %s не является объектом", name)
+			}
+			var m []byte
+			if v, ok := metric["value"].(float64); ok {
+				m = protowire.AppendTag(m, 1, protowire.Fixed64Type)
+				m = protowire.AppendFixed64(m, math.Float64bits(v))
+			}
+			if v, ok := metric["unit"].(string); ok {
+				m = protowire.AppendTag(m, 2, protowire.BytesType)
+				m = protowire.AppendString(m, v)
+			}
+			if v, ok := metric["quality"].(string); ok {
+				m = protowire.AppendTag(m, 3, protowire.BytesType)
+				m = protowire.AppendString(m, v)
+			}
+
+			// Элемент map<string, Metric>: key = 1, value = 2
+			var entry []byte
+			entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+			entry = protowire.AppendString(entry, name)
+			entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+			entry = protowire.AppendBytes(entry, m)
+
+			b = protowire.AppendTag(b, 3, protowire.BytesType)
+			b = protowire.AppendBytes(b, entry)
+		}
+	}
+
+	return b, nil
+}
+
+func (Protobuf) DecodeDocument(body []byte) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	data := map[string]interface{}{}
+
+	err := eachField(body, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			doc["schema_version"] = float64(x)
+		case num == 2 && typ == protowire.BytesType:
+			meta, err := decodeMeta(v)
+			if err != nil {
+				return fmt.Errorf("meta: %v", err)
+			}
+			doc["meta"] = meta
+		case num == 3 && typ == protowire.BytesType:
+			name, metric, err := decodeMetricEntry(v)
+			if err != nil {
+				return fmt.Errorf("data: %v", err)
+			}
+			data[name] = metric
+		case num == 4 && typ == protowire.BytesType:
+			doc["id"] = string(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("protobuf: %v", err)
+	}
+
+	if len(data) > 0 {
+		doc["data"] = data
+	}
+	return doc, nil
+}
+
+func decodeMeta(b []byte) (map[string]interface{}, error) {
+	meta := map[string]interface{}{}
+	err := eachField(b, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			meta["recipient"] = string(v)
+		case num == 2 && typ == protowire.VarintType:
+			meta["post_id"] = float64(int64(x))
+		case num == 3 && typ == protowire.VarintType:
+			meta["address"] = float64(int64(x))
+		case num == 4 && typ == protowire.Fixed64Type:
+			meta["timestamp"] = time.Unix(0, int64(x)).UTC().Format(time.RFC3339Nano)
+		}
+		return nil
+	})
+	return meta, err
+}
+
+func decodeMetricEntry(b []byte) (string, map[string]interface{}, error) {
+	var name string
+	// В proto3 нулевое значение не передается
+	metric := map[string]interface{}{"value": 0.0}
+	err := eachField(b, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			name = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			return eachField(v, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					metric["value"] = math.Float64frombits(x)
+				case num == 2 && typ == protowire.BytesType:
+					metric["unit"] = string(v)
+				case num == 3 && typ == protowire.BytesType:
+					metric["quality"] = string(v)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return name, metric, err
+}
+
+// eachField перебирает поля сообщения; для полей-строк и вложенных
+// сообщений передается v, для чисел - x. Неизвестные поля пропускаются.
+func eachField(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v []byte
+		var x uint64
+		switch typ {
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			x, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var x32 uint32
+			x32, n = protowire.ConsumeFixed32(b)
+			x = uint64(x32)
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(num, typ, v, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+// Package filter разбирает и вычисляет выражения отбора показаний, например
+//
+//	address == 3 and post_id in 1..2 and humidity > 70
+//
+// Поля метаданных: recipient, post_id (или post), address, id; их можно
+// писать и с префиксом meta. (meta.address). Любое другое имя - метрика
+// показания: humidity - ее значение, humidity.quality и humidity.unit -
+// признак качества и единица измерения. Метрику с именем, совпадающим с
+// полем или ключевым словом, можно указать через префикс data.
+// (data.address).
+//
+// Операторы: == != < <= > >=, in [1, 2, 3], in 1..2 (диапазон включает
+// границы), and/&&, or/||, not/!, скобки, has(метрика), true, false.
+// Сравнение с метрикой, которой нет в показании, ложно.
+package filter
+
+import (
+	"big_go/internal/models"
+	"fmt"
+)
+
+// Filter - разобранное и проверенное выражение
+type Filter struct {
+	src  string
+	root node
+}
+
+// Compile разбирает выражение и проверяет типы операндов
+func Compile(src string) (*Filter, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("позиция %d: лишнее %s", t.pos, t)
+	}
+	return &Filter{src: src, root: root}, nil
+}
+
+// Match сообщает, удовлетворяет ли показание выражению
+func (f *Filter) Match(data models.SensorData) bool {
+	return f.root.match(&data)
+}
+
+// String возвращает исходный текст выражения
+func (f *Filter) String() string {
+	return f.src
+}
+
+// valueType - тип операнда, известный при разборе
+type valueType int
+
+const (
+	typeNumber valueType = iota
+	typeString
+)
+
+func (t valueType) String() string {
+	if t == typeString {
+		return "строка"
+	}
+	return "число"
+}
+
+type value struct {
+	num float64
+	str string
+}
+
+// operand - значение, которое берется из показания или задано в выражении
+type operand interface {
+	typ() valueType
+	// value возвращает значение; false - в показании его нет
+	value(d *models.SensorData) (value, bool)
+}
+
+type literal struct {
+	t valueType
+	v value
+}
+
+func (l literal) typ() valueType                         { return l.t }
+func (l literal) value(*models.SensorData) (value, bool) { return l.v, true }
+
+// metaField - поле метаданных показания
+type metaField string
+
+func (f metaField) typ() valueType {
+	if f == "post_id" || f == "address" {
+		return typeNumber
+	}
+	return typeString
+}
+
+func (f metaField) value(d *models.SensorData) (value, bool) {
+	switch f {
+	case "recipient":
+		return value{str: d.Meta.Recipient}, true
+	case "post_id":
+		return value{num: float64(d.Meta.PostID)}, true
+	case "address":
+		return value{num: float64(d.Meta.Address)}, true
+	case "id":
+		return value{str: d.ID}, true
+	}
+	return value{}, false
+}
+
+// metricField - значение, единица или качество метрики
+type metricField struct {
+	metric string
+	attr   string // value, unit или quality
+}
+
+func (f metricField) typ() valueType {
+	if f.attr == "value" {
+		return typeNumber
+	}
+	return typeString
+}
+
+func (f metricField) value(d *models.SensorData) (value, bool) {
+	m, ok := d.Data[f.metric]
+	if !ok {
+		return value{}, false
+	}
+	switch f.attr {
+	case "unit":
+		return value{str: m.Unit}, true
+	case "quality":
+		return value{str: m.Quality}, true
+	}
+	return value{num: m.Value}, true
+}
+
+// node - логическое подвыражение
+type node interface {
+	match(d *models.SensorData) bool
+}
+
+type orNode []node
+
+func (n orNode) match(d *models.SensorData) bool {
+	for _, c := range n {
+		if c.match(d) {
+			return true
+		}
+	}
+	return false
+}
+
+type andNode []node
+
+func (n andNode) match(d *models.SensorData) bool {
+	for _, c := range n {
+		if !c.match(d) {
+			return false
+		}
+	}
+	return true
+}
+
+type notNode struct{ n node }
+
+func (n notNode) match(d *models.SensorData) bool { return !n.n.match(d) }
+
+type boolNode bool
+
+func (n boolNode) match(*models.SensorData) bool { return bool(n) }
+
+// hasNode - метрика есть в показании
+type hasNode string
+
+func (n hasNode) match(d *models.SensorData) bool {
+	_, ok := d.Data[string(n)]
+	return ok
+}
+
+type compareNode struct {
+	op          string
+	left, right operand
+}
+
+func (n compareNode) match(d *models.SensorData) bool {
+	l, ok := n.left.value(d)
+	if !ok {
+		return false
+	}
+	r, ok := n.right.value(d)
+	if !ok {
+		return false
+	}
+	if n.left.typ() == typeString {
+		if n.op == "==" {
+			return l.str == r.str
+		}
+		return l.str != r.str
+	}
+	switch n.op {
+	case "==":
+		return l.num == r.num
+	case "!=":
+		return l.num != r.num
+	case "<":
+		return l.num < r.num
+	case "<=":
+		return l.num <= r.num
+	case ">":
+		return l.num > r.num
+	}
+	return l.num >= r.num
+}
+
+type inListNode struct {
+	left  operand
+	items []value
+}
+
+func (n inListNode) match(d *models.SensorData) bool {
+	v, ok := n.left.value(d)
+	if !ok {
+		return false
+	}
+	for _, item := range n.items {
+		if (n.left.typ() == typeString && v.str == item.str) || (n.left.typ() == typeNumber && v.num == item.num) {
+			return true
+		}
+	}
+	return false
+}
+
+type inRangeNode struct {
+	left   operand
+	lo, hi float64
+}
+
+func (n inRangeNode) match(d *models.SensorData) bool {
+	v, ok := n.left.value(d)
+	return ok && v.num >= n.lo && v.num <= n.hi
+}
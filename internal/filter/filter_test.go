@@ -0,0 +1,109 @@
+package filter
+
+import (
+	"big_go/internal/models"
+	"strings"
+	"testing"
+)
+
+// reading - показание поста 2 адреса 3 получателя user1
+var reading = models.SensorData{
+	ID: "01HX",
+	Meta: models.MetaData{
+		Recipient: "user1",
+		Address:   3,
+		PostID:    2,
+	},
+	Data: map[string]models.Metric{
+		"humidity":    {Value: 75, Unit: "%"},
+		"temperature": {Value: -4.5, Unit: "C", Quality: models.QualityBad},
+		"address":     {Value: 10},
+	},
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"address == 3", true},
+		{"meta.address == 3 and post_id == 2", true},
+		{"post == 2", true},
+		{"recipient == 'user1'", true},
+		{"recipient != \"user1\"", false},
+		{"id == '01HX'", true},
+		{"humidity > 70", true},
+		{"humidity.value >= 75 && humidity <= 75", true},
+		{"humidity.unit == '%'", true},
+		{"temperature < -4", true},
+		{"temperature == -4.5", true},
+		{"temperature.quality == 'bad'", true},
+		{"data.address == 10", true},
+		{"data.address.value > meta.address", true},
+		{"post_id in [1, 2, 3]", true},
+		{"post_id in [4]", false},
+		{"recipient in ['user2', 'user1']", true},
+		{"humidity in 70..75", true},
+		{"humidity in 70..74.9", false},
+		{"address in -1..3", true},
+		{"has(humidity) and not has(co2)", true},
+		{"has('co2')", false},
+		{"co2 > 0", false},
+		{"not co2 > 0", true},
+		{"co2.unit != 'ppm'", false},
+		{"address == 1 or address == 3 and post == 2", true},
+		{"(address == 1 or address == 3) and post == 1", false},
+		{"!(address == 1) && true", true},
+		{"false || humidity > 100", false},
+	}
+	for _, tt := range tests {
+		f, err := Compile(tt.src)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.src, err)
+			continue
+		}
+		if got := f.Match(reading); got != tt.want {
+			t.Errorf("%q: Match = %v, want %v", tt.src, got, tt.want)
+		}
+		if f.String() != tt.src {
+			t.Errorf("String() = %q, want %q", f.String(), tt.src)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		// Разбор
+		{"", "позиция 1: ожидалось число или строка, найдено конец выражения"},
+		{"address", "позиция 8: ожидался оператор сравнения, найдено конец выражения"},
+		{"address == 3 and", "позиция 17: ожидалось число или строка"},
+		{"(address == 3", "позиция 14: ожидалось \")\", найдено конец выражения"},
+		{"address == 3)", "позиция 13: лишнее \")\""},
+		{"address == 3 post == 1", "позиция 14: лишнее \"post\""},
+		{"has(1)", "позиция 5: ожидалось имя метрики, найдено \"1\""},
+		{"post in [1, 2", "позиция 14: ожидалось \"]\""},
+		{"humidity in 1", "ожидалось \"..\""},
+		{"humidity in 5..1", "позиция 13: пустой диапазон 5..1"},
+		{"meta.humidity > 1", "позиция 1: неизвестное поле метаданных meta.humidity"},
+		{"data > 1", "позиция 1: после data. ожидалось имя метрики"},
+		{"humidity.min > 1", "позиция 1: у метрики есть только value, unit и quality, найдено humidity.min"},
+		{"recipient == -'user1'", "ожидалось число или строка"},
+		// Типы
+		{"recipient == 1", "позиция 14: сравнение значений разных типов (строка и число)"},
+		{"humidity == 'high'", "позиция 13: сравнение значений разных типов (число и строка)"},
+		{"humidity.unit == humidity", "сравнение значений разных типов (строка и число)"},
+		{"recipient < 'user2'", "позиция 11: строки сравниваются только == и !="},
+		{"post in [1, 'two']", "позиция 13: элемент списка - строка, ожидалось число"},
+		{"recipient in 1..2", "позиция 14: диапазон допустим только для чисел"},
+		{"humidity in 'a'..'b'", "позиция 13: границы диапазона должны быть числами"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Compile(%q) error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
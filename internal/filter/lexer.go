@@ -0,0 +1,111 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind - вид лексемы
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp // операторы и знаки препинания
+)
+
+type token struct {
+	kind tokenKind
+	text string  // имя, оператор или значение строки
+	num  float64 // значение числа
+	pos  int     // позиция в выражении (с 1)
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "конец выражения"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// operators - операторы в порядке убывания длины, чтобы "<=" не читался как "<"
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "..", "<", ">", "!", "(", ")", "[", "]", ",", ".", "-"}
+
+// lex разбивает выражение на лексемы
+func lex(src string) ([]token, error) {
+	var tokens []token
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[start:i]), pos: pos})
+
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			// Дробная часть, но не начало диапазона "1..2"
+			if i+1 < len(runes) && runes[i] == '.' && unicode.IsDigit(runes[i+1]) {
+				i++
+				for i < len(runes) && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+			text := string(runes[start:i])
+			num, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("позиция %d: некорректное число %q", pos, text)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: text, num: num, pos: pos})
+
+		case r == '"' || r == '\'':
+			var b strings.Builder
+			i++
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("позиция %d: незакрытая строка", pos)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokString, text: b.String(), pos: pos})
+
+		default:
+			op := ""
+			rest := string(runes[i:])
+			for _, candidate := range operators {
+				if strings.HasPrefix(rest, candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("позиция %d: неожиданный символ %q", pos, r)
+			}
+			i += len([]rune(op))
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: pos})
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(runes) + 1}), nil
+}
@@ -0,0 +1,73 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	tokens, err := lex(`humidity.value >= 70.5 and post in 1..2 || recipient != 'us\'er' && !has("co2")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []token{
+		{kind: tokIdent, text: "humidity", pos: 1},
+		{kind: tokOp, text: ".", pos: 9},
+		{kind: tokIdent, text: "value", pos: 10},
+		{kind: tokOp, text: ">=", pos: 16},
+		{kind: tokNumber, text: "70.5", num: 70.5, pos: 19},
+		{kind: tokIdent, text: "and", pos: 24},
+		{kind: tokIdent, text: "post", pos: 28},
+		{kind: tokIdent, text: "in", pos: 33},
+		{kind: tokNumber, text: "1", num: 1, pos: 36},
+		{kind: tokOp, text: "..", pos: 37},
+		{kind: tokNumber, text: "2", num: 2, pos: 39},
+		{kind: tokOp, text: "||", pos: 41},
+		{kind: tokIdent, text: "recipient", pos: 44},
+		{kind: tokOp, text: "!=", pos: 54},
+		{kind: tokString, text: "us'er", pos: 57},
+		{kind: tokOp, text: "&&", pos: 66},
+		{kind: tokOp, text: "!", pos: 69},
+		{kind: tokIdent, text: "has", pos: 70},
+		{kind: tokOp, text: "(", pos: 73},
+		{kind: tokString, text: "co2", pos: 74},
+		{kind: tokOp, text: ")", pos: 79},
+		{kind: tokEOF, pos: 80},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens %v, want %d", len(tokens), tokens, len(want))
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("token %d = %+v, want %+v", i, tokens[i], want[i])
+		}
+	}
+}
+
+func TestLexUnicodeNames(t *testing.T) {
+	tokens, err := lex("влажность > 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Позиции считаются в символах, а не в байтах
+	if tokens[0].text != "влажность" || tokens[1].pos != 11 {
+		t.Errorf("tokens %+v", tokens)
+	}
+}
+
+func TestLexErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`recipient == "user1`, "позиция 14: незакрытая строка"},
+		{`address == 1 # comment`, "позиция 14: неожиданный символ '#'"},
+		{`address = 1`, "позиция 9: неожиданный символ '='"},
+	}
+	for _, tt := range tests {
+		_, err := lex(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("lex(%q) error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package filter
+
+import "fmt"
+
+// keywords нельзя использовать как имена метрик без префикса data.
+var keywords = map[string]bool{
+	"and": true, "or": true, "not": true, "in": true,
+	"true": true, "false": true, "has": true,
+}
+
+// comparisons - операторы сравнения
+var comparisons = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// metaFields - поля метаданных; post - синоним post_id
+var metaFields = map[string]metaField{
+	"recipient": "recipient",
+	"post_id":   "post_id",
+	"post":      "post_id",
+	"address":   "address",
+	"id":        "id",
+}
+
+// parser - рекурсивный спуск по грамматике
+//
+//	or      = and { ("or" | "||") and }
+//	and     = unary { ("and" | "&&") unary }
+//	unary   = ("not" | "!") unary | primary
+//	primary = "(" or ")" | "true" | "false" | "has" "(" name ")" | compare
+//	compare = operand ( cmp operand | "in" ( list | range ) )
+//	list    = "[" literal { "," literal } "]"
+//	range   = number ".." number
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept пропускает оператор или ключевое слово text, если оно следующее
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokOp || t.kind == tokIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return fmt.Errorf("позиция %d: ожидалось %q, найдено %s", t.pos, text, t)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	nodes := orNode{first}
+	for p.accept("or") || p.accept("||") {
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 1 {
+		return first, nil
+	}
+	return nodes, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	nodes := andNode{first}
+	for p.accept("and") || p.accept("&&") {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 1 {
+		return first, nil
+	}
+	return nodes, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("not") || p.accept("!") {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	switch {
+	case p.accept("("):
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case p.accept("true"):
+		return boolNode(true), nil
+	case p.accept("false"):
+		return boolNode(false), nil
+	case p.accept("has"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		t := p.next()
+		if t.kind != tokIdent && t.kind != tokString {
+			return nil, fmt.Errorf("позиция %d: ожидалось имя метрики, найдено %s", t.pos, t)
+		}
+		return hasNode(t.text), p.expect(")")
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.next()
+	if t.kind == tokIdent && t.text == "in" {
+		return p.parseIn(left)
+	}
+	if t.kind != tokOp || !comparisons[t.text] {
+		return nil, fmt.Errorf("позиция %d: ожидался оператор сравнения, найдено %s", t.pos, t)
+	}
+	if left.typ() == typeString && t.text != "==" && t.text != "!=" {
+		return nil, fmt.Errorf("позиция %d: строки сравниваются только == и !=", t.pos)
+	}
+
+	rightPos := p.peek().pos
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if left.typ() != right.typ() {
+		return nil, fmt.Errorf("позиция %d: сравнение значений разных типов (%s и %s)", rightPos, left.typ(), right.typ())
+	}
+	return compareNode{op: t.text, left: left, right: right}, nil
+}
+
+func (p *parser) parseIn(left operand) (node, error) {
+	if p.accept("[") {
+		var items []value
+		for {
+			lit, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			if lit.t != left.typ() {
+				return nil, fmt.Errorf("позиция %d: элемент списка - %s, ожидалось %s", p.tokens[p.pos-1].pos, lit.t, left.typ())
+			}
+			items = append(items, lit.v)
+			if !p.accept(",") {
+				break
+			}
+		}
+		return inListNode{left: left, items: items}, p.expect("]")
+	}
+
+	pos := p.peek().pos
+	if left.typ() != typeNumber {
+		return nil, fmt.Errorf("позиция %d: диапазон допустим только для чисел", pos)
+	}
+	lo, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(".."); err != nil {
+		return nil, err
+	}
+	hi, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	if lo.t != typeNumber || hi.t != typeNumber {
+		return nil, fmt.Errorf("позиция %d: границы диапазона должны быть числами", pos)
+	}
+	if lo.v.num > hi.v.num {
+		return nil, fmt.Errorf("позиция %d: пустой диапазон %g..%g", pos, lo.v.num, hi.v.num)
+	}
+	return inRangeNode{left: left, lo: lo.v.num, hi: hi.v.num}, nil
+}
+
+func (p *parser) parseLiteral() (literal, error) {
+	negative := p.accept("-")
+	t := p.next()
+	switch {
+	case t.kind == tokNumber && negative:
+		return literal{t: typeNumber, v: value{num: -t.num}}, nil
+	case t.kind == tokNumber:
+		return literal{t: typeNumber, v: value{num: t.num}}, nil
+	case t.kind == tokString && !negative:
+		return literal{t: typeString, v: value{str: t.text}}, nil
+	}
+	return literal{}, fmt.Errorf("позиция %d: ожидалось число или строка, найдено %s", t.pos, t)
+}
+
+func (p *parser) parseOperand() (operand, error) {
+	t := p.peek()
+	if t.kind != tokIdent || keywords[t.text] {
+		return p.parseLiteral()
+	}
+
+	// Путь из имен через точку: humidity, humidity.quality, meta.address, data.address.value
+	var path []string
+	for {
+		t := p.next()
+		if t.kind != tokIdent {
+			return nil, fmt.Errorf("позиция %d: ожидалось имя, найдено %s", t.pos, t)
+		}
+		path = append(path, t.text)
+		if !p.accept(".") {
+			break
+		}
+	}
+	return resolve(path, t.pos)
+}
+
+// resolve сопоставляет путь полю метаданных или метрике
+func resolve(path []string, pos int) (operand, error) {
+	switch path[0] {
+	case "meta":
+		if len(path) == 2 {
+			if f, ok := metaFields[path[1]]; ok {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("позиция %d: неизвестное поле метаданных %s", pos, joinPath(path))
+	case "data":
+		if len(path) < 2 {
+			return nil, fmt.Errorf("позиция %d: после data. ожидалось имя метрики", pos)
+		}
+		path = path[1:]
+	default:
+		if f, ok := metaFields[path[0]]; ok && len(path) == 1 {
+			return f, nil
+		}
+	}
+
+	switch {
+	case len(path) == 1:
+		return metricField{metric: path[0], attr: "value"}, nil
+	case len(path) == 2 && (path[1] == "value" || path[1] == "unit" || path[1] == "quality"):
+		return metricField{metric: path[0], attr: path[1]}, nil
+	}
+	return nil, fmt.Errorf("позиция %d: у метрики есть только value, unit и quality, найдено %s", pos, joinPath(path))
+}
+
+func joinPath(path []string) string {
+	s := path[0]
+	for _, p := range path[1:] {
+		s += "." + p
+	}
+	return s
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Состояния тревоги
+const (
+	AlertPending  = "pending"  // условие выполняется, но еще не дольше заданного времени
+	AlertFiring   = "firing"   // тревога сработала
+	AlertResolved = "resolved" // условие снято после срабатывания
+)
+
+// Alert - тревога правила в одной области: посте, адресе или получателе.
+// Уведомления отправляются при переходе в AlertFiring и в AlertResolved.
+type Alert struct {
+	Rule       string     `json:"rule"`
+	Severity   string     `json:"severity,omitempty"`
+	State      string     `json:"state"`
+	Scope      string     `json:"scope"` // post, address или recipient
+	Recipient  string     `json:"recipient"`
+	Address    int        `json:"address,omitempty"` // 0 для области recipient
+	PostID     int        `json:"post_id,omitempty"` // 0 для областей address и recipient
+	Metric     string     `json:"metric"`
+	Value      float64    `json:"value"`           // значение или скорость изменения в последнем учтенном показании
+	Posts      []string   `json:"posts,omitempty"` // посты области, для которых выполняется условие, "адрес/пост"
+	Message    string     `json:"message"`
+	Since      time.Time  `json:"since"` // когда условие начало выполняться
+	FiredAt    *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// ID возвращает идентификатор тревоги: одинаковый для срабатывания и
+// снятия, поэтому получатель может заменить сработавшую тревогу снятой
+func (a Alert) ID() string {
+	return fmt.Sprintf("%s/%s/%d/%d/%d", a.Rule, a.Recipient, a.Address, a.PostID, a.Since.UnixMilli())
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// crockford - алфавит Crockford Base32, которым записываются ULID
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewID создает идентификатор сообщения в формате ULID: 48 бит времени t
+// в миллисекундах и 80 бит из entropy. Идентификаторы упорядочены по
+// времени, а при воспроизводимом источнике entropy воспроизводимы.
+func NewID(t time.Time, entropy io.Reader) (string, error) {
+	var id [16]byte
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	for i := 5; i >= 0; i-- {
+		id[i] = byte(ms)
+		ms >>= 8
+	}
+	if _, err := io.ReadFull(entropy, id[6:]); err != nil {
+		return "", fmt.Errorf("ошибка получения случайных байт: %v", err)
+	}
+
+	// 128 бит записываются 26 символами по 5 бит, старший символ - 3 бита
+	out := make([]byte, 26)
+	var acc uint32
+	bits := 2 // дополнение слева до 130 бит
+	n := 0
+	for _, b := range id {
+		acc = acc<<8 | uint32(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[n] = crockford[(acc>>uint(bits))&0x1f]
+			n++
+		}
+	}
+	return string(out), nil
+}
+
+// documentID выводит идентификатор сообщения, у которого его не было, из
+// содержимого документа (UUID версии 5 от канонического JSON), чтобы
+// повторно доставленные копии получали один и тот же идентификатор
+func documentID(doc map[string]interface{}) (string, error) {
+	// json.Marshal сортирует ключи объектов, поэтому запись каноническая
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	h.Write([]byte("urn:big_go:sensor_data:"))
+	h.Write(b)
+	sum := h.Sum(nil)
+
+	sum[6] = sum[6]&0x0f | 0x50 // версия 5
+	sum[8] = sum[8]&0x3f | 0x80 // вариант RFC 4122
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16]), nil
+}
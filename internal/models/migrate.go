@@ -0,0 +1,165 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SchemaVersion - текущая версия схемы сообщений.
+//
+//	1 - без schema_version, метрики temperature/pressure/humidity числами
+//	2 - schema_version, метрики объектами {"value", "unit", "quality"}
+//	3 - идентификатор сообщения id
+const SchemaVersion = 3
+
+// Upcaster преобразует документ сообщения (результат json.Unmarshal в
+// map[string]interface{}) из версии from в версию from+1
+type Upcaster func(doc map[string]interface{}) error
+
+var (
+	upcastersMu sync.RWMutex
+	upcasters   = map[int]Upcaster{}
+)
+
+// RegisterUpcaster регистрирует преобразование из версии from в from+1.
+// Новая версия схемы добавляется вместе с преобразованием из предыдущей.
+func RegisterUpcaster(from int, fn Upcaster) {
+	upcastersMu.Lock()
+	defer upcastersMu.Unlock()
+	if _, ok := upcasters[from]; ok {
+		panic(fmt.Sprintf("преобразование из версии %d уже зарегистрировано", from))
+	}
+	upcasters[from] = fn
+}
+
+func init() {
+	RegisterUpcaster(1, upcastV1)
+	RegisterUpcaster(2, upcastV2)
+}
+
+// upcastV1 переводит метрики из чисел в объекты с единицами измерения
+func upcastV1(doc map[string]interface{}) error {
+	data, ok := doc["data"].(map[string]interface{})
+	if !ok {
+		return nil // отсутствие данных обнаружит проверка схемы
+	}
+	for name, value := range data {
+		if number, ok := value.(float64); ok {
+			m := map[string]interface{}{"value": number}
+			if unit := MetricUnits[name]; unit != "" {
+				m["unit"] = unit
+			}
+			data[name] = m
+		}
+	}
+	return nil
+}
+
+// upcastV2 добавляет идентификатор, выведенный из содержимого сообщения:
+// копии одного сообщения прежней версии получают одинаковый id
+func upcastV2(doc map[string]interface{}) error {
+	if _, ok := doc["id"]; ok {
+		return nil
+	}
+	id, err := documentID(doc)
+	if err != nil {
+		return err
+	}
+	doc["id"] = id
+	return nil
+}
+
+// Причины, по которым сообщение не может быть принято
+const (
+	ReasonMalformed = "malformed" // тело не является JSON-объектом
+	ReasonVersion   = "version"   // версия схемы неизвестна или не преобразуется
+	ReasonSchema    = "schema"    // документ не соответствует схеме
+	ReasonUnmarshal = "unmarshal" // документ не переносится в структуру
+)
+
+// DecodeError - сообщение не прошло разбор, преобразование или проверку
+type DecodeError struct {
+	Reason   string   // одна из Reason*
+	Version  int      // версия схемы сообщения, если известна
+	Problems []string // нарушения схемы или описание ошибки
+}
+
+func (e *DecodeError) Error() string {
+	if e.Version == 0 {
+		return fmt.Sprintf("%s: %s", e.Reason, strings.Join(e.Problems, "; "))
+	}
+	return fmt.Sprintf("%s (версия %d): %s", e.Reason, e.Version, strings.Join(e.Problems, "; "))
+}
+
+// Decode разбирает JSON-сообщение любой поддерживаемой версии (см. DecodeDocument)
+func Decode(body []byte) (SensorData, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil || doc == nil {
+		problem := "тело не является JSON-объектом"
+		if err != nil {
+			problem = err.Error()
+		}
+		return SensorData{}, &DecodeError{Reason: ReasonMalformed, Problems: []string{problem}}
+	}
+	return DecodeDocument(doc)
+}
+
+// DecodeDocument переносит в SensorData документ сообщения в JSON-модели
+// (объекты - map[string]interface{}, числа - float64, время - строка
+// RFC 3339): читает schema_version (отсутствие означает версию 1),
+// последовательно применяет зарегистрированные преобразования до текущей
+// версии и проверяет результат по SensorDataSchema. Документ изменяется.
+func DecodeDocument(doc map[string]interface{}) (SensorData, error) {
+	version := 1
+	if raw, ok := doc["schema_version"]; ok {
+		v, ok := raw.(float64)
+		if !ok || v != float64(int(v)) || v < 1 {
+			return SensorData{}, &DecodeError{Reason: ReasonVersion,
+				Problems: []string{fmt.Sprintf("некорректная schema_version %v", raw)}}
+		}
+		version = int(v)
+	}
+	if version > SchemaVersion {
+		return SensorData{}, &DecodeError{Reason: ReasonVersion, Version: version,
+			Problems: []string{fmt.Sprintf("версия новее поддерживаемой %d", SchemaVersion)}}
+	}
+
+	if err := Upcast(doc, version); err != nil {
+		return SensorData{}, &DecodeError{Reason: ReasonVersion, Version: version, Problems: []string{err.Error()}}
+	}
+
+	if problems := SensorDataSchema().Validate(doc); len(problems) > 0 {
+		return SensorData{}, &DecodeError{Reason: ReasonSchema, Version: version, Problems: problems}
+	}
+
+	// Документ уже проверен, повторная сериализация сохраняет его как есть
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return SensorData{}, &DecodeError{Reason: ReasonUnmarshal, Version: version, Problems: []string{err.Error()}}
+	}
+	var data SensorData
+	if err := json.Unmarshal(normalized, &data); err != nil {
+		return SensorData{}, &DecodeError{Reason: ReasonUnmarshal, Version: version, Problems: []string{err.Error()}}
+	}
+	return data, nil
+}
+
+// Upcast преобразует документ версии version в текущую версию
+func Upcast(doc map[string]interface{}, version int) error {
+	upcastersMu.RLock()
+	defer upcastersMu.RUnlock()
+
+	for v := version; v < SchemaVersion; v++ {
+		fn, ok := upcasters[v]
+		if !ok {
+			return fmt.Errorf("нет преобразования из версии %d", v)
+		}
+		if err := fn(doc); err != nil {
+			return fmt.Errorf("преобразование из версии %d: %v", v, err)
+		}
+	}
+	doc["schema_version"] = float64(SchemaVersion)
+	return nil
+}
@@ -1,24 +1,156 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
 
 // SensorData представляет данные от датчиков
 type SensorData struct {
-	Meta MetaData  `json:"meta"`
-	Data DataPoint `json:"data"`
+	SchemaVersion int       `json:"schema_version" schema:"minimum=1"` // см. SchemaVersion
+	ID            string    `json:"id" schema:"minLength=1"`           // ULID, назначенный генератором (см. NewID)
+	Meta          MetaData  `json:"meta"`
+	Data          DataPoint `json:"data" schema:"minProperties=1"`
 }
 
 // MetaData содержит метаданные сообщения
 type MetaData struct {
-	Recipient string    `json:"recipient"` // User1 или User2
-	PostID    int       `json:"post_id"`   // Номер поста (1-10)
-	Address   int       `json:"address"`   // Адрес
-	Timestamp time.Time `json:"timestamp"` // Временная метка
+	Recipient string    `json:"recipient" schema:"minLength=1"` // User1 или User2
+	PostID    int       `json:"post_id" schema:"minimum=1"`     // Номер поста (1-10)
+	Address   int       `json:"address" schema:"minimum=1"`     // Адрес
+	Timestamp time.Time `json:"timestamp"`                      // Временная метка
 }
 
-// DataPoint содержит данные измерений
-type DataPoint struct {
-	Temperature float64 `json:"temperature"` // Температура в градусах Цельсия
-	Pressure    float64 `json:"pressure"`    // Давление в мм.рт.ст.
-	Humidity    float64 `json:"humidity"`    // Влажность в %
+// Metric - одно измеренное значение
+type Metric struct {
+	Value   float64 `json:"value"`
+	Unit    string  `json:"unit,omitempty"`
+	Quality string  `json:"quality,omitempty" schema:"enum=good|uncertain|bad"` // пусто - качество не оценивалось
+}
+
+// Признаки качества измерения
+const (
+	QualityGood      = "good"
+	QualityUncertain = "uncertain"
+	QualityBad       = "bad"
+)
+
+// DataPoint содержит измерения поста: имя метрики -> значение.
+//
+// Кодируется как {"temperature": {"value": 21.5, "unit": "°C"}, ...}.
+// Прежняя кодировка с числами вместо объектов ({"temperature": 21.5,
+// "pressure": 760, "humidity": 55}) по-прежнему читается; единицы
+// измерения в этом случае берутся из MetricUnits.
+type DataPoint map[string]Metric
+
+// Имена известных метрик
+const (
+	MetricTemperature    = "temperature"
+	MetricPressure       = "pressure"
+	MetricHumidity       = "humidity"
+	MetricCO2            = "co2"
+	MetricWindSpeed      = "wind_speed"
+	MetricBatteryVoltage = "battery_voltage"
+	MetricIlluminance    = "illuminance"
+)
+
+// MetricUnits - единицы измерения известных метрик
+var MetricUnits = map[string]string{
+	MetricTemperature:    "°C",
+	MetricPressure:       "мм.рт.ст.",
+	MetricHumidity:       "%",
+	MetricCO2:            "ppm",
+	MetricWindSpeed:      "м/с",
+	MetricBatteryVoltage: "В",
+	MetricIlluminance:    "лк",
+}
+
+// NewMetric создает значение метрики с единицей измерения из MetricUnits
+func NewMetric(name string, value float64) Metric {
+	return Metric{Value: value, Unit: MetricUnits[name]}
+}
+
+// UnmarshalJSON читает как текущую, так и прежнюю (числовую) кодировку
+func (d *DataPoint) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*d = make(DataPoint, len(raw))
+	for name, value := range raw {
+		if string(value) == "null" {
+			continue
+		}
+		var number float64
+		if err := json.Unmarshal(value, &number); err == nil {
+			(*d)[name] = NewMetric(name, number)
+			continue
+		}
+
+		var m Metric
+		if err := json.Unmarshal(value, &m); err != nil {
+			return fmt.Errorf("метрика %s: %v", name, err)
+		}
+		if m.Unit == "" {
+			m.Unit = MetricUnits[name]
+		}
+		(*d)[name] = m
+	}
+	return nil
+}
+
+// Value возвращает значение метрики и признак ее наличия
+func (d DataPoint) Value(name string) (float64, bool) {
+	m, ok := d[name]
+	return m.Value, ok
+}
+
+// Names возвращает имена метрик: сначала известные в порядке их объявления,
+// затем остальные по алфавиту
+func (d DataPoint) Names() []string {
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
+	}
+	SortMetrics(names)
+	return names
+}
+
+// Clone возвращает независимую копию измерений
+func (d DataPoint) Clone() DataPoint {
+	c := make(DataPoint, len(d))
+	for name, m := range d {
+		c[name] = m
+	}
+	return c
+}
+
+// metricOrder - порядок отображения известных метрик
+var metricOrder = map[string]int{
+	MetricTemperature:    1,
+	MetricPressure:       2,
+	MetricHumidity:       3,
+	MetricCO2:            4,
+	MetricWindSpeed:      5,
+	MetricBatteryVoltage: 6,
+	MetricIlluminance:    7,
+}
+
+// SortMetrics упорядочивает имена метрик для отображения
+func SortMetrics(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		oi, oj := metricOrder[names[i]], metricOrder[names[j]]
+		switch {
+		case oi != 0 && oj != 0:
+			return oi < oj
+		case oi != 0:
+			return true
+		case oj != 0:
+			return false
+		}
+		return names[i] < names[j]
+	})
 }
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Rollup - сводка показаний поста или всех постов адреса за окно времени
+// [Start, End). Окна выравниваются по началу эпохи: 1m - по минутам, 1h - по
+// часам.
+type Rollup struct {
+	Window    string                  `json:"window"` // длительность окна, например "5m"
+	Start     time.Time               `json:"start"`
+	End       time.Time               `json:"end"`
+	Recipient string                  `json:"recipient"`
+	Address   int                     `json:"address"`
+	PostID    int                     `json:"post_id,omitempty"` // 0 - сводка по всем постам адреса
+	Partial   bool                    `json:"partial,omitempty"` // окно закрыто раньше срока
+	Part      int                     `json:"part,omitempty"`    // номер части окна, закрытого раньше срока (с 1); 0 - окно целиком
+	Metrics   map[string]MetricRollup `json:"metrics"`
+}
+
+// MetricRollup - статистика одной метрики за окно
+type MetricRollup struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+	Last  float64 `json:"last"` // значение с наибольшим временем показания
+	Unit  string  `json:"unit,omitempty"`
+}
+
+// ID возвращает идентификатор сводки: одинаковый для одного окна, поста
+// (адреса), получателя и части, поэтому повторная доставка распознается, а
+// части окна не заменяют друг друга
+func (r Rollup) ID() string {
+	id := fmt.Sprintf("%s/%d/%d/%s/%d", r.Recipient, r.Address, r.PostID, r.Window, r.Start.Unix())
+	if r.Part > 0 {
+		id += fmt.Sprintf("/%d", r.Part)
+	}
+	return id
+}
+
+// FormatWindow возвращает длительность окна в коротком виде: 30s, 5m, 1h
+func FormatWindow(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return d.String()
+}
@@ -0,0 +1,239 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:generate go run ../../cmd/schema -o sensor_data.schema.json
+
+// Schema - JSON Schema (подмножество draft 2020-12) в виде документа
+type Schema map[string]interface{}
+
+var (
+	sensorDataSchema     Schema
+	sensorDataSchemaOnce sync.Once
+)
+
+// SensorDataSchema возвращает JSON Schema сообщения текущей версии,
+// построенную по типам Go
+func SensorDataSchema() Schema {
+	sensorDataSchemaOnce.Do(func() {
+		sensorDataSchema = GenerateSchema(reflect.TypeOf(SensorData{}))
+		sensorDataSchema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+		sensorDataSchema["$id"] = fmt.Sprintf("urn:big_go:schema:sensor_data:v%d", SchemaVersion)
+		sensorDataSchema["title"] = "SensorData"
+	})
+	return sensorDataSchema
+}
+
+// GenerateSchema строит JSON Schema для типа t. Поля структур берутся по
+// тегам json; поля без omitempty обязательны. Дополнительные ограничения
+// задаются тегом schema через запятую, например
+// `schema:"minimum=1"` или `schema:"enum=good|bad"`.
+func GenerateSchema(t reflect.Type) Schema {
+	if t == reflect.TypeOf(time.Time{}) {
+		return Schema{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return GenerateSchema(t.Elem())
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": GenerateSchema(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": GenerateSchema(t.Elem())}
+	case reflect.Struct:
+		properties := Schema{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			tag := strings.Split(f.Tag.Get("json"), ",")
+			name := tag[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+
+			s := GenerateSchema(f.Type)
+			applySchemaTag(s, f.Tag.Get("schema"))
+			properties[name] = s
+
+			omitempty := false
+			for _, opt := range tag[1:] {
+				omitempty = omitempty || opt == "omitempty"
+			}
+			if !omitempty {
+				required = append(required, name)
+			}
+		}
+		s := Schema{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+
+	return Schema{}
+}
+
+// applySchemaTag добавляет в схему ограничения из тега schema
+func applySchemaTag(s Schema, tag string) {
+	if tag == "" {
+		return
+	}
+	for _, opt := range strings.Split(tag, ",") {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := kv[0], kv[1]
+		switch key {
+		case "enum":
+			values := strings.Split(value, "|")
+			enum := make([]interface{}, len(values))
+			for i, v := range values {
+				enum[i] = v
+			}
+			s[key] = enum
+		case "minimum", "maximum", "minLength", "minProperties":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				panic(fmt.Sprintf("некорректное ограничение схемы %q: %v", opt, err))
+			}
+			s[key] = n
+		default:
+			s[key] = value
+		}
+	}
+}
+
+// Validate проверяет документ (результат json.Unmarshal в interface{}) по
+// схеме и возвращает список нарушений; пустой список - документ корректен
+func (s Schema) Validate(doc interface{}) []string {
+	var problems []string
+	s.validate(doc, "", &problems)
+	sort.Strings(problems)
+	return problems
+}
+
+func (s Schema) validate(v interface{}, path string, problems *[]string) {
+	report := func(format string, args ...interface{}) {
+		p := path
+		if p == "" {
+			p = "/"
+		}
+		*problems = append(*problems, p+": "+fmt.Sprintf(format, args...))
+	}
+
+	if t, ok := s["type"].(string); ok && !schemaType(t, v) {
+		report("ожидается %s, получено %s", t, jsonType(v))
+		return
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == v
+		}
+		if !found {
+			report("значение %v не входит в %v", v, enum)
+		}
+	}
+
+	switch v := v.(type) {
+	case float64:
+		if min, ok := s["minimum"].(float64); ok && v < min {
+			report("значение %v меньше %v", v, min)
+		}
+		if max, ok := s["maximum"].(float64); ok && v > max {
+			report("значение %v больше %v", v, max)
+		}
+	case string:
+		if min, ok := s["minLength"].(float64); ok && float64(len([]rune(v))) < min {
+			report("длина строки меньше %v", min)
+		}
+		if s["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				report("некорректная дата-время %q", v)
+			}
+		}
+	case []interface{}:
+		if items, ok := s["items"].(Schema); ok {
+			for i, item := range v {
+				items.validate(item, fmt.Sprintf("%s/%d", path, i), problems)
+			}
+		}
+	case map[string]interface{}:
+		if min, ok := s["minProperties"].(float64); ok && float64(len(v)) < min {
+			report("должно быть не меньше %v свойств", min)
+		}
+		if required, ok := s["required"].([]string); ok {
+			for _, name := range required {
+				if _, ok := v[name]; !ok {
+					report("отсутствует обязательное поле %s", name)
+				}
+			}
+		}
+		properties, _ := s["properties"].(Schema)
+		additional, _ := s["additionalProperties"].(Schema)
+		for name, value := range v {
+			if ps, ok := properties[name].(Schema); ok {
+				ps.validate(value, path+"/"+name, problems)
+			} else if additional != nil {
+				additional.validate(value, path+"/"+name, problems)
+			}
+		}
+	}
+}
+
+// schemaType проверяет соответствие значения типу JSON Schema
+func schemaType(t string, v interface{}) bool {
+	switch t {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return jsonType(v) == t
+}
+
+// jsonType возвращает тип JSON значения
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,566 @@
+// Package repository хранит историю показаний в PostgreSQL
+package repository
+
+import (
+	"big_go/config"
+	"big_go/internal/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// DefaultLimit - сколько показаний возвращает запрос без ограничения
+const DefaultLimit = 1000
+
+// insertChunk - наибольшее число строк в одном INSERT (у PostgreSQL не
+// больше 65535 параметров на запрос)
+const insertChunk = 1000
+
+// migrations - изменения схемы по версиям; применяются по порядку, номер
+// версии - индекс + 1. Примененные миграции не меняются, новые добавляются в конец.
+var migrations = []string{
+	// 1: адреса, посты и показания
+	`CREATE TABLE addresses (
+		address    INTEGER PRIMARY KEY,
+		first_seen TIMESTAMPTZ NOT NULL,
+		last_seen  TIMESTAMPTZ NOT NULL
+	);
+	CREATE TABLE posts (
+		address    INTEGER NOT NULL REFERENCES addresses (address),
+		post_id    INTEGER NOT NULL,
+		recipient  TEXT NOT NULL,
+		first_seen TIMESTAMPTZ NOT NULL,
+		last_seen  TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (address, post_id)
+	);
+	CREATE TABLE readings (
+		id             TEXT PRIMARY KEY,
+		address        INTEGER NOT NULL,
+		post_id        INTEGER NOT NULL,
+		recipient      TEXT NOT NULL,
+		measured_at    TIMESTAMPTZ NOT NULL,
+		received_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+		schema_version INTEGER NOT NULL,
+		document       JSONB NOT NULL,
+		FOREIGN KEY (address, post_id) REFERENCES posts (address, post_id)
+	);
+	CREATE INDEX readings_measured_at ON readings (measured_at);
+	CREATE INDEX readings_recipient ON readings (recipient, measured_at);
+	CREATE INDEX readings_post ON readings (address, post_id, measured_at);`,
+
+	// 2: сводки по окнам (models.Rollup); post_id = 0 - сводка по адресу
+	`CREATE TABLE rollups (
+		recipient    TEXT NOT NULL,
+		address      INTEGER NOT NULL,
+		post_id      INTEGER NOT NULL,
+		window_size  TEXT NOT NULL,
+		window_start TIMESTAMPTZ NOT NULL,
+		window_end   TIMESTAMPTZ NOT NULL,
+		partial      BOOLEAN NOT NULL,
+		document     JSONB NOT NULL,
+		PRIMARY KEY (recipient, address, post_id, window_size, window_start)
+	);
+	CREATE INDEX rollups_window ON rollups (window_size, window_start);`,
+
+	// 3: части окна, закрытого раньше срока (models.Rollup.Part), хранятся
+	// отдельно
+	`ALTER TABLE rollups ADD COLUMN part INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE rollups DROP CONSTRAINT rollups_pkey;
+	ALTER TABLE rollups ADD PRIMARY KEY (recipient, address, post_id, window_size, window_start, part);`,
+}
+
+// migrationLock - ключ advisory-блокировки, под которой применяются миграции
+const migrationLock = 0x6269675f676f // "big_go"
+
+// Address - адрес, с которого поступали показания
+type Address struct {
+	Address   int       `json:"address"`
+	Posts     int       `json:"posts"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Post - пост адреса
+type Post struct {
+	Address   int       `json:"address"`
+	PostID    int       `json:"post_id"`
+	Recipient string    `json:"recipient"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// ReadingFilter - условия выборки показаний; нулевое поле не ограничивает выборку
+type ReadingFilter struct {
+	Recipient string
+	Address   int
+	PostID    int
+	From      time.Time // включительно
+	To        time.Time // не включительно
+	Limit     int       // 0 - DefaultLimit
+}
+
+// RollupFilter - условия выборки сводок; нулевое поле не ограничивает выборку
+type RollupFilter struct {
+	Window    string // например "5m"
+	Recipient string
+	Address   int
+	PostID    *int      // nil - любые; 0 - только сводки по адресу
+	From      time.Time // начало окна, включительно
+	To        time.Time // начало окна, не включительно
+	Limit     int       // 0 - DefaultLimit
+}
+
+// PostgresRepository хранит показания в таблице readings, а адреса и посты,
+// с которых они поступали, - в таблицах addresses и posts. Показание
+// хранится целиком (document) и читается в том же виде.
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresRepository создает репозиторий. Соединение устанавливается
+// при первом запросе, поэтому база может быть еще недоступна; схему
+// создает Migrate.
+func NewPostgresRepository(cfg *config.PostgresConfig) (*PostgresRepository, error) {
+	db, err := sql.Open("postgres", cfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresRepository{db: db}, nil
+}
+
+// Close закрывает соединения с базой
+func (r *PostgresRepository) Close() error {
+	return r.db.Close()
+}
+
+// Migrate применяет недостающие миграции и возвращает версию схемы.
+// Несколько экземпляров могут вызывать Migrate одновременно.
+func (r *PostgresRepository) Migrate(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return 0, err
+	}
+
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, err
+	}
+	if version > len(migrations) {
+		return version, fmt.Errorf("версия схемы базы %d новее известной %d", version, len(migrations))
+	}
+	for v := version + 1; v <= len(migrations); v++ {
+		if _, err := tx.ExecContext(ctx, migrations[v-1]); err != nil {
+			return version, fmt.Errorf("миграция %d: %v", v, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, v); err != nil {
+			return version, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return version, err
+	}
+	return len(migrations), nil
+}
+
+// Insert сохраняет показания одной транзакцией и обновляет адреса и посты.
+// Показание с уже сохраненным идентификатором пропускается, поэтому
+// повторная запись пакета безопасна.
+func (r *PostgresRepository) Insert(ctx context.Context, readings []models.SensorData) error {
+	if len(readings) == 0 {
+		return nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := upsertAddresses(ctx, tx, readings); err != nil {
+		return fmt.Errorf("ошибка записи адресов: %v", err)
+	}
+	if err := upsertPosts(ctx, tx, readings); err != nil {
+		return fmt.Errorf("ошибка записи постов: %v", err)
+	}
+	for start := 0; start < len(readings); start += insertChunk {
+		end := start + insertChunk
+		if end > len(readings) {
+			end = len(readings)
+		}
+		if err := insertReadings(ctx, tx, readings[start:end]); err != nil {
+			return fmt.Errorf("ошибка записи показаний: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// measuredAt - время показания; показания без метки времени получают текущее
+func measuredAt(data models.SensorData) time.Time {
+	if data.Meta.Timestamp.IsZero() {
+		return time.Now().UTC()
+	}
+	return data.Meta.Timestamp
+}
+
+// seen - первое и последнее время показаний адреса или поста в пакете
+type seen struct {
+	first, last time.Time
+	recipient   string
+}
+
+func (s *seen) add(t time.Time, recipient string) {
+	if s.first.IsZero() || t.Before(s.first) {
+		s.first = t
+	}
+	if !t.Before(s.last) {
+		s.last, s.recipient = t, recipient
+	}
+}
+
+func upsertAddresses(ctx context.Context, tx *sql.Tx, readings []models.SensorData) error {
+	addresses := make(map[int]*seen)
+	for _, data := range readings {
+		s, ok := addresses[data.Meta.Address]
+		if !ok {
+			s = &seen{}
+			addresses[data.Meta.Address] = s
+		}
+		s.add(measuredAt(data), data.Meta.Recipient)
+	}
+	keys := make([]int, 0, len(addresses))
+	for address := range addresses {
+		keys = append(keys, address)
+	}
+	// Одинаковый порядок строк во всех транзакциях исключает взаимные блокировки
+	sort.Ints(keys)
+
+	var values []string
+	var args []interface{}
+	for _, address := range keys {
+		s := addresses[address]
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d)", n+1, n+2, n+3))
+		args = append(args, address, s.first, s.last)
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO addresses (address, first_seen, last_seen) VALUES `+
+		strings.Join(values, ", ")+`
+		ON CONFLICT (address) DO UPDATE SET
+			first_seen = LEAST(addresses.first_seen, EXCLUDED.first_seen),
+			last_seen = GREATEST(addresses.last_seen, EXCLUDED.last_seen)`, args...)
+	return err
+}
+
+func upsertPosts(ctx context.Context, tx *sql.Tx, readings []models.SensorData) error {
+	type postKey struct{ address, postID int }
+	posts := make(map[postKey]*seen)
+	for _, data := range readings {
+		key := postKey{data.Meta.Address, data.Meta.PostID}
+		s, ok := posts[key]
+		if !ok {
+			s = &seen{}
+			posts[key] = s
+		}
+		s.add(measuredAt(data), data.Meta.Recipient)
+	}
+	keys := make([]postKey, 0, len(posts))
+	for key := range posts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].address != keys[j].address {
+			return keys[i].address < keys[j].address
+		}
+		return keys[i].postID < keys[j].postID
+	})
+
+	var values []string
+	var args []interface{}
+	for _, key := range keys {
+		s := posts[key]
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5))
+		args = append(args, key.address, key.postID, s.recipient, s.first, s.last)
+	}
+	// Получатель поста - из его последнего показания
+	_, err := tx.ExecContext(ctx, `INSERT INTO posts (address, post_id, recipient, first_seen, last_seen) VALUES `+
+		strings.Join(values, ", ")+`
+		ON CONFLICT (address, post_id) DO UPDATE SET
+			recipient = CASE WHEN EXCLUDED.last_seen >= posts.last_seen THEN EXCLUDED.recipient ELSE posts.recipient END,
+			first_seen = LEAST(posts.first_seen, EXCLUDED.first_seen),
+			last_seen = GREATEST(posts.last_seen, EXCLUDED.last_seen)`, args...)
+	return err
+}
+
+func insertReadings(ctx context.Context, tx *sql.Tx, readings []models.SensorData) error {
+	var values []string
+	var args []interface{}
+	for _, data := range readings {
+		document, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("показание %s: %v", data.ID, err)
+		}
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7))
+		args = append(args, data.ID, data.Meta.Address, data.Meta.PostID, data.Meta.Recipient,
+			measuredAt(data), data.SchemaVersion, string(document))
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO readings
+		(id, address, post_id, recipient, measured_at, schema_version, document) VALUES `+
+		strings.Join(values, ", ")+` ON CONFLICT (id) DO NOTHING`, args...)
+	return err
+}
+
+// Query возвращает показания, подходящие под условия, по возрастанию времени
+func (r *PostgresRepository) Query(ctx context.Context, f ReadingFilter) ([]models.SensorData, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Recipient != "" {
+		add("recipient = $%d", f.Recipient)
+	}
+	if f.Address != 0 {
+		add("address = $%d", f.Address)
+	}
+	if f.PostID != 0 {
+		add("post_id = $%d", f.PostID)
+	}
+	if !f.From.IsZero() {
+		add("measured_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("measured_at < $%d", f.To)
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	query := `SELECT document FROM readings`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(` ORDER BY measured_at, id LIMIT $%d`, len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var readings []models.SensorData
+	for rows.Next() {
+		var document []byte
+		if err := rows.Scan(&document); err != nil {
+			return nil, err
+		}
+		var data models.SensorData
+		if err := json.Unmarshal(document, &data); err != nil {
+			return nil, err
+		}
+		readings = append(readings, data)
+	}
+	return readings, rows.Err()
+}
+
+// ReadingsByRecipient возвращает показания получателя за период [from, to)
+func (r *PostgresRepository) ReadingsByRecipient(ctx context.Context, recipient string, from, to time.Time) ([]models.SensorData, error) {
+	return r.Query(ctx, ReadingFilter{Recipient: recipient, From: from, To: to})
+}
+
+// ReadingsByPost возвращает показания поста за период [from, to)
+func (r *PostgresRepository) ReadingsByPost(ctx context.Context, address, postID int, from, to time.Time) ([]models.SensorData, error) {
+	return r.Query(ctx, ReadingFilter{Address: address, PostID: postID, From: from, To: to})
+}
+
+// ReadingsByAddress возвращает показания всех постов адреса за период [from, to)
+func (r *PostgresRepository) ReadingsByAddress(ctx context.Context, address int, from, to time.Time) ([]models.SensorData, error) {
+	return r.Query(ctx, ReadingFilter{Address: address, From: from, To: to})
+}
+
+// ReadingsInRange возвращает все показания за период [from, to)
+func (r *PostgresRepository) ReadingsInRange(ctx context.Context, from, to time.Time) ([]models.SensorData, error) {
+	return r.Query(ctx, ReadingFilter{From: from, To: to})
+}
+
+// Addresses возвращает адреса с числом постов
+func (r *PostgresRepository) Addresses(ctx context.Context) ([]Address, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT a.address, COUNT(p.post_id), a.first_seen, a.last_seen
+		FROM addresses a LEFT JOIN posts p ON p.address = a.address
+		GROUP BY a.address ORDER BY a.address`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addresses []Address
+	for rows.Next() {
+		var a Address
+		if err := rows.Scan(&a.Address, &a.Posts, &a.FirstSeen, &a.LastSeen); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses, rows.Err()
+}
+
+// Posts возвращает посты адреса; address == 0 - посты всех адресов
+func (r *PostgresRepository) Posts(ctx context.Context, address int) ([]Post, error) {
+	query := `SELECT address, post_id, recipient, first_seen, last_seen FROM posts`
+	var args []interface{}
+	if address != 0 {
+		query += ` WHERE address = $1`
+		args = append(args, address)
+	}
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY address, post_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		var p Post
+		if err := rows.Scan(&p.Address, &p.PostID, &p.Recipient, &p.FirstSeen, &p.LastSeen); err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// InsertRollups сохраняет сводки одной транзакцией. Сводка того же окна,
+// поста, получателя и части заменяет сохраненную.
+func (r *PostgresRepository) InsertRollups(ctx context.Context, rollups []models.Rollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(rollups); start += insertChunk {
+		end := start + insertChunk
+		if end > len(rollups) {
+			end = len(rollups)
+		}
+		if err := insertRollups(ctx, tx, rollups[start:end]); err != nil {
+			return fmt.Errorf("ошибка записи сводок: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func insertRollups(ctx context.Context, tx *sql.Tx, rollups []models.Rollup) error {
+	// Одна сводка дважды в одном INSERT ... ON CONFLICT DO UPDATE недопустима
+	latest := make(map[string]int, len(rollups))
+	for i, rollup := range rollups {
+		latest[rollup.ID()] = i
+	}
+
+	var values []string
+	var args []interface{}
+	for i, rollup := range rollups {
+		if latest[rollup.ID()] != i {
+			continue
+		}
+		document, err := json.Marshal(rollup)
+		if err != nil {
+			return fmt.Errorf("сводка %s: %v", rollup.ID(), err)
+		}
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9))
+		args = append(args, rollup.Recipient, rollup.Address, rollup.PostID, rollup.Window,
+			rollup.Start, rollup.Part, rollup.End, rollup.Partial, string(document))
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO rollups
+		(recipient, address, post_id, window_size, window_start, part, window_end, partial, document) VALUES `+
+		strings.Join(values, ", ")+`
+		ON CONFLICT (recipient, address, post_id, window_size, window_start, part) DO UPDATE SET
+			window_end = EXCLUDED.window_end,
+			partial = EXCLUDED.partial,
+			document = EXCLUDED.document`, args...)
+	return err
+}
+
+// QueryRollups возвращает сводки, подходящие под условия, по возрастанию
+// начала окна
+func (r *PostgresRepository) QueryRollups(ctx context.Context, f RollupFilter) ([]models.Rollup, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Window != "" {
+		add("window_size = $%d", f.Window)
+	}
+	if f.Recipient != "" {
+		add("recipient = $%d", f.Recipient)
+	}
+	if f.Address != 0 {
+		add("address = $%d", f.Address)
+	}
+	if f.PostID != nil {
+		add("post_id = $%d", *f.PostID)
+	}
+	if !f.From.IsZero() {
+		add("window_start >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("window_start < $%d", f.To)
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	query := `SELECT document FROM rollups`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(` ORDER BY window_start, window_size, address, post_id, part LIMIT $%d`, len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rollups []models.Rollup
+	for rows.Next() {
+		var document []byte
+		if err := rows.Scan(&document); err != nil {
+			return nil, err
+		}
+		var rollup models.Rollup
+		if err := json.Unmarshal(document, &rollup); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, rollup)
+	}
+	return rollups, rows.Err()
+}
@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"big_go/internal/models"
+	"big_go/internal/services"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrBufferFull возвращается Save, когда буфер записи заполнен
+var ErrBufferFull = errors.New("буфер записи истории заполнен")
+
+// WriterOptions - параметры пакетной записи
+type WriterOptions struct {
+	BatchSize     int           // наибольший пакет
+	FlushInterval time.Duration // наибольшее время ожидания неполного пакета
+	Buffer        int           // сколько показаний (и отдельно сводок) ждет записи; при переполнении новые отбрасываются
+	RetryMin      time.Duration // задержка повтора после ошибки базы
+	RetryMax      time.Duration
+}
+
+// WriterStats - счетчики записи истории
+type WriterStats struct {
+	SchemaVersion int       `json:"schema_version"` // 0 - миграции еще не применены
+	Buffered      int       `json:"buffered"`
+	Written       uint64    `json:"written"`  // записано показаний
+	Rollups       uint64    `json:"rollups"`  // записано сводок
+	Batches       uint64    `json:"batches"`  // записано пакетов
+	Dropped       uint64    `json:"dropped"`  // отброшено из-за переполнения буфера
+	Failures      uint64    `json:"failures"` // неудачные попытки записи пакета
+	LastError     string    `json:"last_error,omitempty"`
+	LastWrite     time.Time `json:"last_write,omitempty"`
+}
+
+// Writer записывает показания в PostgresRepository пакетами в фоне. Save
+// не ждет базы: показания копятся в буфере и записываются, когда набирается
+// пакет или проходит FlushInterval. Пока база недоступна, пакет повторяется
+// с нарастающей задержкой, а показания копятся в буфере. Сводки
+// (SaveRollup) записываются так же, после показаний.
+type Writer struct {
+	repo *PostgresRepository
+	opts WriterOptions
+
+	mu      sync.Mutex
+	buf     []models.SensorData
+	rollups []models.Rollup
+	stats   WriterStats
+	wake    chan struct{}
+}
+
+// NewWriter создает пакетную запись в repo
+func NewWriter(repo *PostgresRepository, opts WriterOptions) *Writer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	return &Writer{repo: repo, opts: opts, wake: make(chan struct{}, 1)}
+}
+
+// Save ставит показание в очередь записи
+func (w *Writer) Save(data models.SensorData) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.opts.Buffer > 0 && len(w.buf) >= w.opts.Buffer {
+		w.stats.Dropped++
+		return ErrBufferFull
+	}
+	w.buf = append(w.buf, data)
+	if len(w.buf) >= w.opts.BatchSize {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// SaveRollup ставит сводку в очередь записи
+func (w *Writer) SaveRollup(rollup models.Rollup) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.opts.Buffer > 0 && len(w.rollups) >= w.opts.Buffer {
+		w.stats.Dropped++
+		return ErrBufferFull
+	}
+	w.rollups = append(w.rollups, rollup)
+	return nil
+}
+
+// Stats возвращает счетчики записи; Buffered - показания и сводки
+func (w *Writer) Stats() WriterStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.Buffered = len(w.buf) + len(w.rollups)
+	return stats
+}
+
+// Run применяет миграции и записывает показания до отмены ctx; при
+// остановке пытается записать остаток буфера
+func (w *Writer) Run(ctx context.Context) {
+	retry := services.NewBackoff(w.opts.RetryMin, w.opts.RetryMax)
+	for {
+		version, err := w.repo.Migrate(ctx)
+		if err == nil {
+			w.mu.Lock()
+			w.stats.SchemaVersion = version
+			w.mu.Unlock()
+			log.Printf("Схема истории показаний в PostgreSQL: версия %d", version)
+			break
+		}
+		if !w.wait(ctx, retry.Next(), err, "Ошибка подготовки схемы PostgreSQL") {
+			return
+		}
+	}
+	retry.Reset()
+
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			w.drain()
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+
+		for w.Stats().Buffered > 0 {
+			err := w.flush(ctx)
+			if err == nil {
+				retry.Reset()
+				continue
+			}
+			if !w.wait(ctx, retry.Next(), err, "Ошибка записи истории показаний") {
+				w.drain()
+				return
+			}
+		}
+	}
+}
+
+// flush записывает один пакет из начала буфера показаний, а когда он
+// пуст - пакет сводок
+func (w *Writer) flush(ctx context.Context) error {
+	w.mu.Lock()
+	n := len(w.buf)
+	if n > w.opts.BatchSize {
+		n = w.opts.BatchSize
+	}
+	batch := w.buf[:n]
+	w.mu.Unlock()
+	if n == 0 {
+		return w.flushRollups(ctx)
+	}
+
+	if err := w.repo.Insert(ctx, batch); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.buf = append(w.buf[:0:0], w.buf[n:]...)
+	w.stats.Written += uint64(n)
+	w.stats.Batches++
+	w.stats.LastWrite = time.Now()
+	w.stats.LastError = ""
+	w.mu.Unlock()
+	return nil
+}
+
+// flushRollups записывает один пакет из начала буфера сводок
+func (w *Writer) flushRollups(ctx context.Context) error {
+	w.mu.Lock()
+	n := len(w.rollups)
+	if n > w.opts.BatchSize {
+		n = w.opts.BatchSize
+	}
+	batch := w.rollups[:n]
+	w.mu.Unlock()
+
+	if err := w.repo.InsertRollups(ctx, batch); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.rollups = append(w.rollups[:0:0], w.rollups[n:]...)
+	w.stats.Rollups += uint64(n)
+	w.stats.Batches++
+	w.stats.LastWrite = time.Now()
+	w.stats.LastError = ""
+	w.mu.Unlock()
+	return nil
+}
+
+// wait учитывает ошибку и ждет d; false - ctx отменен
+func (w *Writer) wait(ctx context.Context, d time.Duration, err error, what string) bool {
+	w.mu.Lock()
+	w.stats.Failures++
+	w.stats.LastError = err.Error()
+	w.mu.Unlock()
+	log.Printf("%s: %v; повтор через %s", what, err, d)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// drain пытается записать остаток буфера при остановке
+func (w *Writer) drain() {
+	if w.Stats().SchemaVersion == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for w.Stats().Buffered > 0 {
+		if err := w.flush(ctx); err != nil {
+			log.Printf("История показаний не записана при остановке (%d показаний): %v", w.Stats().Buffered, err)
+			return
+		}
+	}
+}
@@ -12,7 +12,7 @@ import (
 func SetupRoutes(r *gin.Engine, PageTitle string) {
 	fmt.Println("Setting up routes with page title:", PageTitle)
 	r.GET("/", func(c *gin.Context) {
-		fmt.Println("Handling request for / %s", PageTitle)
+		fmt.Printf("Handling request for / %s\n", PageTitle)
 		handlers.IndexHandler(PageTitle)(c)
 	})
 }
@@ -22,7 +22,7 @@ func SetupInitLogsRoute(r *gin.Engine, PageTitle, initLogs string) {
 	fmt.Println("Setting up /init_logs route with page title:", PageTitle, "and initLogs:", initLogs)
 
 	r.GET("/init_logs", func(c *gin.Context) {
-		fmt.Println("Handling request for /init_logs %s", PageTitle)
+		fmt.Printf("Handling request for /init_logs %s\n", PageTitle)
 		handlers.InitLogsHandler(PageTitle, initLogs)(c)
 	})
 }
@@ -0,0 +1,307 @@
+package collector
+
+import (
+	"big_go/internal/models"
+	"container/list"
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AggregatorOptions - параметры сводок
+type AggregatorOptions struct {
+	Windows   []time.Duration // длительности окон, например 1m, 5m, 1h
+	Lateness  time.Duration   // сколько окно ждет опоздавших показаний после своего конца
+	MaxSeries int             // наибольшее число открытых окон (0 - без ограничения)
+}
+
+// AggregatorStats - состояние сводок
+type AggregatorStats struct {
+	Open      int       `json:"open"`      // открытые окна
+	Emitted   uint64    `json:"emitted"`   // выпущенные сводки
+	Late      uint64    `json:"late"`      // показания, пришедшие после закрытия своего окна
+	Evicted   uint64    `json:"evicted"`   // части окон, закрытые раньше срока из-за MaxSeries
+	Watermark time.Time `json:"watermark"` // время показаний, до которого окна считаются полными
+}
+
+// seriesKey - окно сводки поста (post > 0) или адреса (post == 0) одного получателя
+type seriesKey struct {
+	window    time.Duration
+	start     int64 // начало окна, Unix мс
+	recipient string
+	address   int
+	post      int
+}
+
+// metricAcc - накопленная статистика метрики
+type metricAcc struct {
+	count    int
+	min, max float64
+	sum      float64
+	last     float64
+	lastAt   time.Time
+	unit     string
+}
+
+// series - открытое окно
+type series struct {
+	key     seriesKey
+	metrics map[string]*metricAcc
+	elem    *list.Element // место в Aggregator.lru
+}
+
+// Aggregator строит сводки показаний по неперекрывающимся окнам времени
+// показаний (MetaData.Timestamp) для каждого поста и для каждого адреса
+// (по всем постам адреса одного получателя). Окно закрывается, когда
+// водяной знак - наибольшее время принятых показаний, сдвигаемое вперед
+// по часам коллектора, пока показаний нет, - проходит конец окна плюс
+// Lateness; показание, окно которого уже закрыто, не учитывается (Late).
+// Если открыто MaxSeries окон, окно, которое дольше всех не обновлялось,
+// закрывается раньше срока: его сводка и сводки следующих частей того же
+// окна выпускаются с Partial и номерами Part. Открытые окна хранятся в
+// памяти: при остановке коллектора они теряются.
+type Aggregator struct {
+	opts AggregatorOptions
+	now  func() time.Time
+
+	mu       sync.Mutex
+	series   map[seriesKey]*series
+	lru      *list.List        // открытые окна, в начале - последнее обновленное
+	parts    map[seriesKey]int // сколько частей окна уже выпущено раньше срока
+	maxEvent time.Time         // наибольшее время принятого показания
+	arrived  time.Time         // когда оно принято
+	stats    AggregatorStats
+}
+
+// NewAggregator создает построитель сводок
+func NewAggregator(opts AggregatorOptions) *Aggregator {
+	return &Aggregator{
+		opts:   opts,
+		now:    time.Now,
+		series: make(map[seriesKey]*series),
+		lru:    list.New(),
+		parts:  make(map[seriesKey]int),
+	}
+}
+
+// watermark возвращает водяной знак; вызывается под a.mu
+func (a *Aggregator) watermark(now time.Time) time.Time {
+	if a.maxEvent.IsZero() {
+		return time.Time{}
+	}
+	return a.maxEvent.Add(now.Sub(a.arrived))
+}
+
+// Add учитывает показание во всех окнах; закрытые из-за MaxSeries окна
+// возвращаются для выпуска
+func (a *Aggregator) Add(data models.SensorData) []models.Rollup {
+	ts := data.Meta.Timestamp
+	if ts.IsZero() {
+		return nil
+	}
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	watermark := a.watermark(now)
+	// Показание из будущего не сдвигает водяной знак дальше текущего
+	// времени, иначе все остальные показания оказались бы опоздавшими
+	if event := minTime(ts, now); event.After(watermark) {
+		a.maxEvent, a.arrived = event, now
+	}
+
+	var evicted []models.Rollup
+	late := false
+	for _, window := range a.opts.Windows {
+		start := windowStart(ts, window)
+		if !watermark.IsZero() && !start.Add(window+a.opts.Lateness).After(watermark) {
+			late = true
+			continue
+		}
+		for _, post := range []int{data.Meta.PostID, 0} {
+			key := seriesKey{
+				window:    window,
+				start:     start.UnixMilli(),
+				recipient: data.Meta.Recipient,
+				address:   data.Meta.Address,
+				post:      post,
+			}
+			s, ok := a.series[key]
+			if !ok {
+				if a.opts.MaxSeries > 0 && len(a.series) >= a.opts.MaxSeries {
+					if rollup, ok := a.evict(); ok {
+						evicted = append(evicted, rollup)
+					}
+				}
+				s = &series{key: key, metrics: make(map[string]*metricAcc)}
+				s.elem = a.lru.PushFront(s)
+				a.series[key] = s
+			} else {
+				a.lru.MoveToFront(s.elem)
+			}
+			s.add(data)
+		}
+	}
+	if late {
+		a.stats.Late++
+	}
+	return evicted
+}
+
+func (s *series) add(data models.SensorData) {
+	for name, m := range data.Data {
+		if m.Quality == models.QualityBad || math.IsNaN(m.Value) {
+			continue
+		}
+		acc, ok := s.metrics[name]
+		if !ok {
+			acc = &metricAcc{min: m.Value, max: m.Value, unit: m.Unit}
+			s.metrics[name] = acc
+		}
+		acc.count++
+		acc.sum += m.Value
+		acc.min = math.Min(acc.min, m.Value)
+		acc.max = math.Max(acc.max, m.Value)
+		if !data.Meta.Timestamp.Before(acc.lastAt) {
+			acc.last, acc.lastAt = m.Value, data.Meta.Timestamp
+		}
+	}
+}
+
+// evict закрывает окно, которое дольше всех не обновлялось, и возвращает
+// его сводку; false - в окне нет значений. Вызывается под a.mu.
+func (a *Aggregator) evict() (models.Rollup, bool) {
+	oldest := a.lru.Remove(a.lru.Back()).(*series)
+	delete(a.series, oldest.key)
+	a.stats.Evicted++
+	if len(oldest.metrics) == 0 {
+		return models.Rollup{}, false
+	}
+	a.parts[oldest.key]++
+	a.stats.Emitted++
+	rollup := oldest.rollup()
+	rollup.Partial, rollup.Part = true, a.parts[oldest.key]
+	return rollup, true
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// windowStart возвращает начало окна длительностью window, в которое
+// попадает ts. Окна отсчитываются от начала эпохи Unix: time.Truncate
+// отсчитывает их от нулевого времени Go, и окна, на которые не делятся
+// сутки (например 7m), могли бы оказаться сдвинуты относительно эпохи.
+func windowStart(ts time.Time, window time.Duration) time.Time {
+	n := ts.UnixNano()
+	offset := n % int64(window)
+	if offset < 0 {
+		offset += int64(window)
+	}
+	return time.Unix(0, n-offset)
+}
+
+func (s *series) rollup() models.Rollup {
+	start := time.UnixMilli(s.key.start).UTC()
+	r := models.Rollup{
+		Window:    models.FormatWindow(s.key.window),
+		Start:     start,
+		End:       start.Add(s.key.window),
+		Recipient: s.key.recipient,
+		Address:   s.key.address,
+		PostID:    s.key.post,
+		Metrics:   make(map[string]models.MetricRollup, len(s.metrics)),
+	}
+	for name, acc := range s.metrics {
+		r.Metrics[name] = models.MetricRollup{
+			Count: acc.count,
+			Min:   acc.min,
+			Max:   acc.max,
+			Avg:   acc.sum / float64(acc.count),
+			Last:  acc.last,
+			Unit:  acc.unit,
+		}
+	}
+	return r
+}
+
+// Close закрывает окна, конец которых вместе с Lateness прошел водяной
+// знак, и возвращает их сводки по времени начала
+func (a *Aggregator) Close() []models.Rollup {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	watermark := a.watermark(a.now())
+	var closed []*series
+	for key, s := range a.series {
+		end := time.UnixMilli(key.start).Add(key.window + a.opts.Lateness)
+		if !end.After(watermark) {
+			closed = append(closed, s)
+			delete(a.series, key)
+			a.lru.Remove(s.elem)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool {
+		ki, kj := closed[i].key, closed[j].key
+		if ki.start != kj.start {
+			return ki.start < kj.start
+		}
+		if ki.window != kj.window {
+			return ki.window < kj.window
+		}
+		if ki.address != kj.address {
+			return ki.address < kj.address
+		}
+		return ki.post < kj.post
+	})
+	rollups := make([]models.Rollup, 0, len(closed))
+	for _, s := range closed {
+		if len(s.metrics) == 0 {
+			continue
+		}
+		rollup := s.rollup()
+		// Остаток окна, части которого уже выпущены, - следующая часть
+		if n, ok := a.parts[s.key]; ok {
+			rollup.Partial, rollup.Part = true, n+1
+		}
+		rollups = append(rollups, rollup)
+	}
+	// Окна, вытесненные и больше не открывавшиеся, тоже закрыты
+	for key := range a.parts {
+		if !time.UnixMilli(key.start).Add(key.window + a.opts.Lateness).After(watermark) {
+			delete(a.parts, key)
+		}
+	}
+	a.stats.Emitted += uint64(len(rollups))
+	return rollups
+}
+
+// Run закрывает окна раз в секунду и передает сводки emit до отмены ctx
+func (a *Aggregator) Run(ctx context.Context, emit func(models.Rollup)) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, rollup := range a.Close() {
+				emit(rollup)
+			}
+		}
+	}
+}
+
+// Stats возвращает состояние сводок
+func (a *Aggregator) Stats() AggregatorStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats := a.stats
+	stats.Open = len(a.series)
+	stats.Watermark = a.watermark(a.now())
+	return stats
+}
@@ -0,0 +1,28 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowStart(t *testing.T) {
+	tests := []struct {
+		ts     time.Time
+		window time.Duration
+		want   time.Time
+	}{
+		{time.Unix(1700000010, 0), time.Minute, time.Unix(1699999980, 0)},
+		{time.Unix(1700000000, 0), time.Hour, time.Unix(1699999200, 0)},
+		{time.Unix(1700000000, 0).In(time.FixedZone("MSK", 3*3600)), time.Hour, time.Unix(1699999200, 0)},
+		// Окна, на которые не делятся сутки, тоже отсчитываются от эпохи
+		{time.Unix(1000, 0), 7 * time.Minute, time.Unix(840, 0)},
+		{time.Unix(1700000000, 0), 7 * time.Minute, time.Unix(1699999980, 0)},
+		{time.Unix(840, 0), 7 * time.Minute, time.Unix(840, 0)},
+		{time.Unix(-1, 0), time.Minute, time.Unix(-60, 0)},
+	}
+	for _, tt := range tests {
+		if got := windowStart(tt.ts, tt.window); !got.Equal(tt.want) {
+			t.Errorf("windowStart(%d, %s) = %d, want %d", tt.ts.Unix(), tt.window, got.Unix(), tt.want.Unix())
+		}
+	}
+}
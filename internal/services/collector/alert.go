@@ -0,0 +1,431 @@
+package collector
+
+import (
+	"big_go/config"
+	"big_go/internal/filter"
+	"big_go/internal/models"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertEvent - срабатывание или снятие тревоги и адресаты уведомления
+// (имена из раздела notifiers таблицы маршрутов или config.NotifyRecipient)
+type AlertEvent struct {
+	Alert  models.Alert
+	Notify []string
+}
+
+// AlertStats - состояние тревог
+type AlertStats struct {
+	Rules    int    `json:"rules"`
+	Pending  int    `json:"pending"`  // ожидают срабатывания
+	Firing   int    `json:"firing"`   // сработали
+	Fired    uint64 `json:"fired"`    // срабатывания с запуска коллектора
+	Resolved uint64 `json:"resolved"` // снятия с запуска коллектора
+}
+
+// alertRule - проверенное правило с разобранным условием отбора показаний
+type alertRule struct {
+	config.AlertRule
+	when *filter.Filter // nil - все показания
+}
+
+// alertKey - тревога правила в области: посте, адресе (post == 0) или
+// получателе (address == 0, post == 0)
+type alertKey struct {
+	rule      string
+	recipient string
+	address   int
+	post      int
+}
+
+// sample - значение метрики во время показания
+type sample struct {
+	at    time.Time
+	value float64
+}
+
+// postCondition - условие правила для одного поста области
+type postCondition struct {
+	met     bool
+	seen    time.Time // последнее показание поста по часам коллектора
+	samples []sample  // значения за окно скорости изменения (только rate)
+}
+
+// alertSeries - тревога области и условия ее постов
+type alertSeries struct {
+	rule  *alertRule
+	alert models.Alert // State == "" - условие не выполняется
+	posts map[postKey]*postCondition
+}
+
+// AlertEngine проверяет правила тревог на каждом принятом показании и
+// ведет состояние тревоги для каждого правила и области: pending, пока
+// условие выполняется меньше For, firing после этого и resolved после
+// снятия условия. Время For и устаревание постов (Stale) отсчитываются по
+// часам коллектора, скорость изменения - по времени показаний. Состояние
+// хранится в памяти: после перезапуска коллектора тревоги начинаются
+// заново.
+type AlertEngine struct {
+	rules []*alertRule
+	now   func() time.Time
+
+	mu       sync.Mutex
+	series   map[alertKey]*alertSeries
+	fired    uint64
+	resolved uint64
+}
+
+// NewAlertEngine создает проверку тревог по проверенным правилам
+// (config.LoadAlerting)
+func NewAlertEngine(cfg *config.AlertingConfig) (*AlertEngine, error) {
+	e := &AlertEngine{now: time.Now, series: make(map[alertKey]*alertSeries)}
+	for _, rule := range cfg.Rules {
+		r := &alertRule{AlertRule: rule}
+		if rule.When != "" {
+			when, err := filter.Compile(rule.When)
+			if err != nil {
+				return nil, fmt.Errorf("правило %s: %v", rule.Name, err)
+			}
+			r.when = when
+		}
+		e.rules = append(e.rules, r)
+	}
+	return e, nil
+}
+
+// LoadAlertEngine загружает правила тревог из файла и создает по ним проверку
+func LoadAlertEngine(filename string) (*AlertEngine, error) {
+	cfg, err := config.LoadAlerting(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewAlertEngine(cfg)
+}
+
+// Notifiers возвращает адресатов уведомлений всех правил, кроме
+// config.NotifyRecipient
+func (e *AlertEngine) Notifiers() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, rule := range e.rules {
+		for _, name := range rule.Notify {
+			if name != config.NotifyRecipient && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Evaluate проверяет правила на показании и возвращает срабатывания и
+// снятия тревог
+func (e *AlertEngine) Evaluate(data models.SensorData) []AlertEvent {
+	now := e.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var events []AlertEvent
+	for _, rule := range e.rules {
+		m, ok := data.Data[rule.Metric]
+		if !ok || m.Quality == models.QualityBad || math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+			continue
+		}
+		if rule.when != nil && !rule.when.Match(data) {
+			continue
+		}
+
+		s := e.seriesFor(rule, data)
+		post := postKey{data.Meta.Address, data.Meta.PostID}
+		cond, ok := s.posts[post]
+		if !ok {
+			cond = &postCondition{}
+			s.posts[post] = cond
+		}
+		if rule.Kind == config.AlertRate && cond.outdated(data.Meta.Timestamp) {
+			continue
+		}
+		cond.seen = now
+		// Скорость неизвестна, если предыдущие значения вышли из окна:
+		// условие поста по ней больше не выполняется
+		value, ok := rule.measure(cond, data.Meta.Timestamp, m.Value)
+		if ok {
+			cond.met = rule.exceeds(value, cond.met)
+		} else {
+			value, cond.met = s.alert.Value, false
+		}
+		if event, ok := e.transition(s, now, value, cond.met); ok {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// seriesFor возвращает тревогу области показания; вызывается под e.mu
+func (e *AlertEngine) seriesFor(rule *alertRule, data models.SensorData) *alertSeries {
+	key := alertKey{rule: rule.Name, recipient: data.Meta.Recipient}
+	switch rule.Scope {
+	case config.AlertScopePost:
+		key.address, key.post = data.Meta.Address, data.Meta.PostID
+	case config.AlertScopeAddress:
+		key.address = data.Meta.Address
+	}
+	s, ok := e.series[key]
+	if !ok {
+		s = &alertSeries{
+			rule: rule,
+			alert: models.Alert{
+				Rule:      rule.Name,
+				Severity:  rule.Severity,
+				Scope:     rule.Scope,
+				Recipient: key.recipient,
+				Address:   key.address,
+				PostID:    key.post,
+				Metric:    rule.Metric,
+			},
+			posts: make(map[postKey]*postCondition),
+		}
+		e.series[key] = s
+	}
+	return s
+}
+
+// outdated сообщает, что показание не новее уже учтенных в скорости
+// изменения
+func (c *postCondition) outdated(at time.Time) bool {
+	n := len(c.samples)
+	return n > 0 && !at.After(c.samples[n-1].at)
+}
+
+// measure возвращает проверяемую величину: значение метрики или скорость
+// его изменения в минуту от самого старого значения в окне Window; false -
+// в окне нет более раннего значения и скорость неизвестна
+func (r *alertRule) measure(cond *postCondition, at time.Time, value float64) (float64, bool) {
+	if r.Kind != config.AlertRate {
+		return value, true
+	}
+	cond.samples = append(cond.samples, sample{at: at, value: value})
+	cutoff := at.Add(-r.Window.Duration)
+	i := 0
+	for i < len(cond.samples)-1 && cond.samples[i].at.Before(cutoff) {
+		i++
+	}
+	cond.samples = cond.samples[i:]
+	if len(cond.samples) < 2 {
+		return 0, false
+	}
+	first := cond.samples[0]
+	return (value - first.value) / at.Sub(first.at).Minutes(), true
+}
+
+// exceeds сообщает, выполняется ли условие для величины v; выполнявшееся
+// условие (met) снимается, только когда v вернется за границу на Hysteresis
+func (r *alertRule) exceeds(v float64, met bool) bool {
+	var margin float64
+	if met {
+		margin = r.Hysteresis
+	}
+	return (r.Above != nil && v > *r.Above-margin) || (r.Below != nil && v < *r.Below+margin)
+}
+
+// describe возвращает текст уведомления
+func (r *alertRule) describe(alert models.Alert) string {
+	what := r.Metric
+	if r.Kind == config.AlertRate {
+		what = "скорость изменения " + r.Metric + " (в минуту)"
+	}
+	if alert.State == models.AlertResolved {
+		return fmt.Sprintf("%s = %s, условие снято", what, formatValue(alert.Value))
+	}
+	var bounds []string
+	if r.Above != nil {
+		bounds = append(bounds, fmt.Sprintf("> %g", *r.Above))
+	}
+	if r.Below != nil {
+		bounds = append(bounds, fmt.Sprintf("< %g", *r.Below))
+	}
+	return fmt.Sprintf("%s = %s, условие %s", what, formatValue(alert.Value), strings.Join(bounds, " или "))
+}
+
+// formatValue округляет величину до тысячных для текста уведомления
+func formatValue(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1000)/1000, 'f', -1, 64)
+}
+
+// transition переводит тревогу области по условиям ее постов после
+// показания поста с величиной value (postMet - условие этого поста) и
+// возвращает событие, если тревога сработала или снята. В тревоге
+// остается величина последнего поста, для которого условие выполняется, а
+// если таких нет - последняя. Вызывается под e.mu.
+func (e *AlertEngine) transition(s *alertSeries, now time.Time, value float64, postMet bool) (AlertEvent, bool) {
+	var posts []string
+	for key, cond := range s.posts {
+		if cond.met {
+			posts = append(posts, fmt.Sprintf("%d/%d", key.address, key.post))
+		}
+	}
+	sort.Strings(posts)
+	met := len(posts) > 0
+	if postMet || !met {
+		s.alert.Value = value
+	}
+	if s.rule.Scope != config.AlertScopePost {
+		s.alert.Posts = posts
+	}
+
+	a := &s.alert
+	switch a.State {
+	case "", models.AlertResolved:
+		if !met {
+			return AlertEvent{}, false
+		}
+		a.State, a.Since, a.FiredAt, a.ResolvedAt = models.AlertPending, now, nil, nil
+		return e.fireDue(s, now)
+	case models.AlertPending:
+		if !met {
+			a.State = ""
+			return AlertEvent{}, false
+		}
+		return e.fireDue(s, now)
+	case models.AlertFiring:
+		if met {
+			return AlertEvent{}, false
+		}
+		a.State, a.ResolvedAt = models.AlertResolved, &now
+		e.resolved++
+		return e.event(s), true
+	}
+	return AlertEvent{}, false
+}
+
+// fireDue переводит ожидающую тревогу в firing, если условие выполняется
+// не меньше For; вызывается под e.mu
+func (e *AlertEngine) fireDue(s *alertSeries, now time.Time) (AlertEvent, bool) {
+	if now.Sub(s.alert.Since) < s.rule.For.Duration {
+		return AlertEvent{}, false
+	}
+	s.alert.State, s.alert.FiredAt = models.AlertFiring, &now
+	e.fired++
+	return e.event(s), true
+}
+
+// event возвращает копию тревоги для уведомления; вызывается под e.mu
+func (e *AlertEngine) event(s *alertSeries) AlertEvent {
+	alert := s.alert
+	alert.Posts = append([]string(nil), s.alert.Posts...)
+	alert.Message = s.rule.describe(alert)
+	s.alert.Message = alert.Message
+	return AlertEvent{Alert: alert, Notify: s.rule.Notify}
+}
+
+// Tick снимает условия постов, не присылавших метрику дольше Stale, и
+// переводит в firing тревоги, условие которых выполняется уже не меньше
+// For, даже если новых показаний не было
+func (e *AlertEngine) Tick() []AlertEvent {
+	now := e.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var events []AlertEvent
+	for key, s := range e.series {
+		if e.expire(s, now) {
+			if event, ok := e.transition(s, now, s.alert.Value, false); ok {
+				events = append(events, event)
+			}
+			if len(s.posts) == 0 && s.alert.State == "" {
+				delete(e.series, key)
+			}
+			continue
+		}
+		if s.alert.State != models.AlertPending {
+			continue
+		}
+		if event, ok := e.fireDue(s, now); ok {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// expire удаляет условия постов, не присылавших метрику дольше Stale, и
+// сообщает, были ли такие; вызывается под e.mu
+func (e *AlertEngine) expire(s *alertSeries, now time.Time) bool {
+	expired := false
+	for key, cond := range s.posts {
+		if now.Sub(cond.seen) > s.rule.Stale.Duration {
+			delete(s.posts, key)
+			expired = true
+		}
+	}
+	return expired
+}
+
+// Run проверяет ожидающие тревоги и устаревшие посты раз в секунду и
+// передает срабатывания и снятия emit до отмены ctx
+func (e *AlertEngine) Run(ctx context.Context, emit func(AlertEvent)) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, event := range e.Tick() {
+				emit(event)
+			}
+		}
+	}
+}
+
+// Alerts возвращает тревоги в состоянии state (пусто - во всех) по правилу
+// и области
+func (e *AlertEngine) Alerts(state string) []models.Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	alerts := []models.Alert{}
+	for _, s := range e.series {
+		if s.alert.State == "" || (state != "" && s.alert.State != state) {
+			continue
+		}
+		alert := s.alert
+		alert.Posts = append([]string(nil), s.alert.Posts...)
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		a, b := alerts[i], alerts[j]
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		if a.Recipient != b.Recipient {
+			return a.Recipient < b.Recipient
+		}
+		if a.Address != b.Address {
+			return a.Address < b.Address
+		}
+		return a.PostID < b.PostID
+	})
+	return alerts
+}
+
+// Stats возвращает состояние тревог
+func (e *AlertEngine) Stats() AlertStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stats := AlertStats{Rules: len(e.rules), Fired: e.fired, Resolved: e.resolved}
+	for _, s := range e.series {
+		switch s.alert.State {
+		case models.AlertPending:
+			stats.Pending++
+		case models.AlertFiring:
+			stats.Firing++
+		}
+	}
+	return stats
+}
@@ -0,0 +1,167 @@
+package collector
+
+import (
+	"big_go/config"
+	"big_go/internal/models"
+	"testing"
+	"time"
+)
+
+// newTestAlertEngine создает проверку тревог по правилу rule с часами clock
+func newTestAlertEngine(t *testing.T, clock *testClock, rule config.AlertRule) *AlertEngine {
+	t.Helper()
+	cfg := &config.AlertingConfig{Notify: []string{config.NotifyRecipient}, Rules: []config.AlertRule{rule}}
+	cfg.Normalize()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewAlertEngine(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.now = clock.Now
+	return e
+}
+
+// alertReading - показание поста 1 адреса 1 со значением температуры
+func alertReading(at time.Time, value float64) models.SensorData {
+	return models.SensorData{
+		Meta: models.MetaData{Recipient: "user1", Address: 1, PostID: 1, Timestamp: at},
+		Data: map[string]models.Metric{"temperature": {Value: value, Unit: "C"}},
+	}
+}
+
+func TestAlertTransitions(t *testing.T) {
+	above, below := 30.0, 5.0
+	// Шаг: часы идут на advance, затем приходит показание value или, при
+	// tick, проверяются ожидающие тревоги и устаревшие посты
+	type step struct {
+		advance time.Duration
+		value   float64
+		tick    bool
+		state   string // состояние тревоги после шага; пусто - тревоги нет
+		event   string // состояние в уведомлении; пусто - уведомления нет
+	}
+	tests := []struct {
+		name  string
+		rule  config.AlertRule
+		steps []step
+	}{
+		{
+			name: "pending, firing and resolved with hysteresis",
+			rule: config.AlertRule{Above: &above, Hysteresis: 2, For: config.NewDuration(time.Minute)},
+			steps: []step{
+				{value: 25},
+				{advance: 10 * time.Second, value: 31, state: models.AlertPending},
+				// Ниже границы, но в пределах гистерезиса: условие выполняется
+				{advance: 30 * time.Second, value: 29, state: models.AlertPending},
+				{advance: 30 * time.Second, value: 30.5, state: models.AlertFiring, event: models.AlertFiring},
+				{advance: 10 * time.Second, value: 28.5, state: models.AlertFiring},
+				{advance: 10 * time.Second, value: 27.9, state: models.AlertResolved, event: models.AlertResolved},
+				// Снятое условие снова выполняется только за самой границей
+				{advance: 10 * time.Second, value: 29, state: models.AlertResolved},
+				{advance: 10 * time.Second, value: 31, state: models.AlertPending},
+			},
+		},
+		{
+			name: "pending cleared before for",
+			rule: config.AlertRule{Above: &above, Hysteresis: 2, For: config.NewDuration(time.Minute)},
+			steps: []step{
+				{value: 31, state: models.AlertPending},
+				{advance: 30 * time.Second, value: 27, state: ""},
+				{advance: 40 * time.Second, value: 29},
+				{advance: 40 * time.Second, tick: true},
+			},
+		},
+		{
+			name: "below with hysteresis",
+			rule: config.AlertRule{Below: &below, Hysteresis: 1},
+			steps: []step{
+				{value: 4, state: models.AlertFiring, event: models.AlertFiring},
+				{advance: time.Second, value: 5.5, state: models.AlertFiring},
+				{advance: time.Second, value: 6.1, state: models.AlertResolved, event: models.AlertResolved},
+			},
+		},
+		{
+			name: "fires on tick without new readings",
+			rule: config.AlertRule{Above: &above, For: config.NewDuration(time.Minute)},
+			steps: []step{
+				{value: 31, state: models.AlertPending},
+				{advance: 59 * time.Second, tick: true, state: models.AlertPending},
+				{advance: time.Second, tick: true, state: models.AlertFiring, event: models.AlertFiring},
+			},
+		},
+		{
+			name: "stale post resolves",
+			rule: config.AlertRule{Above: &above, Stale: config.NewDuration(5 * time.Minute)},
+			steps: []step{
+				{value: 31, state: models.AlertFiring, event: models.AlertFiring},
+				{advance: 5 * time.Minute, tick: true, state: models.AlertFiring},
+				{advance: time.Second, tick: true, state: models.AlertResolved, event: models.AlertResolved},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name, tt.rule.Metric = "hot", "temperature"
+			clock := newTestClock()
+			e := newTestAlertEngine(t, clock, tt.rule)
+
+			for i, s := range tt.steps {
+				clock.Advance(s.advance)
+				var events []AlertEvent
+				if s.tick {
+					events = e.Tick()
+				} else {
+					events = e.Evaluate(alertReading(clock.Now(), s.value))
+				}
+
+				switch {
+				case s.event == "" && len(events) != 0:
+					t.Fatalf("step %d: unexpected events %+v", i, events)
+				case s.event != "" && (len(events) != 1 || events[0].Alert.State != s.event):
+					t.Fatalf("step %d: events %+v, want one %s", i, events, s.event)
+				}
+
+				alerts := e.Alerts("")
+				state := ""
+				if len(alerts) > 0 {
+					state = alerts[0].State
+				}
+				if state != s.state {
+					t.Fatalf("step %d: state %q, want %q", i, state, s.state)
+				}
+			}
+		})
+	}
+}
+
+func TestAlertFiredAt(t *testing.T) {
+	above := 30.0
+	clock := newTestClock()
+	e := newTestAlertEngine(t, clock, config.AlertRule{
+		Name:   "hot",
+		Metric: "temperature",
+		Above:  &above,
+		For:    config.NewDuration(time.Minute),
+	})
+
+	start := clock.Now()
+	e.Evaluate(alertReading(start, 31))
+	clock.Advance(time.Minute)
+	events := e.Evaluate(alertReading(clock.Now(), 32))
+	if len(events) != 1 {
+		t.Fatalf("events %+v, want firing", events)
+	}
+	alert := events[0].Alert
+	// For отсчитывается от первого показания за границей
+	if !alert.Since.Equal(start) || alert.FiredAt == nil || !alert.FiredAt.Equal(clock.Now()) {
+		t.Errorf("since %v, fired at %v; want %v, %v", alert.Since, alert.FiredAt, start, clock.Now())
+	}
+	if alert.Value != 32 {
+		t.Errorf("value %g, want 32", alert.Value)
+	}
+	if stats := e.Stats(); stats.Firing != 1 || stats.Fired != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
@@ -0,0 +1,407 @@
+package collector
+
+import (
+	"big_go/internal/models"
+	"big_go/internal/repository"
+	"big_go/internal/services"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API - HTTP API состояния работающего коллектора: счетчики и метрики
+// Prometheus, история и сводки из PostgreSQL, текущее состояние из Redis,
+// автоматы отключения, адресаты memory:, карантин и тревоги
+type API struct {
+	collector *Collector
+	consumer  *services.Consumer
+	repo      *repository.PostgresRepository
+	history   *repository.Writer
+	cache     *services.RedisCache
+	tsdb      *services.OpentsdbWriter
+}
+
+// APIOptions - хранилища коллектора; nil - хранилище отключено, и запросы
+// к нему отвечают 503
+type APIOptions struct {
+	Repository *repository.PostgresRepository
+	History    *repository.Writer
+	Cache      *services.RedisCache
+	TSDB       *services.OpentsdbWriter
+}
+
+// NewAPI создает API состояния коллектора c, получающего сообщения через consumer
+func NewAPI(c *Collector, consumer *services.Consumer, opts APIOptions) *API {
+	return &API{
+		collector: c,
+		consumer:  consumer,
+		repo:      opts.Repository,
+		history:   opts.History,
+		cache:     opts.Cache,
+		tsdb:      opts.TSDB,
+	}
+}
+
+// Register регистрирует маршруты API
+func (a *API) Register(r gin.IRouter) {
+	r.GET("/status", a.status)
+	r.GET("/metrics", a.metrics)
+
+	// История показаний: GET /readings?recipient=User1&address=1&post=2&from=...&to=...&limit=100
+	// (from и to - RFC 3339), GET /addresses, GET /posts?address=1
+	r.GET("/readings", a.withHistory(a.readings))
+	r.GET("/addresses", a.withHistory(a.addresses))
+	r.GET("/posts", a.withHistory(a.posts))
+
+	// Сводки: GET /rollups?window=5m&recipient=User1&address=1&post=2&from=...&to=...&limit=100
+	// (post=0 - сводки по всему адресу, from и to - по началу окна, RFC 3339)
+	r.GET("/rollups", a.withHistory(a.rollups))
+
+	// Текущее состояние из Redis: GET /cache/latest?address=1&post=2 -
+	// последнее показание поста (только address - адреса, без параметров -
+	// всех постов), GET /cache/history/:recipient?limit=100 - последние
+	// показания адресата, GET /cache/stream/:recipient - новые показания
+	// адресата (server-sent events)
+	r.GET("/cache/latest", a.withCache(a.cacheLatest))
+	r.GET("/cache/history/:recipient", a.withCache(a.cacheHistory))
+	r.GET("/cache/stream/:recipient", a.withCache(a.cacheStream))
+
+	// Автоматы отключения адресов получателей
+	r.GET("/breakers", a.breakers)
+	r.POST("/breakers/reset", a.resetBreaker)
+
+	// Показания, принятые адресатом memory:<имя>
+	r.GET("/sinks/memory/:name", a.memorySink)
+
+	// Карантин: GET /quarantine?address=1&post=2&reason=range&limit=100,
+	// POST /quarantine/:id/release - переслать показание без проверки
+	// (сообщения, не прошедшие проверку схемы, не выпускаются),
+	// POST /quarantine/:id/discard - отбросить
+	r.GET("/quarantine", a.withQuarantine(a.quarantine))
+	r.POST("/quarantine/:id/release", a.withQuarantine(a.quarantineAction(a.collector.Release, "released")))
+	r.POST("/quarantine/:id/discard", a.withQuarantine(a.quarantineAction(a.collector.Discard, "discarded")))
+
+	// Тревоги: GET /alerts?state=firing (pending, firing, resolved; без
+	// параметра - все)
+	r.GET("/alerts", a.alerts)
+}
+
+// status возвращает счетчики потребителя, коллектора и хранилищ
+func (a *API) status(c *gin.Context) {
+	status := gin.H{
+		"amqp":      a.consumer.Stats(),
+		"collector": a.collector.Stats(),
+	}
+	if a.history != nil {
+		status["history"] = a.history.Stats()
+	}
+	if a.cache != nil {
+		status["cache"] = a.cache.Stats()
+	}
+	if a.tsdb != nil {
+		status["opentsdb"] = a.tsdb.Stats()
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// withHistory отвечает 503, если история показаний отключена
+func (a *API) withHistory(handle gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.repo == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "история показаний отключена"})
+			return
+		}
+		handle(c)
+	}
+}
+
+func (a *API) readings(c *gin.Context) {
+	filter, err := readingFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	readings, err := a.repo.Query(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, readings)
+}
+
+func (a *API) addresses(c *gin.Context) {
+	addresses, err := a.repo.Addresses(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, addresses)
+}
+
+func (a *API) posts(c *gin.Context) {
+	address, err := queryInt(c, "address")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	posts, err := a.repo.Posts(c.Request.Context(), address)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, posts)
+}
+
+func (a *API) rollups(c *gin.Context) {
+	filter, err := rollupFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rollups, err := a.repo.QueryRollups(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rollups)
+}
+
+// withCache отвечает 503, если кэш в Redis отключен
+func (a *API) withCache(handle gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.cache == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "кэш в Redis отключен"})
+			return
+		}
+		handle(c)
+	}
+}
+
+func (a *API) cacheLatest(c *gin.Context) {
+	address, err := queryInt(c, "address")
+	var post int
+	if err == nil {
+		post, err = queryInt(c, "post")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	var result interface{}
+	switch {
+	case address != 0 && post != 0:
+		result, err = a.cache.LatestByPost(ctx, address, post)
+	case address != 0:
+		result, err = a.cache.LatestByAddress(ctx, address)
+	case post != 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "post задается вместе с address"})
+		return
+	default:
+		result, err = a.cache.LatestPosts(ctx)
+	}
+	switch {
+	case errors.Is(err, services.ErrNotCached):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func (a *API) cacheHistory(c *gin.Context) {
+	limit, err := queryInt(c, "limit")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	readings, err := a.cache.History(c.Request.Context(), c.Param("recipient"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, readings)
+}
+
+func (a *API) cacheStream(c *gin.Context) {
+	readings, err := a.cache.Subscribe(c.Request.Context(), c.Param("recipient"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Stream(func(w io.Writer) bool {
+		data, ok := <-readings
+		if ok {
+			c.SSEvent("reading", data)
+		}
+		return ok
+	})
+}
+
+func (a *API) breakers(c *gin.Context) {
+	c.JSON(http.StatusOK, a.collector.Router().Breakers())
+}
+
+func (a *API) resetBreaker(c *gin.Context) {
+	var req struct {
+		Endpoint string `json:"endpoint" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !a.collector.Router().ResetBreaker(req.Endpoint) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "адреса нет в таблице маршрутов"})
+		return
+	}
+	log.Printf("Автомат отключения адреса %s замкнут вручную", req.Endpoint)
+	c.JSON(http.StatusOK, gin.H{"status": "reset", "endpoint": req.Endpoint})
+}
+
+func (a *API) memorySink(c *gin.Context) {
+	sink, ok := a.collector.Router().MemorySink(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "адресата нет в таблице маршрутов"})
+		return
+	}
+	messages, total := sink.Messages()
+	c.JSON(http.StatusOK, gin.H{"total": total, "messages": messages})
+}
+
+// withQuarantine отвечает 503, если карантин отключен
+func (a *API) withQuarantine(handle gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.collector.Quarantine() == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "карантин отключен"})
+			return
+		}
+		handle(c)
+	}
+}
+
+func (a *API) quarantine(c *gin.Context) {
+	filter := QuarantineFilter{Reason: c.Query("reason")}
+	var err error
+	if filter.Address, err = queryInt(c, "address"); err == nil {
+		if filter.PostID, err = queryInt(c, "post"); err == nil {
+			filter.Limit, err = queryInt(c, "limit")
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"stats":   a.collector.Quarantine().Stats(),
+		"entries": a.collector.Quarantine().List(filter),
+	})
+}
+
+// quarantineAction выполняет action над показанием карантина и отвечает status
+func (a *API) quarantineAction(action func(id string) error, status string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		err := action(id)
+		switch {
+		case err == nil:
+			c.JSON(http.StatusOK, gin.H{"status": status, "id": id})
+		case errors.Is(err, ErrNotQuarantined):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrUnparsed):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+	}
+}
+
+func (a *API) alerts(c *gin.Context) {
+	if a.collector.Alerts() == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "тревоги отключены"})
+		return
+	}
+	state := c.Query("state")
+	switch state {
+	case "", models.AlertPending, models.AlertFiring, models.AlertResolved:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("state: ожидается %s, %s или %s",
+			models.AlertPending, models.AlertFiring, models.AlertResolved)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"stats":  a.collector.Alerts().Stats(),
+		"alerts": a.collector.Alerts().Alerts(state),
+	})
+}
+
+// readingFilter разбирает условия выборки истории из параметров запроса
+func readingFilter(c *gin.Context) (repository.ReadingFilter, error) {
+	filter := repository.ReadingFilter{Recipient: c.Query("recipient")}
+	var err error
+	if filter.Address, err = queryInt(c, "address"); err != nil {
+		return filter, err
+	}
+	if filter.PostID, err = queryInt(c, "post"); err != nil {
+		return filter, err
+	}
+	if filter.Limit, err = queryInt(c, "limit"); err != nil {
+		return filter, err
+	}
+	err = queryTimes(c, map[string]*time.Time{"from": &filter.From, "to": &filter.To})
+	return filter, err
+}
+
+// rollupFilter разбирает условия выборки сводок из параметров запроса
+func rollupFilter(c *gin.Context) (repository.RollupFilter, error) {
+	filter := repository.RollupFilter{Window: c.Query("window"), Recipient: c.Query("recipient")}
+	var err error
+	if filter.Address, err = queryInt(c, "address"); err != nil {
+		return filter, err
+	}
+	if v := c.Query("post"); v != "" {
+		post, err := queryInt(c, "post")
+		if err != nil {
+			return filter, err
+		}
+		filter.PostID = &post
+	}
+	if filter.Limit, err = queryInt(c, "limit"); err != nil {
+		return filter, err
+	}
+	err = queryTimes(c, map[string]*time.Time{"from": &filter.From, "to": &filter.To})
+	return filter, err
+}
+
+// queryInt возвращает целый параметр запроса; отсутствующий - 0
+func queryInt(c *gin.Context, name string) (int, error) {
+	v := c.Query(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: ожидается целое число", name)
+	}
+	return n, nil
+}
+
+// queryTimes разбирает параметры запроса со временем RFC 3339;
+// отсутствующие не меняются
+func queryTimes(c *gin.Context, times map[string]*time.Time) error {
+	for name, t := range times {
+		if v := c.Query(name); v != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				return fmt.Errorf("%s: ожидается время RFC 3339: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
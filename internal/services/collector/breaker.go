@@ -0,0 +1,163 @@
+package collector
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Состояния автомата отключения
+const (
+	BreakerClosed   = "closed"    // запросы проходят
+	BreakerOpen     = "open"      // запросы сразу отклоняются
+	BreakerHalfOpen = "half_open" // проходят пробные запросы
+)
+
+// ErrCircuitOpen возвращается вместо запроса к адресу, автомат которого разомкнут
+var ErrCircuitOpen = errors.New("автомат отключения разомкнут")
+
+// BreakerSettings - параметры автомата отключения
+type BreakerSettings struct {
+	Failures int           // подряд неудачных запросов до размыкания
+	Cooldown time.Duration // сколько автомат остается разомкнутым
+	Probes   int           // удачных пробных запросов до замыкания
+}
+
+// BreakerStats - состояние автомата адреса
+type BreakerStats struct {
+	Endpoint  string     `json:"endpoint"`
+	State     string     `json:"state"`
+	Since     time.Time  `json:"since"`    // время перехода в текущее состояние
+	Failures  int        `json:"failures"` // неудачи подряд
+	Opens     uint64     `json:"opens"`    // сколько раз автомат размыкался
+	Rejected  uint64     `json:"rejected"` // запросы, отклоненные без обращения к адресу
+	LastError string     `json:"last_error,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"` // когда разомкнутый автомат пропустит пробный запрос
+}
+
+// Breaker - автомат отключения адреса получателя. После Failures
+// неудачных запросов подряд он размыкается, и запросы к адресу сразу
+// завершаются ошибкой ErrCircuitOpen, не дожидаясь соединения. Через
+// Cooldown автомат переходит в полуразомкнутое состояние и пропускает
+// пробные запросы по одному: Probes удачных замыкают его, любая неудача
+// снова размыкает.
+type Breaker struct {
+	endpoint string
+	now      func() time.Time
+
+	mu        sync.Mutex
+	settings  BreakerSettings
+	state     string
+	since     time.Time
+	failures  int
+	successes int
+	probing   bool // пробный запрос выполняется
+	opens     uint64
+	rejected  uint64
+	lastError string
+}
+
+// NewBreaker создает замкнутый автомат адреса endpoint
+func NewBreaker(endpoint string, settings BreakerSettings) *Breaker {
+	b := &Breaker{endpoint: endpoint, now: time.Now, state: BreakerClosed}
+	b.since = b.now()
+	b.Configure(settings)
+	return b
+}
+
+// Configure меняет параметры, сохраняя состояние
+func (b *Breaker) Configure(settings BreakerSettings) {
+	if settings.Failures <= 0 {
+		settings.Failures = 1
+	}
+	if settings.Probes <= 0 {
+		settings.Probes = 1
+	}
+	b.mu.Lock()
+	b.settings = settings
+	b.mu.Unlock()
+}
+
+// Allow разрешает запрос или возвращает ErrCircuitOpen. После
+// разрешенного запроса нужно вызвать Done с его результатом.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.since) >= b.settings.Cooldown {
+		b.setState(BreakerHalfOpen)
+	}
+	switch b.state {
+	case BreakerOpen:
+		b.rejected++
+		return ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			b.rejected++
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Done учитывает результат запроса, разрешенного Allow
+func (b *Breaker) Done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	probe := b.state == BreakerHalfOpen && b.probing
+	b.probing = false
+	if err != nil {
+		b.failures++
+		b.lastError = err.Error()
+		if probe || (b.state == BreakerClosed && b.failures >= b.settings.Failures) {
+			b.opens++
+			b.setState(BreakerOpen)
+		}
+		return
+	}
+
+	b.failures = 0
+	if probe {
+		b.successes++
+		if b.successes >= b.settings.Probes {
+			b.setState(BreakerClosed)
+		}
+	}
+}
+
+// Reset замыкает автомат
+func (b *Breaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.probing = 0, false
+	b.setState(BreakerClosed)
+}
+
+// setState переключает состояние; вызывается под b.mu
+func (b *Breaker) setState(state string) {
+	if b.state != state {
+		b.state, b.since, b.successes = state, b.now(), 0
+	}
+}
+
+// Stats возвращает состояние автомата
+func (b *Breaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := BreakerStats{
+		Endpoint:  b.endpoint,
+		State:     b.state,
+		Since:     b.since,
+		Failures:  b.failures,
+		Opens:     b.opens,
+		Rejected:  b.rejected,
+		LastError: b.lastError,
+	}
+	if b.state == BreakerOpen {
+		retryAt := b.since.Add(b.settings.Cooldown)
+		stats.RetryAt = &retryAt
+	}
+	return stats
+}
@@ -0,0 +1,148 @@
+package collector
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	const cooldown = time.Minute
+	// Шаги: allow - запрос разрешен, reject - отклонен с ErrCircuitOpen,
+	// ok и fail - результат разрешенного запроса, wait - прошел cooldown,
+	// early - до конца cooldown осталась секунда
+	type step struct {
+		op    string
+		state string // состояние после шага
+	}
+	tests := []struct {
+		name     string
+		settings BreakerSettings
+		steps    []step
+		opens    uint64
+		rejected uint64
+	}{
+		{
+			name:     "opens after consecutive failures",
+			settings: BreakerSettings{Failures: 2, Cooldown: cooldown, Probes: 2},
+			steps: []step{
+				{"allow", BreakerClosed}, {"fail", BreakerClosed},
+				{"allow", BreakerClosed}, {"fail", BreakerOpen},
+				{"reject", BreakerOpen},
+			},
+			opens:    1,
+			rejected: 1,
+		},
+		{
+			name:     "success resets failures",
+			settings: BreakerSettings{Failures: 2, Cooldown: cooldown, Probes: 2},
+			steps: []step{
+				{"allow", BreakerClosed}, {"fail", BreakerClosed},
+				{"allow", BreakerClosed}, {"ok", BreakerClosed},
+				{"allow", BreakerClosed}, {"fail", BreakerClosed},
+			},
+		},
+		{
+			name:     "stays open until cooldown",
+			settings: BreakerSettings{Failures: 1, Cooldown: cooldown, Probes: 1},
+			steps: []step{
+				{"allow", BreakerClosed}, {"fail", BreakerOpen},
+				{"early", BreakerOpen}, {"reject", BreakerOpen},
+				{"wait", BreakerOpen}, {"allow", BreakerHalfOpen},
+			},
+			opens:    1,
+			rejected: 1,
+		},
+		{
+			name:     "one probe at a time until probes succeed",
+			settings: BreakerSettings{Failures: 1, Cooldown: cooldown, Probes: 2},
+			steps: []step{
+				{"allow", BreakerClosed}, {"fail", BreakerOpen},
+				{"wait", BreakerOpen},
+				{"allow", BreakerHalfOpen}, {"reject", BreakerHalfOpen}, {"ok", BreakerHalfOpen},
+				{"allow", BreakerHalfOpen}, {"reject", BreakerHalfOpen}, {"ok", BreakerClosed},
+				{"allow", BreakerClosed}, {"allow", BreakerClosed},
+			},
+			opens:    1,
+			rejected: 2,
+		},
+		{
+			name:     "failed probe reopens and restarts cooldown",
+			settings: BreakerSettings{Failures: 3, Cooldown: cooldown, Probes: 2},
+			steps: []step{
+				{"allow", BreakerClosed}, {"fail", BreakerClosed},
+				{"allow", BreakerClosed}, {"fail", BreakerClosed},
+				{"allow", BreakerClosed}, {"fail", BreakerOpen},
+				{"wait", BreakerOpen},
+				{"allow", BreakerHalfOpen}, {"ok", BreakerHalfOpen},
+				{"allow", BreakerHalfOpen}, {"fail", BreakerOpen},
+				{"early", BreakerOpen}, {"reject", BreakerOpen},
+				{"wait", BreakerOpen},
+				// Удачная проба до размыкания не засчитывается
+				{"allow", BreakerHalfOpen}, {"ok", BreakerHalfOpen},
+				{"allow", BreakerHalfOpen}, {"ok", BreakerClosed},
+			},
+			opens:    2,
+			rejected: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newTestClock()
+			b := NewBreaker("http://user1:8081/data", tt.settings)
+			b.now = clock.Now
+
+			for i, s := range tt.steps {
+				switch s.op {
+				case "allow", "reject":
+					err := b.Allow()
+					if s.op == "allow" && err != nil {
+						t.Fatalf("step %d: Allow: %v", i, err)
+					}
+					if s.op == "reject" && !errors.Is(err, ErrCircuitOpen) {
+						t.Fatalf("step %d: Allow = %v, want ErrCircuitOpen", i, err)
+					}
+				case "ok":
+					b.Done(nil)
+				case "fail":
+					b.Done(errors.New("connection refused"))
+				case "wait":
+					clock.Advance(cooldown)
+				case "early":
+					clock.Advance(cooldown - time.Second)
+				}
+				if state := b.Stats().State; state != s.state {
+					t.Fatalf("step %d (%s): state %s, want %s", i, s.op, state, s.state)
+				}
+			}
+
+			stats := b.Stats()
+			if stats.Opens != tt.opens || stats.Rejected != tt.rejected {
+				t.Errorf("opens %d, rejected %d; want %d, %d", stats.Opens, stats.Rejected, tt.opens, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestBreakerRetryAt(t *testing.T) {
+	clock := newTestClock()
+	b := NewBreaker("http://user1:8081/data", BreakerSettings{Failures: 1, Cooldown: time.Minute})
+	b.now = clock.Now
+
+	if err := b.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	b.Done(errors.New("connection refused"))
+	stats := b.Stats()
+	if stats.RetryAt == nil || !stats.RetryAt.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("retry at %v, want %v", stats.RetryAt, clock.Now().Add(time.Minute))
+	}
+	if stats.LastError != "connection refused" {
+		t.Errorf("last error %q", stats.LastError)
+	}
+
+	b.Reset()
+	if stats := b.Stats(); stats.State != BreakerClosed || stats.RetryAt != nil || stats.Failures != 0 {
+		t.Errorf("after Reset: %+v", stats)
+	}
+}
@@ -1,76 +1,353 @@
 package collector
 
 import (
+	"big_go/config"
+	"big_go/internal/codec"
 	"big_go/internal/models"
-	"bytes"
+	"big_go/internal/services"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
+	"strings"
 )
 
-// Collector представляет сервис коллектора данных
+// ErrDuplicate возвращается ProcessData для показания, уже принятого в окне дедупликации
+var ErrDuplicate = errors.New("повтор показания")
+
+// ErrQuarantined возвращается ProcessData для показания, не прошедшего
+// проверку и помещенного в карантин
+var ErrQuarantined = errors.New("показание помещено в карантин")
+
+// Store сохраняет принятые показания, например в историю
+// (repository.Writer). Save не должен ждать внешних систем.
+type Store interface {
+	Save(data models.SensorData) error
+}
+
+// RollupStore сохраняет сводки (например, repository.Writer). SaveRollup
+// не должен ждать внешних систем.
+type RollupStore interface {
+	SaveRollup(rollup models.Rollup) error
+}
+
+// Options - необязательные параметры коллектора
+type Options struct {
+	// ContentType возвращает формат тела для маршрута; nil - JSON
+	ContentType func(route string) string
+	// Dedup отбрасывает повторы по идентификатору показания; nil - без дедупликации
+	Dedup *services.Deduplicator
+	// Stores получают каждое принятое показание, в том числе без маршрутов
+	Stores []Store
+	// Validator проверяет показания до дедупликации; nil - без проверки
+	Validator *Validator
+	// Quarantine хранит показания, не прошедшие проверку; nil - они отбрасываются
+	Quarantine *Quarantine
+	// Aggregator строит сводки пересланных показаний; nil - без сводок.
+	// Сводка отправляется по маршруту получателя (codec.ContentTypeRollup)
+	// и передается хранилищам RollupStores.
+	Aggregator   *Aggregator
+	RollupStores []RollupStore
+	// Alerts проверяет правила тревог на пересланных показаниях; nil - без
+	// тревог. Уведомления ставятся в очередь маршрутов адресатов
+	// (codec.ContentTypeAlert).
+	Alerts *AlertEngine
+}
+
+// Stats - состояние коллектора
+type Stats struct {
+	Dedup      *services.DedupStats   `json:"dedup,omitempty"`
+	Quarantine *QuarantineStats       `json:"quarantine,omitempty"`
+	Rollups    *AggregatorStats       `json:"rollups,omitempty"`
+	Alerts     *AlertStats            `json:"alerts,omitempty"`
+	Outbox     map[string]OutboxStats `json:"outbox"`
+	Breakers   []BreakerStats         `json:"breakers"`
+	Recipients []string               `json:"recipients"`
+	Rules      []string               `json:"rules"`
+}
+
+// Collector представляет сервис коллектора данных: выбирает маршруты
+// показания по таблице, кодирует его в формат каждого маршрута и ставит в
+// outbox, который доставляет показания адресатам маршрутов (Sink).
+// Источник показаний (например, очередь RabbitMQ) передает их в ProcessData.
 type Collector struct {
-	user1Data  chan models.SensorData
-	user2Data  chan models.SensorData
-	httpClient *http.Client
+	router *Router
+	outbox *Outbox
+	opts   Options
 }
 
-// NewCollector создает новый экземпляр коллектора
-func NewCollector() *Collector {
-	c := &Collector{
-		user1Data:  make(chan models.SensorData, 100),
-		user2Data:  make(chan models.SensorData, 100),
-		httpClient: &http.Client{},
-	}
+// NewCollector создает новый экземпляр коллектора, пересылающий показания
+// по таблице маршрутов router через outbox
+func NewCollector(router *Router, outbox *Outbox, opts Options) *Collector {
+	return &Collector{router: router, outbox: outbox, opts: opts}
+}
+
+// Router возвращает таблицу маршрутов коллектора
+func (c *Collector) Router() *Router {
+	return c.router
+}
+
+// Quarantine возвращает карантин коллектора; nil - проверка отключена
+func (c *Collector) Quarantine() *Quarantine {
+	return c.opts.Quarantine
+}
 
-	// Запуск горутин для отправки данных пользователям
-	go c.sendDataToUser("user1", c.user1Data)
-	go c.sendDataToUser("user2", c.user2Data)
+// Alerts возвращает проверку тревог (nil, если тревоги отключены)
+func (c *Collector) Alerts() *AlertEngine {
+	return c.opts.Alerts
+}
 
-	return c
+// Run доставляет показания, выпускает сводки и тревоги до отмены ctx
+func (c *Collector) Run(ctx context.Context) {
+	if c.opts.Aggregator != nil {
+		go c.opts.Aggregator.Run(ctx, c.publishRollup)
+	}
+	if c.opts.Alerts != nil {
+		go c.opts.Alerts.Run(ctx, c.notify)
+	}
+	c.outbox.Run(ctx)
 }
 
-// ProcessData обрабатывает полученные данные и направляет их соответствующему пользователю
+// ProcessData обрабатывает полученные данные: проверяет их, передает
+// хранилищам Stores и ставит в очередь всех маршрутов, выбранных таблицей.
+// Возвращает ErrQuarantined или ErrInvalid (без карантина) для показания,
+// не прошедшего проверку, ErrDuplicate для повтора, ошибку политики
+// (ErrRejected или ErrDeadLetter), если маршрутов нет, или ошибку записи в
+// outbox либо в карантин; в последнем случае показание нужно передать снова.
+// ProcessData не ждет доставки: недоступный получатель не задерживает
+// остальных.
 func (c *Collector) ProcessData(data models.SensorData) error {
 	log.Printf("Обработка данных для %s от поста %d", data.Meta.Recipient, data.Meta.PostID)
 
-	// Направление данных соответствующему пользователю
-	switch data.Meta.Recipient {
-	case "User1":
-		c.user1Data <- data
-	case "User2":
-		c.user2Data <- data
-	default:
-		return fmt.Errorf("неизвестный получатель: %s", data.Meta.Recipient)
+	if c.opts.Validator != nil {
+		if err := c.opts.Validator.Check(data); err != nil {
+			return c.quarantine(data, err.(*ValidationError))
+		}
+	}
+	return c.accept(data)
+}
+
+// quarantine помещает показание, не прошедшее проверку, в карантин
+func (c *Collector) quarantine(data models.SensorData, verr *ValidationError) error {
+	if c.opts.Quarantine == nil {
+		return verr
+	}
+	if err := c.opts.Quarantine.Add(data, verr); err != nil {
+		return fmt.Errorf("ошибка помещения показания %s в карантин: %v", data.ID, err)
+	}
+	return fmt.Errorf("%w: %s: %v", ErrQuarantined, data.ID, verr)
+}
+
+// QuarantineMessage помещает в карантин сообщение, не прошедшее проверку
+// схемы (см. Quarantine.AddMessage). Возвращает ErrQuarantined или, без
+// карантина, derr; при ошибке записи сообщение нужно передать снова.
+func (c *Collector) QuarantineMessage(id, contentType string, body []byte, derr *models.DecodeError) error {
+	if c.opts.Quarantine == nil {
+		return derr
+	}
+	if err := c.opts.Quarantine.AddMessage(id, contentType, body, derr); err != nil {
+		return fmt.Errorf("ошибка помещения сообщения в карантин: %v", err)
+	}
+	return fmt.Errorf("%w: %v", ErrQuarantined, derr)
+}
+
+// Release выпускает показание из карантина: оно обрабатывается без
+// проверки, как если бы пришло из очереди, и удаляется из карантина.
+// Если показание не удалось поставить в очередь маршрутов, оно остается в
+// карантине. Сообщение, не прошедшее проверку схемы, не выпускается
+// (ErrUnparsed).
+func (c *Collector) Release(id string) error {
+	if c.opts.Quarantine == nil {
+		return fmt.Errorf("%w: %s", ErrNotQuarantined, id)
+	}
+	entry, ok := c.opts.Quarantine.Get(id)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotQuarantined, id)
+	}
+	if entry.Body != nil {
+		return fmt.Errorf("%w: %s", ErrUnparsed, id)
 	}
+	log.Printf("Показание %s выпущено из карантина (%s)", id, entry.Reason)
+	err := c.accept(entry.Data)
+	if err != nil && !errors.Is(err, ErrDuplicate) {
+		return err
+	}
+	return c.opts.Quarantine.remove(id, opRelease)
+}
 
+// Discard отбрасывает показание из карантина
+func (c *Collector) Discard(id string) error {
+	if c.opts.Quarantine == nil {
+		return fmt.Errorf("%w: %s", ErrNotQuarantined, id)
+	}
+	if err := c.opts.Quarantine.Discard(id); err != nil {
+		return err
+	}
+	log.Printf("Показание %s отброшено из карантина", id)
 	return nil
 }
 
-// sendDataToUser отправляет данные соответствующему пользовательскому сервису
-func (c *Collector) sendDataToUser(userService string, dataChan <-chan models.SensorData) {
-	var endpoint string
-	if userService == "user1" {
-		endpoint = "http://user1:8082/data"
-	} else {
-		endpoint = "http://user2:8083/data"
+// accept пересылает проверенное показание
+func (c *Collector) accept(data models.SensorData) error {
+	if c.opts.Dedup != nil && c.opts.Dedup.Seen(data.ID) {
+		return fmt.Errorf("%w: %s", ErrDuplicate, data.ID)
+	}
+
+	// Хранилища сами переносят повторы, поэтому ошибка сохранения не
+	// мешает доставке
+	for _, store := range c.opts.Stores {
+		if err := store.Save(data); err != nil {
+			log.Printf("Показание %s не сохранено: %v", data.ID, err)
+		}
 	}
 
-	for data := range dataChan {
-		jsonData, err := json.Marshal(data)
+	routes, err := c.router.Resolve(data)
+	if err != nil {
+		// Показание подтверждается только после того, как отправитель
+		// применит политику (например, поместит его в очередь
+		// недоставленных); если это не удастся, копия должна быть принята
+		c.forget(data.ID)
+		return err
+	}
+
+	// Ставим данные в очередь каждого маршрута в предпочитаемом им формате
+	var failed []string
+	for _, route := range routes {
+		err := c.enqueue(route, data)
 		if err != nil {
-			log.Printf("Ошибка сериализации данных для %s: %v", userService, err)
+			log.Printf("Ошибка постановки данных в очередь маршрута %s: %v", route.Name, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", route.Name, err))
 			continue
 		}
+		log.Printf("Данные поставлены в очередь маршрута %s", route.Name)
+	}
+	if len(failed) == 0 {
+		c.aggregate(data)
+		c.evaluate(data)
+		return nil
+	}
 
-		resp, err := http.Post(endpoint, "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			log.Printf("Ошибка отправки данных для %s: %v", userService, err)
+	// Повторная копия показания должна быть принята; маршруты, в очередь
+	// которых оно уже попало, получат его дважды и отбросят повтор по
+	// идентификатору
+	c.forget(data.ID)
+	return errors.New(strings.Join(failed, "; "))
+}
+
+// forget удаляет идентификатор из окна дедупликации
+func (c *Collector) forget(id string) {
+	if c.opts.Dedup != nil {
+		c.opts.Dedup.Forget(id)
+	}
+}
+
+func (c *Collector) enqueue(route *Route, data models.SensorData) error {
+	contentType := codec.ContentTypeJSON
+	if c.opts.ContentType != nil {
+		contentType = c.opts.ContentType(route.Name)
+	}
+	bodyCodec, err := codec.ForContentType(contentType)
+	if err != nil {
+		return err
+	}
+	body, err := bodyCodec.Encode(data)
+	if err != nil {
+		return err
+	}
+	return c.outbox.Enqueue(route.Name, data.ID, bodyCodec.ContentType(), body)
+}
+
+// aggregate учитывает показание в сводках; окна, закрытые раньше срока,
+// выпускаются сразу
+func (c *Collector) aggregate(data models.SensorData) {
+	if c.opts.Aggregator == nil {
+		return
+	}
+	for _, rollup := range c.opts.Aggregator.Add(data) {
+		c.publishRollup(rollup)
+	}
+}
+
+// publishRollup передает сводку хранилищам и ставит ее в очередь маршрута
+// получателя, если он есть в таблице
+func (c *Collector) publishRollup(rollup models.Rollup) {
+	for _, store := range c.opts.RollupStores {
+		if err := store.SaveRollup(rollup); err != nil {
+			log.Printf("Сводка %s не сохранена: %v", rollup.ID(), err)
+		}
+	}
+	route, ok := c.router.Lookup(rollup.Recipient)
+	if !ok {
+		return
+	}
+	body, err := json.Marshal(rollup)
+	if err == nil {
+		err = c.outbox.Enqueue(route.Name, rollup.ID(), codec.ContentTypeRollup, body)
+	}
+	if err != nil {
+		log.Printf("Ошибка постановки сводки %s в очередь маршрута %s: %v", rollup.ID(), route.Name, err)
+	}
+}
+
+// evaluate проверяет правила тревог на показании и рассылает уведомления
+func (c *Collector) evaluate(data models.SensorData) {
+	if c.opts.Alerts == nil {
+		return
+	}
+	for _, event := range c.opts.Alerts.Evaluate(data) {
+		c.notify(event)
+	}
+}
+
+// notify ставит уведомление о тревоге в очередь маршрутов адресатов;
+// config.NotifyRecipient - маршрут получателя тревоги
+func (c *Collector) notify(event AlertEvent) {
+	alert := event.Alert
+	log.Printf("Тревога %s (%s): %s, %s", alert.Rule, alert.State, alert.ID(), alert.Message)
+	body, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("Ошибка кодирования тревоги %s: %v", alert.ID(), err)
+		return
+	}
+	for _, name := range event.Notify {
+		if name == config.NotifyRecipient {
+			name = alert.Recipient
+		}
+		route, ok := c.router.Lookup(name)
+		if !ok {
+			log.Printf("Уведомление о тревоге %s не отправлено: маршрута %s нет в таблице", alert.ID(), name)
 			continue
 		}
-		resp.Body.Close()
+		if err := c.outbox.Enqueue(route.Name, alert.ID()+"/"+alert.State, codec.ContentTypeAlert, body); err != nil {
+			log.Printf("Ошибка постановки уведомления о тревоге %s в очередь маршрута %s: %v", alert.ID(), route.Name, err)
+		}
+	}
+}
 
-		log.Printf("Данные успешно отправлены %s", userService)
+// Stats возвращает состояние окна дедупликации, очередей и адресатов
+func (c *Collector) Stats() Stats {
+	stats := Stats{
+		Outbox:     c.outbox.Stats(),
+		Breakers:   c.router.Breakers(),
+		Recipients: c.router.Recipients(),
+		Rules:      c.router.Rules(),
+	}
+	if c.opts.Dedup != nil {
+		dedup := c.opts.Dedup.Stats()
+		stats.Dedup = &dedup
+	}
+	if c.opts.Quarantine != nil {
+		quarantine := c.opts.Quarantine.Stats()
+		stats.Quarantine = &quarantine
+	}
+	if c.opts.Aggregator != nil {
+		rollups := c.opts.Aggregator.Stats()
+		stats.Rollups = &rollups
+	}
+	if c.opts.Alerts != nil {
+		alerts := c.opts.Alerts.Stats()
+		stats.Alerts = &alerts
 	}
+	return stats
 }
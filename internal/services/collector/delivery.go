@@ -0,0 +1,153 @@
+package collector
+
+import (
+	"big_go/internal/codec"
+	"big_go/internal/models"
+	"big_go/internal/services"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// DeliveryHandler передает сообщения очереди показаний коллектору.
+// Сообщение подтверждается (ack), когда коллектор поставил его в очередь
+// всех маршрутов или оно помещено в карантин либо в очередь
+// недоставленных; если коллектор его не принял, оно возвращается в
+// очередь (nack) до maxRetries раз, а затем уходит в очередь
+// недоставленных.
+type DeliveryHandler struct {
+	collector  *Collector
+	maxRetries int
+	attempts   map[string]int // неудачные попытки по идентификатору показания
+}
+
+// NewDeliveryHandler создает обработчик сообщений для services.Consumer
+func NewDeliveryHandler(c *Collector, maxRetries int) *DeliveryHandler {
+	return &DeliveryHandler{
+		collector:  c,
+		maxRetries: maxRetries,
+		attempts:   make(map[string]int),
+	}
+}
+
+// Handle обрабатывает одно сообщение. Вызывается из горутины потребителя.
+func (h *DeliveryHandler) Handle(s *services.Session, d amqp.Delivery) {
+	// Разбор в формате из content-type с преобразованием старых версий
+	// схемы и проверкой по ней
+	sensorData, err := codec.Decode(d.ContentType, d.Body)
+	if err != nil {
+		var decodeErr *models.DecodeError
+		if !errors.As(err, &decodeErr) {
+			decodeErr = &models.DecodeError{Reason: models.ReasonUnmarshal, Problems: []string{err.Error()}}
+		}
+		if decodeErr.Reason == models.ReasonMalformed {
+			// Тело не разбирается - в очередь недоставленных
+			log.Printf("Сообщение не разбирается: %v", err)
+			h.settle(d, h.deadLetter(s, d, services.DeadLetterMalformed, err, 1))
+			return
+		}
+		log.Printf("Сообщение не прошло проверку схемы: %v", err)
+		err = h.collector.QuarantineMessage(d.MessageId, d.ContentType, d.Body, decodeErr)
+		if errors.Is(err, ErrQuarantined) {
+			err = nil
+		}
+		h.settle(d, err)
+		return
+	}
+
+	log.Printf("Получено сообщение: %+v", sensorData)
+
+	// Идентификатор в теле переживает любой формат передачи, поэтому
+	// дедупликация ведется по нему
+	if d.MessageId != "" && d.MessageId != sensorData.ID {
+		log.Printf("message-id %s не совпадает с идентификатором показания %s", d.MessageId, sensorData.ID)
+	}
+
+	err = h.collector.ProcessData(sensorData)
+	switch {
+	case err == nil:
+		delete(h.attempts, sensorData.ID)
+		h.settle(d, nil)
+		return
+	case errors.Is(err, ErrQuarantined):
+		log.Printf("%v", err)
+		delete(h.attempts, sensorData.ID)
+		h.settle(d, nil)
+		return
+	case errors.Is(err, ErrInvalid):
+		log.Printf("Показание %s отброшено: %v", sensorData.ID, err)
+		delete(h.attempts, sensorData.ID)
+		h.settle(d, nil)
+		return
+	case errors.Is(err, ErrDuplicate):
+		log.Printf("Повтор показания %s отброшен (повторная доставка: %t)", sensorData.ID, d.Redelivered)
+		h.settle(d, nil)
+		return
+	case errors.Is(err, ErrDeadLetter):
+		log.Printf("%v", err)
+		h.settle(d, h.deadLetter(s, d, services.DeadLetterUnknownRecipient, err, 1))
+		return
+	case errors.Is(err, ErrRejected):
+		log.Printf("Показание %s отброшено: %v", sensorData.ID, err)
+		h.settle(d, nil)
+		return
+	}
+
+	h.attempts[sensorData.ID]++
+	attempts := h.attempts[sensorData.ID]
+	if attempts <= h.maxRetries {
+		log.Printf("Показание %s возвращено в очередь (попытка %d из %d)", sensorData.ID, attempts, h.maxRetries+1)
+		if err := d.Nack(false, true); err != nil {
+			log.Printf("Ошибка возврата сообщения в очередь: %v", err)
+		}
+		return
+	}
+	delete(h.attempts, sensorData.ID)
+	log.Printf("Показание %s не поставлено в очередь за %d попыток", sensorData.ID, attempts)
+	h.settle(d, h.deadLetter(s, d, services.DeadLetterRetriesExhausted, err, attempts))
+}
+
+// settle подтверждает сообщение, если err == nil (оно обработано или
+// перенесено в другую очередь), иначе возвращает его в очередь
+func (h *DeliveryHandler) settle(d amqp.Delivery, err error) {
+	if err != nil {
+		log.Printf("Сообщение возвращено в очередь: %v", err)
+		if err := d.Nack(false, true); err != nil {
+			log.Printf("Ошибка возврата сообщения в очередь: %v", err)
+		}
+		return
+	}
+	if err := d.Ack(false); err != nil {
+		log.Printf("Ошибка подтверждения сообщения: %v", err)
+	}
+}
+
+// deadLetter публикует сообщение в точку обмена недоставленных с причиной
+// reason, текстом ошибки и числом попыток
+func (h *DeliveryHandler) deadLetter(s *services.Session, d amqp.Delivery, reason string, cause error, attempts int) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[services.HeaderDeadLetterReason] = reason
+	headers[services.HeaderDeadLetterError] = cause.Error()
+	headers[services.HeaderDeliveryAttempts] = int32(attempts)
+	headers[services.HeaderOriginalQueue] = services.SensorDataQueue
+
+	// Исходное сообщение с новыми заголовками
+	err := s.Publish(services.DeadLetterExchange, "", amqp.Publishing{
+		ContentType:  d.ContentType,
+		MessageId:    d.MessageId,
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Timestamp:    time.Now(),
+		Body:         d.Body,
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка помещения сообщения в очередь недоставленных: %v", err)
+	}
+	return nil
+}
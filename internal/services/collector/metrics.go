@@ -0,0 +1,244 @@
+package collector
+
+import (
+	"big_go/internal/models"
+	"big_go/internal/repository"
+	"big_go/internal/services"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metrics отдает метрики в текстовом формате Prometheus
+func (a *API) metrics(c *gin.Context) {
+	var b strings.Builder
+	writeConsumerMetrics(&b, a.consumer.Stats())
+	stats := a.collector.Stats()
+	if stats.Dedup != nil {
+		fmt.Fprintf(&b, "# TYPE big_go_collector_duplicates_total counter\n")
+		fmt.Fprintf(&b, "big_go_collector_duplicates_total %d\n", stats.Dedup.Duplicates)
+	}
+	if a.history != nil {
+		writeHistoryMetrics(&b, a.history.Stats())
+	}
+	if a.cache != nil {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), time.Second)
+		up := a.cache.Ping(ctx) == nil
+		cancel()
+		writeCacheMetrics(&b, up, a.cache.Stats())
+	}
+	if a.tsdb != nil {
+		writeTSDBMetrics(&b, a.tsdb.Stats())
+	}
+	if stats.Rollups != nil {
+		writeRollupMetrics(&b, *stats.Rollups)
+	}
+	if stats.Alerts != nil {
+		writeAlertMetrics(&b, *stats.Alerts, a.collector.Alerts().Alerts(models.AlertFiring))
+	}
+	if stats.Quarantine != nil {
+		writeQuarantineMetrics(&b, *stats.Quarantine)
+	}
+	writeOutboxMetrics(&b, stats.Outbox)
+	writeBreakerMetrics(&b, stats.Breakers)
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+// writeConsumerMetrics добавляет метрики подключения к RabbitMQ
+func writeConsumerMetrics(b *strings.Builder, stats services.ConsumerStats) {
+	connected := 0
+	if stats.State == services.StateConnected {
+		connected = 1
+	}
+	fmt.Fprintf(b, "# HELP big_go_collector_amqp_connected Подключен ли потребитель к RabbitMQ\n")
+	fmt.Fprintf(b, "# TYPE big_go_collector_amqp_connected gauge\n")
+	fmt.Fprintf(b, "big_go_collector_amqp_connected %d\n", connected)
+	fmt.Fprintf(b, "# TYPE big_go_collector_amqp_connects_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_amqp_connects_total %d\n", stats.Connects)
+	fmt.Fprintf(b, "# TYPE big_go_collector_amqp_reconnects_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_amqp_reconnects_total %d\n", stats.Reconnects)
+	fmt.Fprintf(b, "# TYPE big_go_collector_amqp_failed_connects_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_amqp_failed_connects_total %d\n", stats.FailedConnects)
+	fmt.Fprintf(b, "# TYPE big_go_collector_deliveries_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_deliveries_total %d\n", stats.Deliveries)
+}
+
+// writeHistoryMetrics добавляет метрики записи истории в PostgreSQL
+func writeHistoryMetrics(b *strings.Builder, stats repository.WriterStats) {
+	fmt.Fprintf(b, "# TYPE big_go_collector_history_buffered gauge\n")
+	fmt.Fprintf(b, "big_go_collector_history_buffered %d\n", stats.Buffered)
+	fmt.Fprintf(b, "# TYPE big_go_collector_history_written_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_history_written_total %d\n", stats.Written)
+	fmt.Fprintf(b, "# TYPE big_go_collector_history_rollups_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_history_rollups_total %d\n", stats.Rollups)
+	fmt.Fprintf(b, "# TYPE big_go_collector_history_dropped_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_history_dropped_total %d\n", stats.Dropped)
+	fmt.Fprintf(b, "# TYPE big_go_collector_history_failures_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_history_failures_total %d\n", stats.Failures)
+}
+
+// writeCacheMetrics добавляет метрики кэша в Redis; up - ответил ли Redis
+func writeCacheMetrics(b *strings.Builder, up bool, stats services.RedisCacheStats) {
+	fmt.Fprintf(b, "# HELP big_go_collector_cache_up Отвечает ли Redis\n")
+	fmt.Fprintf(b, "# TYPE big_go_collector_cache_up gauge\n")
+	if up {
+		fmt.Fprintf(b, "big_go_collector_cache_up 1\n")
+	} else {
+		fmt.Fprintf(b, "big_go_collector_cache_up 0\n")
+	}
+	fmt.Fprintf(b, "# TYPE big_go_collector_cache_buffered gauge\n")
+	fmt.Fprintf(b, "big_go_collector_cache_buffered %d\n", stats.Buffered)
+	fmt.Fprintf(b, "# TYPE big_go_collector_cache_written_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_cache_written_total %d\n", stats.Written)
+	fmt.Fprintf(b, "# HELP big_go_collector_cache_duplicates_total Повторы уже записанных показаний\n")
+	fmt.Fprintf(b, "# TYPE big_go_collector_cache_duplicates_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_cache_duplicates_total %d\n", stats.Duplicates)
+	fmt.Fprintf(b, "# TYPE big_go_collector_cache_published_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_cache_published_total %d\n", stats.Published)
+	fmt.Fprintf(b, "# TYPE big_go_collector_cache_dropped_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_cache_dropped_total %d\n", stats.Dropped)
+	fmt.Fprintf(b, "# TYPE big_go_collector_cache_failures_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_cache_failures_total %d\n", stats.Failures)
+}
+
+// writeTSDBMetrics добавляет метрики записи в OpenTSDB
+func writeTSDBMetrics(b *strings.Builder, stats services.OpentsdbStats) {
+	fmt.Fprintf(b, "# TYPE big_go_collector_tsdb_buffered gauge\n")
+	fmt.Fprintf(b, "big_go_collector_tsdb_buffered %d\n", stats.Buffered)
+	fmt.Fprintf(b, "# TYPE big_go_collector_tsdb_written_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_tsdb_written_total %d\n", stats.Written)
+	fmt.Fprintf(b, "# TYPE big_go_collector_tsdb_rejected_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_tsdb_rejected_total %d\n", stats.Rejected)
+	fmt.Fprintf(b, "# TYPE big_go_collector_tsdb_dropped_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_tsdb_dropped_total %d\n", stats.Dropped)
+	fmt.Fprintf(b, "# TYPE big_go_collector_tsdb_failures_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_tsdb_failures_total %d\n", stats.Failures)
+}
+
+// writeRollupMetrics добавляет метрики сводок
+func writeRollupMetrics(b *strings.Builder, stats AggregatorStats) {
+	fmt.Fprintf(b, "# HELP big_go_collector_rollups_open Открытые окна сводок\n")
+	fmt.Fprintf(b, "# TYPE big_go_collector_rollups_open gauge\n")
+	fmt.Fprintf(b, "big_go_collector_rollups_open %d\n", stats.Open)
+	fmt.Fprintf(b, "# TYPE big_go_collector_rollups_emitted_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_rollups_emitted_total %d\n", stats.Emitted)
+	fmt.Fprintf(b, "# HELP big_go_collector_rollups_late_total Показания, пришедшие после закрытия своего окна\n")
+	fmt.Fprintf(b, "# TYPE big_go_collector_rollups_late_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_rollups_late_total %d\n", stats.Late)
+	fmt.Fprintf(b, "# TYPE big_go_collector_rollups_evicted_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_rollups_evicted_total %d\n", stats.Evicted)
+}
+
+// writeQuarantineMetrics добавляет метрики карантина и отклоненных
+// показаний с метками address, post и reason
+func writeQuarantineMetrics(b *strings.Builder, stats QuarantineStats) {
+	fmt.Fprintf(b, "# HELP big_go_collector_quarantine_pending Показания в карантине, ожидающие решения\n")
+	fmt.Fprintf(b, "# TYPE big_go_collector_quarantine_pending gauge\n")
+	fmt.Fprintf(b, "big_go_collector_quarantine_pending %d\n", stats.Pending)
+	fmt.Fprintf(b, "# TYPE big_go_collector_quarantine_released_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_quarantine_released_total %d\n", stats.Released)
+	fmt.Fprintf(b, "# TYPE big_go_collector_quarantine_discarded_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_quarantine_discarded_total %d\n", stats.Discarded)
+	fmt.Fprintf(b, "# TYPE big_go_collector_quarantine_evicted_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_quarantine_evicted_total %d\n", stats.Evicted)
+	fmt.Fprintf(b, "# HELP big_go_collector_quarantined_total Показания, не прошедшие проверку\n")
+	fmt.Fprintf(b, "# TYPE big_go_collector_quarantined_total counter\n")
+	for _, post := range stats.Posts {
+		reasons := make([]string, 0, len(post.Reasons))
+		for reason := range post.Reasons {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(b, "big_go_collector_quarantined_total{address=\"%d\",post=\"%d\",reason=%q} %d\n",
+				post.Address, post.PostID, reason, post.Reasons[reason])
+		}
+	}
+}
+
+// writeAlertMetrics добавляет метрики тревог и число сработавших тревог по
+// правилам с метками rule и severity
+func writeAlertMetrics(b *strings.Builder, stats AlertStats, firing []models.Alert) {
+	fmt.Fprintf(b, "# HELP big_go_collector_alerts_pending Тревоги, ожидающие срабатывания\n")
+	fmt.Fprintf(b, "# TYPE big_go_collector_alerts_pending gauge\n")
+	fmt.Fprintf(b, "big_go_collector_alerts_pending %d\n", stats.Pending)
+	fmt.Fprintf(b, "# TYPE big_go_collector_alerts_fired_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_alerts_fired_total %d\n", stats.Fired)
+	fmt.Fprintf(b, "# TYPE big_go_collector_alerts_resolved_total counter\n")
+	fmt.Fprintf(b, "big_go_collector_alerts_resolved_total %d\n", stats.Resolved)
+
+	type ruleKey struct{ rule, severity string }
+	counts := make(map[ruleKey]int)
+	var keys []ruleKey
+	for _, alert := range firing {
+		key := ruleKey{alert.Rule, alert.Severity}
+		if counts[key] == 0 {
+			keys = append(keys, key)
+		}
+		counts[key]++
+	}
+	fmt.Fprintf(b, "# HELP big_go_collector_alerts_firing Сработавшие тревоги\n")
+	fmt.Fprintf(b, "# TYPE big_go_collector_alerts_firing gauge\n")
+	for _, key := range keys {
+		fmt.Fprintf(b, "big_go_collector_alerts_firing{rule=%q,severity=%q} %d\n", key.rule, key.severity, counts[key])
+	}
+}
+
+// writeOutboxMetrics добавляет метрики очередей outbox с меткой route
+func writeOutboxMetrics(b *strings.Builder, stats map[string]OutboxStats) {
+	routes := make([]string, 0, len(stats))
+	for route := range stats {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	metrics := []struct {
+		name, typ, help string
+		value           func(s OutboxStats) uint64
+	}{
+		{"big_go_collector_outbox_pending", "gauge", "Показания, ожидающие доставки",
+			func(s OutboxStats) uint64 { return uint64(s.Pending) }},
+		{"big_go_collector_outbox_retrying", "gauge", "Показания, ожидающие повтора после неудачной попытки",
+			func(s OutboxStats) uint64 { return uint64(s.Retrying) }},
+		{"big_go_collector_outbox_failed", "gauge", "Показания, которые не удалось доставить",
+			func(s OutboxStats) uint64 { return uint64(s.Failed) }},
+		{"big_go_collector_outbox_delivered_total", "counter", "Доставленные показания",
+			func(s OutboxStats) uint64 { return s.Delivered }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(b, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(b, "# TYPE %s %s\n", m.name, m.typ)
+		for _, route := range routes {
+			fmt.Fprintf(b, "%s{route=%q} %d\n", m.name, route, m.value(stats[route]))
+		}
+	}
+}
+
+// breakerStates - значения метрики состояния автомата
+var breakerStates = map[string]int{
+	BreakerClosed:   0,
+	BreakerHalfOpen: 1,
+	BreakerOpen:     2,
+}
+
+// writeBreakerMetrics добавляет метрики автоматов отключения с меткой endpoint
+func writeBreakerMetrics(b *strings.Builder, stats []BreakerStats) {
+	fmt.Fprintf(b, "# HELP big_go_collector_breaker_state Состояние автомата отключения: 0 - замкнут, 1 - пробные запросы, 2 - разомкнут\n")
+	fmt.Fprintf(b, "# TYPE big_go_collector_breaker_state gauge\n")
+	for _, s := range stats {
+		fmt.Fprintf(b, "big_go_collector_breaker_state{endpoint=%q} %d\n", s.Endpoint, breakerStates[s.State])
+	}
+	fmt.Fprintf(b, "# TYPE big_go_collector_breaker_opens_total counter\n")
+	for _, s := range stats {
+		fmt.Fprintf(b, "big_go_collector_breaker_opens_total{endpoint=%q} %d\n", s.Endpoint, s.Opens)
+	}
+	fmt.Fprintf(b, "# TYPE big_go_collector_breaker_rejected_total counter\n")
+	for _, s := range stats {
+		fmt.Fprintf(b, "big_go_collector_breaker_rejected_total{endpoint=%q} %d\n", s.Endpoint, s.Rejected)
+	}
+}
@@ -0,0 +1,563 @@
+package collector
+
+import (
+	"big_go/internal/services"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Расширения файлов очереди маршрута в каталоге outbox
+const (
+	walExt    = ".wal"    // журнал: добавленные и завершенные показания
+	failedExt = ".failed" // показания, которые не удалось доставить
+)
+
+// compactMin - сколько завершенных записей журнала копится до его сжатия
+const compactMin = 1024
+
+// maxRecordSize - наибольший размер записи журнала
+const maxRecordSize = 16 << 20
+
+// Операции записей журнала
+const (
+	opPut  = "put"
+	opDone = "done"
+)
+
+// ErrOutboxClosed возвращается Enqueue после остановки outbox
+var ErrOutboxClosed = errors.New("outbox остановлен")
+
+// OutboxOptions - параметры повторных попыток и записи журнала
+type OutboxOptions struct {
+	RetryMin    time.Duration // первая задержка повтора
+	RetryMax    time.Duration // наибольшая задержка повтора
+	MaxAge      time.Duration // показание старше этого считается недоставленным (0 - без ограничения)
+	MaxAttempts int           // число попыток, после которого показание недоставлено (0 - без ограничения)
+	Sync        bool          // сбрасывать журнал на диск при каждом добавлении
+}
+
+// OutboxStats - состояние очереди маршрута
+type OutboxStats struct {
+	Pending     int        `json:"pending"`  // ожидают доставки, включая повторяемое
+	Retrying    int        `json:"retrying"` // ожидают повтора после неудачной попытки
+	Failed      int        `json:"failed"`   // не доставлены, записаны в файл .failed
+	Delivered   uint64     `json:"delivered"`
+	LastError   string     `json:"last_error,omitempty"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+}
+
+// outboxRecord - строка журнала (JSON Lines)
+type outboxRecord struct {
+	Op          string     `json:"op"`
+	Seq         uint64     `json:"seq"`
+	ID          string     `json:"id,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	Body        []byte     `json:"body,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+}
+
+// outboxItem - показание в очереди маршрута
+type outboxItem struct {
+	seq         uint64
+	id          string
+	contentType string
+	body        []byte
+	created     time.Time
+	attempts    int
+}
+
+// failedRecord - строка файла недоставленных показаний
+type failedRecord struct {
+	ID          string    `json:"id"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	Created     time.Time `json:"created"`
+	Failed      time.Time `json:"failed"`
+	Attempts    int       `json:"attempts"`
+	Error       string    `json:"error"`
+}
+
+// outboxQueue - очередь одного маршрута со своим журналом
+type outboxQueue struct {
+	name   string
+	wal    *os.File
+	failed *os.File
+	wake   chan struct{}
+
+	items     []*outboxItem // по возрастанию seq; первое доставляется
+	seq       uint64
+	garbage   int // завершенные записи в журнале
+	failures  int
+	delivered uint64
+	lastError string
+	next      time.Time
+}
+
+// Outbox - надежная очередь доставки по маршрутам. Показание сначала
+// записывается в журнал на диске, затем отдельный обработчик маршрута
+// доставляет показания строго по порядку: следующее не отправляется, пока
+// не доставлено предыдущее. Неудачная доставка повторяется с
+// экспоненциальной задержкой со случайным разбросом, поэтому получатель,
+// который был недоступен, после перезапуска получает все пропущенное.
+// После перезапуска коллектора недоставленные показания читаются из журнала.
+//
+// Показание доставляется хотя бы один раз: при повторе маршрута с
+// несколькими адресами оно заново отправляется и на те, что его уже
+// приняли; получатели отбрасывают повторы по идентификатору.
+type Outbox struct {
+	dir    string
+	opts   OutboxOptions
+	router *Router
+	now    func() time.Time
+
+	mu     sync.Mutex
+	queues map[string]*outboxQueue
+	ctx    context.Context // задан, пока работает Run
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// OpenOutbox открывает каталог dir и читает журналы маршрутов
+func OpenOutbox(dir string, router *Router, opts OutboxOptions) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	o := &Outbox{
+		dir:    dir,
+		opts:   opts,
+		router: router,
+		now:    time.Now,
+		queues: make(map[string]*outboxQueue),
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"+walExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, filename := range names {
+		name, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(filename), walExt))
+		if err != nil {
+			log.Printf("Файл %s пропущен: %v", filename, err)
+			continue
+		}
+		q, err := o.openQueue(name)
+		if err != nil {
+			o.close()
+			return nil, err
+		}
+		o.queues[name] = q
+		if len(q.items) > 0 {
+			log.Printf("Outbox %s: %d показаний ожидают доставки", name, len(q.items))
+		}
+	}
+	return o, nil
+}
+
+// openQueue читает журнал маршрута, сжимает его и открывает для записи
+func (o *Outbox) openQueue(name string) (*outboxQueue, error) {
+	q := &outboxQueue{name: name, wake: make(chan struct{}, 1)}
+	base := filepath.Join(o.dir, url.PathEscape(name))
+
+	if err := q.load(base + walExt); err != nil {
+		return nil, fmt.Errorf("журнал outbox %s: %v", name, err)
+	}
+	failures, err := countLines(base + failedExt)
+	if err != nil {
+		return nil, fmt.Errorf("недоставленные outbox %s: %v", name, err)
+	}
+	q.failures = failures
+
+	if err := q.compact(base + walExt); err != nil {
+		return nil, fmt.Errorf("сжатие журнала outbox %s: %v", name, err)
+	}
+	q.failed, err = os.OpenFile(base+failedExt, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		q.wal.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+// load восстанавливает очередь из журнала. Незавершенная последняя
+// запись (сбой во время записи) пропускается.
+func (q *outboxQueue) load(filename string) error {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pending := make(map[uint64]*outboxItem)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		var rec outboxRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			log.Printf("Журнал %s, строка %d пропущена: %v", filename, line, err)
+			continue
+		}
+		if rec.Seq > q.seq {
+			q.seq = rec.Seq
+		}
+		switch rec.Op {
+		case opPut:
+			item := &outboxItem{
+				seq:         rec.Seq,
+				id:          rec.ID,
+				contentType: rec.ContentType,
+				body:        rec.Body,
+			}
+			if rec.Created != nil {
+				item.created = *rec.Created
+			}
+			pending[rec.Seq] = item
+		case opDone:
+			delete(pending, rec.Seq)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, item := range pending {
+		q.items = append(q.items, item)
+	}
+	sort.Slice(q.items, func(i, j int) bool { return q.items[i].seq < q.items[j].seq })
+	return nil
+}
+
+// compact переписывает журнал, оставляя только ожидающие показания, и
+// открывает его для добавления
+func (q *outboxQueue) compact(filename string) error {
+	tmp := filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, item := range q.items {
+		if err := enc.Encode(item.record()); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+
+	wal, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if q.wal != nil {
+		q.wal.Close()
+	}
+	q.wal, q.garbage = wal, 0
+	return nil
+}
+
+func (item *outboxItem) record() outboxRecord {
+	return outboxRecord{
+		Op:          opPut,
+		Seq:         item.seq,
+		ID:          item.id,
+		ContentType: item.contentType,
+		Body:        item.body,
+		Created:     &item.created,
+	}
+}
+
+// append дописывает запись в журнал
+func (q *outboxQueue) append(rec outboxRecord, sync bool) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := q.wal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if sync {
+		return q.wal.Sync()
+	}
+	return nil
+}
+
+// Enqueue записывает показание id в журнал маршрута route. Когда Enqueue
+// вернул nil, показание будет доставлено и после перезапуска коллектора.
+func (o *Outbox) Enqueue(route, id, contentType string, body []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return ErrOutboxClosed
+	}
+
+	q, ok := o.queues[route]
+	if !ok {
+		var err error
+		if q, err = o.openQueue(route); err != nil {
+			return err
+		}
+		o.queues[route] = q
+		if o.ctx != nil {
+			o.start(q)
+		}
+	}
+
+	item := &outboxItem{
+		seq:         q.seq + 1,
+		id:          id,
+		contentType: contentType,
+		body:        body,
+		created:     o.now(),
+	}
+	if err := q.append(item.record(), o.opts.Sync); err != nil {
+		return fmt.Errorf("ошибка записи в журнал outbox %s: %v", route, err)
+	}
+	q.seq = item.seq
+	q.items = append(q.items, item)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run доставляет показания всех маршрутов до отмены ctx, затем закрывает журналы
+func (o *Outbox) Run(ctx context.Context) {
+	o.mu.Lock()
+	o.ctx = ctx
+	for _, q := range o.queues {
+		o.start(q)
+	}
+	o.mu.Unlock()
+
+	<-ctx.Done()
+
+	// Новые маршруты больше не запускаются: wg.Add во время wg.Wait
+	// недопустим, а журналы закрываются после остановки всех обработчиков
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+	o.wg.Wait()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.close()
+}
+
+func (o *Outbox) close() {
+	for _, q := range o.queues {
+		q.wal.Close()
+		q.failed.Close()
+	}
+}
+
+// start запускает обработчик очереди; вызывается под o.mu
+func (o *Outbox) start(q *outboxQueue) {
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		o.deliver(o.ctx, q)
+	}()
+}
+
+// deliver доставляет показания очереди по порядку
+func (o *Outbox) deliver(ctx context.Context, q *outboxQueue) {
+	retry := services.NewBackoff(o.opts.RetryMin, o.opts.RetryMax)
+	for {
+		o.mu.Lock()
+		var item *outboxItem
+		if len(q.items) > 0 {
+			item = q.items[0]
+		}
+		o.mu.Unlock()
+
+		if item == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+			}
+			continue
+		}
+
+		var err error
+		if route, ok := o.router.Lookup(q.name); ok {
+			err = route.Deliver(ctx, Message{ID: item.id, ContentType: item.contentType, Body: item.body})
+		} else {
+			err = fmt.Errorf("маршрута %s нет в таблице", q.name)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			retry.Reset()
+			o.complete(q, item)
+			continue
+		}
+
+		o.mu.Lock()
+		item.attempts++
+		attempts := item.attempts
+		o.mu.Unlock()
+		if errors.Is(err, ErrPermanent) || o.expired(item, attempts) {
+			retry.Reset()
+			o.fail(q, item, err)
+			continue
+		}
+
+		wait := retry.Next()
+		o.mu.Lock()
+		q.lastError, q.next = err.Error(), o.now().Add(wait)
+		o.mu.Unlock()
+		log.Printf("Outbox %s: показание %s не доставлено (попытка %d): %v; повтор через %s",
+			q.name, item.id, attempts, err, wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// expired сообщает, что показание больше не нужно повторять
+func (o *Outbox) expired(item *outboxItem, attempts int) bool {
+	if o.opts.MaxAttempts > 0 && attempts >= o.opts.MaxAttempts {
+		return true
+	}
+	return o.opts.MaxAge > 0 && o.now().Sub(item.created) >= o.opts.MaxAge
+}
+
+// complete отмечает доставленное показание в журнале
+func (o *Outbox) complete(q *outboxQueue, item *outboxItem) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if item.attempts > 0 {
+		log.Printf("Outbox %s: показание %s доставлено с попытки %d", q.name, item.id, item.attempts+1)
+	}
+	q.delivered++
+	q.lastError, q.next = "", time.Time{}
+	o.remove(q)
+}
+
+// fail переносит показание в файл недоставленных
+func (o *Outbox) fail(q *outboxQueue, item *outboxItem, cause error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	log.Printf("Outbox %s: показание %s не доставлено за %d попыток и перенесено в недоставленные: %v",
+		q.name, item.id, item.attempts, cause)
+
+	line, err := json.Marshal(failedRecord{
+		ID:          item.id,
+		ContentType: item.contentType,
+		Body:        item.body,
+		Created:     item.created,
+		Failed:      o.now(),
+		Attempts:    item.attempts,
+		Error:       cause.Error(),
+	})
+	if err == nil {
+		_, err = q.failed.Write(append(line, '\n'))
+	}
+	if err != nil {
+		log.Printf("Outbox %s: ошибка записи недоставленного показания %s: %v", q.name, item.id, err)
+	}
+	q.failures++
+	q.lastError, q.next = cause.Error(), time.Time{}
+	o.remove(q)
+}
+
+// remove убирает первое показание очереди и при необходимости сжимает
+// журнал; вызывается под o.mu
+func (o *Outbox) remove(q *outboxQueue) {
+	item := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+
+	if err := q.append(outboxRecord{Op: opDone, Seq: item.seq}, false); err != nil {
+		// Без отметки показание будет доставлено повторно после перезапуска
+		log.Printf("Outbox %s: ошибка записи в журнал: %v", q.name, err)
+	}
+	q.garbage++
+	if q.garbage >= compactMin && q.garbage > len(q.items) {
+		filename := filepath.Join(o.dir, url.PathEscape(q.name)+walExt)
+		if err := q.compact(filename); err != nil {
+			log.Printf("Outbox %s: ошибка сжатия журнала: %v", q.name, err)
+		}
+	}
+}
+
+// Stats возвращает состояние очередей по маршрутам
+func (o *Outbox) Stats() map[string]OutboxStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	stats := make(map[string]OutboxStats, len(o.queues))
+	for name, q := range o.queues {
+		s := OutboxStats{
+			Pending:   len(q.items),
+			Failed:    q.failures,
+			Delivered: q.delivered,
+			LastError: q.lastError,
+		}
+		if len(q.items) > 0 && q.items[0].attempts > 0 {
+			s.Retrying = 1
+		}
+		if !q.next.IsZero() {
+			next := q.next
+			s.NextAttempt = &next
+		}
+		stats[name] = s
+	}
+	return stats
+}
+
+// countLines считает строки файла; отсутствующий файл пуст
+func countLines(filename string) (int, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for scanner.Scan() {
+		n++
+	}
+	return n, scanner.Err()
+}
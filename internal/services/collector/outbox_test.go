@@ -0,0 +1,383 @@
+package collector
+
+import (
+	"big_go/config"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClock - часы, которые идут только по Advance
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeSink возвращает ошибки errs по одной на каждую отправку, затем
+// принимает показания
+type fakeSink struct {
+	mu   sync.Mutex
+	errs []error
+	sent []string // идентификаторы принятых показаний
+}
+
+func (s *fakeSink) String() string { return "fake" }
+
+func (s *fakeSink) Send(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return err
+	}
+	s.sent = append(s.sent, msg.ID)
+	return nil
+}
+
+func (s *fakeSink) Sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.sent...)
+}
+
+// newTestRouter создает маршрутизатор с маршрутом route, который
+// доставляет показания адресату sink
+func newTestRouter(t *testing.T, route string, sink Sink) *Router {
+	t.Helper()
+	r, err := NewRouter(&config.RoutingTable{
+		Routes: map[string]config.RouteConfig{route: {Endpoints: []string{config.SinkStdout}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := r.Lookup(route)
+	rt.Sinks = []Sink{sink}
+	return r
+}
+
+// runOutbox запускает доставку; возвращаемая функция останавливает ее и
+// ждет закрытия журналов
+func runOutbox(o *Outbox) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// waitFor ждет, пока выполнится cond
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались: %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// readLines читает строки файла
+func readLines(t *testing.T, filename string) [][]byte {
+	t.Helper()
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines [][]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+// readJournal читает записи журнала outbox
+func readJournal(t *testing.T, filename string) []outboxRecord {
+	t.Helper()
+	var records []outboxRecord
+	for _, line := range readLines(t, filename) {
+		var rec outboxRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			t.Fatalf("%s: %v", filename, err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// readFailed читает записи файла недоставленных показаний
+func readFailed(t *testing.T, filename string) []failedRecord {
+	t.Helper()
+	var records []failedRecord
+	for _, line := range readLines(t, filename) {
+		var rec failedRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			t.Fatalf("%s: %v", filename, err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestOutboxDelivery(t *testing.T) {
+	transient := errors.New("connection refused")
+	permanent := fmt.Errorf("статус 400 Bad Request: %w", ErrPermanent)
+
+	tests := []struct {
+		name     string
+		opts     OutboxOptions
+		errs     []error       // ответы адресата
+		age      time.Duration // на сколько идут часы до доставки
+		failed   bool          // показание перенесено в недоставленные
+		attempts int           // попыток в записи недоставленного
+	}{
+		{name: "delivered", errs: nil},
+		{name: "delivered after retries", errs: []error{transient, transient}},
+		{name: "4xx is not retried", errs: []error{permanent}, failed: true, attempts: 1},
+		{
+			name:     "attempts exhausted",
+			opts:     OutboxOptions{MaxAttempts: 3},
+			errs:     []error{transient, transient, transient, transient},
+			failed:   true,
+			attempts: 3,
+		},
+		{
+			name:     "expired",
+			opts:     OutboxOptions{MaxAge: time.Minute},
+			errs:     []error{transient},
+			age:      time.Minute,
+			failed:   true,
+			attempts: 1,
+		},
+		{
+			name: "not expired yet",
+			opts: OutboxOptions{MaxAge: time.Minute},
+			errs: []error{transient},
+			age:  time.Minute - time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sink := &fakeSink{errs: tt.errs}
+			tt.opts.RetryMin, tt.opts.RetryMax = time.Millisecond, time.Millisecond
+			o, err := OpenOutbox(dir, newTestRouter(t, "user1", sink), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := newTestClock()
+			o.now = clock.Now
+
+			if err := o.Enqueue("user1", "a", "application/json", []byte(`{}`)); err != nil {
+				t.Fatal(err)
+			}
+			if err := o.Enqueue("user1", "b", "application/json", []byte(`{}`)); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(tt.age)
+			stop := runOutbox(o)
+			waitFor(t, "очередь пуста", func() bool { return o.Stats()["user1"].Pending == 0 })
+			stats := o.Stats()["user1"]
+			stop()
+
+			// Следующее показание ждет, пока не завершится первое
+			want := []string{"a", "b"}
+			if tt.failed {
+				want = []string{"b"}
+			}
+			if got := sink.Sent(); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("sent %v, want %v", got, want)
+			}
+
+			failed := readFailed(t, filepath.Join(dir, "user1"+failedExt))
+			if !tt.failed {
+				if len(failed) != 0 || stats.Failed != 0 {
+					t.Fatalf("failed = %+v (stats %d), want none", failed, stats.Failed)
+				}
+				return
+			}
+			if len(failed) != 1 || stats.Failed != 1 {
+				t.Fatalf("failed = %+v (stats %d), want one record", failed, stats.Failed)
+			}
+			if failed[0].ID != "a" || failed[0].Attempts != tt.attempts {
+				t.Errorf("failed record = %+v, want id a with %d attempts", failed[0], tt.attempts)
+			}
+			if !failed[0].Failed.Equal(clock.Now()) {
+				t.Errorf("failed at %v, want %v", failed[0].Failed, clock.Now())
+			}
+		})
+	}
+}
+
+func TestOutboxReplayTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	wal := filepath.Join(dir, "user1"+walExt)
+	// Сбой во время записи третьего показания: последняя строка оборвана
+	content := `{"op":"put","seq":1,"id":"a","content_type":"application/json","body":"e30="}
+{"op":"put","seq":2,"id":"b","content_type":"application/json","body":"e30="}
+{"op":"done","seq":1}
+{"op":"put","seq":3,"id":"c","content_ty`
+	if err := os.WriteFile(wal, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &fakeSink{}
+	router := newTestRouter(t, "user1", sink)
+	o, err := OpenOutbox(dir, router, OutboxOptions{RetryMin: time.Millisecond, RetryMax: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := o.Stats()["user1"].Pending; pending != 1 {
+		t.Fatalf("pending after replay = %d, want 1", pending)
+	}
+	// Журнал сжат при открытии: оборванной строки в нем больше нет
+	if records := readJournal(t, wal); len(records) != 1 || records[0].ID != "b" {
+		t.Fatalf("compacted journal = %+v, want only b", records)
+	}
+
+	// Новое показание дописывается после восстановленного
+	if err := o.Enqueue("user1", "d", "application/json", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	stop := runOutbox(o)
+	waitFor(t, "очередь пуста", func() bool { return o.Stats()["user1"].Pending == 0 })
+	stop()
+	if got := sink.Sent(); fmt.Sprint(got) != "[b d]" {
+		t.Errorf("sent %v, want [b d]", got)
+	}
+
+	// После перезапуска доставленное не повторяется
+	o, err = OpenOutbox(dir, router, OutboxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.close()
+	if pending := o.Stats()["user1"].Pending; pending != 0 {
+		t.Errorf("pending after restart = %d, want 0", pending)
+	}
+}
+
+func TestOutboxCompaction(t *testing.T) {
+	dir := t.TempDir()
+	sink := &fakeSink{}
+	o, err := OpenOutbox(dir, newTestRouter(t, "user1", sink), OutboxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Доставка не запущена: в журнале только добавления
+	const n = compactMin + 10
+	for i := 0; i < n; i++ {
+		if err := o.Enqueue("user1", fmt.Sprint(i), "application/json", []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop := runOutbox(o)
+	waitFor(t, "очередь пуста", func() bool { return o.Stats()["user1"].Pending == 0 })
+	stop()
+	if got := len(sink.Sent()); got != n {
+		t.Fatalf("sent %d, want %d", got, n)
+	}
+
+	// Журнал сжат после compactMin завершенных записей: в нем остались
+	// только показания, ожидавшие доставки в момент сжатия, и отметки о
+	// их доставке
+	records := readJournal(t, filepath.Join(dir, "user1"+walExt))
+	if len(records) != 2*(n-compactMin) {
+		t.Fatalf("journal has %d records after %d deliveries, want %d", len(records), n, 2*(n-compactMin))
+	}
+	for _, rec := range records {
+		if rec.Seq <= compactMin {
+			t.Errorf("record %+v left after compaction", rec)
+		}
+	}
+}
+
+func TestOutboxWebhookStatus(t *testing.T) {
+	tests := []struct {
+		status int // ответ на первый запрос; следующие - 200
+		failed bool
+	}{
+		{status: http.StatusBadRequest, failed: true},
+		{status: http.StatusNotFound, failed: true},
+		{status: http.StatusRequestTimeout},
+		{status: http.StatusTooManyRequests},
+		{status: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) == 1 {
+					w.WriteHeader(tt.status)
+				}
+			}))
+			defer server.Close()
+
+			dir := t.TempDir()
+			sink := &WebhookSink{URL: server.URL, Client: server.Client()}
+			o, err := OpenOutbox(dir, newTestRouter(t, "user1", sink), OutboxOptions{RetryMin: time.Millisecond, RetryMax: time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := o.Enqueue("user1", "a", "application/json", []byte(`{}`)); err != nil {
+				t.Fatal(err)
+			}
+			stop := runOutbox(o)
+			waitFor(t, "очередь пуста", func() bool { return o.Stats()["user1"].Pending == 0 })
+			stats := o.Stats()["user1"]
+			stop()
+
+			failed := readFailed(t, filepath.Join(dir, "user1"+failedExt))
+			if tt.failed {
+				if len(failed) != 1 || stats.Delivered != 0 || requests.Load() != 1 {
+					t.Errorf("failed %+v, delivered %d, requests %d; want one failed without retries",
+						failed, stats.Delivered, requests.Load())
+				}
+				return
+			}
+			if len(failed) != 0 || stats.Delivered != 1 || requests.Load() != 2 {
+				t.Errorf("failed %+v, delivered %d, requests %d; want delivered on retry",
+					failed, stats.Delivered, requests.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package collector
+
+import (
+	"big_go/internal/models"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Операции журнала карантина, кроме opPut
+const (
+	opRelease = "release"
+	opDiscard = "discard"
+	opEvict   = "evict"
+)
+
+// ErrNotQuarantined возвращается, если показания с идентификатором нет в карантине
+var ErrNotQuarantined = errors.New("показания нет в карантине")
+
+// ErrUnparsed возвращается при попытке выпустить из карантина сообщение,
+// не прошедшее проверку схемы
+var ErrUnparsed = errors.New("сообщение не разобрано, его можно только отбросить")
+
+// QuarantineEntry - показание в карантине. Для сообщения, не прошедшего
+// проверку схемы, Data пусто, а сообщение хранится как есть в Body.
+type QuarantineEntry struct {
+	ID            string            `json:"id"`
+	Reason        string            `json:"reason"`
+	Problems      []string          `json:"problems"`
+	Received      time.Time         `json:"received"`
+	Data          models.SensorData `json:"data"`
+	ContentType   string            `json:"content_type,omitempty"`
+	Body          []byte            `json:"body,omitempty"`
+	SchemaVersion int               `json:"schema_version,omitempty"` // версия схемы сообщения, если известна
+}
+
+// QuarantineFilter отбирает показания в карантине; нулевые поля не проверяются
+type QuarantineFilter struct {
+	Address int
+	PostID  int
+	Reason  string
+	Limit   int
+}
+
+// PostRejections - отклоненные показания поста по причинам с запуска коллектора
+type PostRejections struct {
+	Address int               `json:"address"`
+	PostID  int               `json:"post_id"`
+	Total   uint64            `json:"total"`
+	Reasons map[string]uint64 `json:"reasons"`
+}
+
+// QuarantineStats - состояние карантина
+type QuarantineStats struct {
+	Pending   int              `json:"pending"`   // ожидают решения оператора
+	Released  uint64           `json:"released"`  // выпущены и переданы дальше
+	Discarded uint64           `json:"discarded"` // отброшены оператором
+	Evicted   uint64           `json:"evicted"`   // вытеснены при переполнении
+	Posts     []PostRejections `json:"posts"`
+}
+
+// quarantineRecord - строка журнала карантина (JSON Lines)
+type quarantineRecord struct {
+	Op    string           `json:"op"`
+	ID    string           `json:"id"`
+	Entry *QuarantineEntry `json:"entry,omitempty"`
+}
+
+// Quarantine хранит показания, не прошедшие проверку, и сообщения, не
+// прошедшие проверку схемы, пока оператор не выпустит (Collector.Release)
+// или не отбросит (Discard) их. Показания записываются в журнал на диске и
+// переживают перезапуск коллектора; при переполнении вытесняются самые
+// старые.
+type Quarantine struct {
+	filename string
+	capacity int
+
+	mu        sync.Mutex
+	file      *os.File
+	entries   []*QuarantineEntry // по времени помещения
+	index     map[string]*QuarantineEntry
+	garbage   int // записи журнала о показаниях, которых уже нет
+	released  uint64
+	discarded uint64
+	evicted   uint64
+	posts     map[postKey]*PostRejections
+}
+
+// OpenQuarantine открывает журнал карантина; capacity <= 0 - без ограничения
+func OpenQuarantine(filename string, capacity int) (*Quarantine, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return nil, err
+	}
+	q := &Quarantine{
+		filename: filename,
+		capacity: capacity,
+		index:    make(map[string]*QuarantineEntry),
+		posts:    make(map[postKey]*PostRejections),
+	}
+	if err := q.load(); err != nil {
+		return nil, fmt.Errorf("журнал карантина %s: %v", filename, err)
+	}
+	if err := q.compact(); err != nil {
+		return nil, fmt.Errorf("сжатие журнала карантина %s: %v", filename, err)
+	}
+	return q, nil
+}
+
+// load восстанавливает карантин из журнала
+func (q *Quarantine) load() error {
+	f, err := os.Open(q.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		var rec quarantineRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			log.Printf("Журнал %s, строка %d пропущена: %v", q.filename, line, err)
+			continue
+		}
+		if rec.Op == opPut && rec.Entry != nil {
+			q.insert(rec.Entry)
+		} else {
+			q.delete(rec.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// compact переписывает журнал, оставляя только показания в карантине, и
+// открывает его для добавления
+func (q *Quarantine) compact() error {
+	tmp := q.filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, entry := range q.entries {
+		if err := enc.Encode(quarantineRecord{Op: opPut, ID: entry.ID, Entry: entry}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.filename); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(q.filename, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if q.file != nil {
+		q.file.Close()
+	}
+	q.file, q.garbage = file, 0
+	return nil
+}
+
+// append дописывает запись в журнал и сбрасывает его на диск
+func (q *Quarantine) append(rec quarantineRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := q.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return q.file.Sync()
+}
+
+// insert добавляет показание, заменяя прежнюю копию с тем же идентификатором
+func (q *Quarantine) insert(entry *QuarantineEntry) {
+	q.delete(entry.ID)
+	q.entries = append(q.entries, entry)
+	q.index[entry.ID] = entry
+}
+
+// delete удаляет показание из памяти; false - его нет
+func (q *Quarantine) delete(id string) bool {
+	entry, ok := q.index[id]
+	if !ok {
+		return false
+	}
+	delete(q.index, id)
+	for i, e := range q.entries {
+		if e == entry {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			break
+		}
+	}
+	q.garbage++
+	return true
+}
+
+// Add помещает показание в карантин с причиной verr и учитывает его в
+// счетчиках поста. Когда Add вернул nil, показание записано на диск.
+func (q *Quarantine) Add(data models.SensorData, verr *ValidationError) error {
+	return q.put(&QuarantineEntry{
+		ID:       data.ID,
+		Reason:   verr.Reason,
+		Problems: verr.Problems,
+		Received: time.Now(),
+		Data:     data,
+	})
+}
+
+// AddMessage помещает в карантин сообщение, не прошедшее проверку схемы,
+// с причиной derr. Без идентификатора (message-id) сообщение получает
+// идентификатор по содержимому, поэтому повторная доставка заменяет
+// прежнюю копию. Пост сообщения неизвестен: оно учитывается в счетчиках
+// поста 0 адреса 0.
+func (q *Quarantine) AddMessage(id, contentType string, body []byte, derr *models.DecodeError) error {
+	if id == "" {
+		sum := sha256.Sum256(body)
+		id = "sha256-" + hex.EncodeToString(sum[:8])
+	}
+	return q.put(&QuarantineEntry{
+		ID:            id,
+		Reason:        derr.Reason,
+		Problems:      derr.Problems,
+		Received:      time.Now(),
+		Data:          models.SensorData{ID: id},
+		ContentType:   contentType,
+		Body:          body,
+		SchemaVersion: derr.Version,
+	})
+}
+
+// put записывает показание в журнал и добавляет его в карантин
+func (q *Quarantine) put(entry *QuarantineEntry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.append(quarantineRecord{Op: opPut, ID: entry.ID, Entry: entry}); err != nil {
+		return err
+	}
+	q.insert(entry)
+	q.count(entry.Data, entry.Reason)
+
+	for q.capacity > 0 && len(q.entries) > q.capacity {
+		oldest := q.entries[0]
+		if err := q.append(quarantineRecord{Op: opEvict, ID: oldest.ID}); err != nil {
+			log.Printf("Ошибка вытеснения показания %s из карантина: %v", oldest.ID, err)
+			break
+		}
+		q.delete(oldest.ID)
+		q.evicted++
+	}
+	q.maybeCompact()
+	return nil
+}
+
+// count учитывает отклоненное показание в счетчиках поста; вызывается под q.mu
+func (q *Quarantine) count(data models.SensorData, reason string) {
+	key := postKey{data.Meta.Address, data.Meta.PostID}
+	post, ok := q.posts[key]
+	if !ok {
+		post = &PostRejections{Address: key.address, PostID: key.post, Reasons: make(map[string]uint64)}
+		q.posts[key] = post
+	}
+	post.Total++
+	post.Reasons[reason]++
+}
+
+// maybeCompact сжимает журнал, когда в нем накопилось много удаленных
+// записей; вызывается под q.mu
+func (q *Quarantine) maybeCompact() {
+	if q.garbage < compactMin || q.garbage <= len(q.entries) {
+		return
+	}
+	if err := q.compact(); err != nil {
+		log.Printf("Ошибка сжатия журнала карантина: %v", err)
+	}
+}
+
+// Get возвращает показание в карантине
+func (q *Quarantine) Get(id string) (QuarantineEntry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry, ok := q.index[id]
+	if !ok {
+		return QuarantineEntry{}, false
+	}
+	return *entry, true
+}
+
+// List возвращает показания, подходящие под фильтр, от старых к новым
+func (q *Quarantine) List(f QuarantineFilter) []QuarantineEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	result := []QuarantineEntry{}
+	for _, entry := range q.entries {
+		if f.Address != 0 && entry.Data.Meta.Address != f.Address {
+			continue
+		}
+		if f.PostID != 0 && entry.Data.Meta.PostID != f.PostID {
+			continue
+		}
+		if f.Reason != "" && entry.Reason != f.Reason {
+			continue
+		}
+		result = append(result, *entry)
+		if f.Limit > 0 && len(result) >= f.Limit {
+			break
+		}
+	}
+	return result
+}
+
+// remove удаляет показание из карантина, записывая решение оператора
+func (q *Quarantine) remove(id, op string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.index[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotQuarantined, id)
+	}
+	if err := q.append(quarantineRecord{Op: op, ID: id}); err != nil {
+		return err
+	}
+	q.delete(id)
+	if op == opRelease {
+		q.released++
+	} else {
+		q.discarded++
+	}
+	q.maybeCompact()
+	return nil
+}
+
+// Discard отбрасывает показание из карантина
+func (q *Quarantine) Discard(id string) error {
+	return q.remove(id, opDiscard)
+}
+
+// Stats возвращает состояние карантина и счетчики отклоненных показаний по постам
+func (q *Quarantine) Stats() QuarantineStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := QuarantineStats{
+		Pending:   len(q.entries),
+		Released:  q.released,
+		Discarded: q.discarded,
+		Evicted:   q.evicted,
+		Posts:     make([]PostRejections, 0, len(q.posts)),
+	}
+	for _, post := range q.posts {
+		reasons := make(map[string]uint64, len(post.Reasons))
+		for reason, n := range post.Reasons {
+			reasons[reason] = n
+		}
+		stats.Posts = append(stats.Posts, PostRejections{
+			Address: post.Address,
+			PostID:  post.PostID,
+			Total:   post.Total,
+			Reasons: reasons,
+		})
+	}
+	sort.Slice(stats.Posts, func(i, j int) bool {
+		if stats.Posts[i].Address != stats.Posts[j].Address {
+			return stats.Posts[i].Address < stats.Posts[j].Address
+		}
+		return stats.Posts[i].PostID < stats.Posts[j].PostID
+	})
+	return stats
+}
+
+// Close закрывает журнал
+func (q *Quarantine) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.file.Close()
+}
@@ -0,0 +1,375 @@
+package collector
+
+import (
+	"big_go/config"
+	"big_go/internal/filter"
+	"big_go/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ошибки маршрутизации получателей, которых нет в таблице
+var (
+	ErrRejected   = errors.New("неизвестный получатель")
+	ErrDeadLetter = errors.New("неизвестный получатель, показание направляется в очередь недоставленных")
+)
+
+// Route - маршрут получателя или правила: показание отправляется каждому адресату
+type Route struct {
+	Name      string   // получатель, имя правила или default
+	Endpoints []string // описания адресатов из таблицы
+	Sinks     []Sink
+	Fallback  Sink // резервный адресат или nil
+}
+
+// newRoute создает маршрут и его адресатов
+func (r *Router) newRoute(name string, cfg config.RouteConfig, reg *sinkRegistry) *Route {
+	route := &Route{Name: name, Endpoints: cfg.Endpoints}
+	for _, endpoint := range cfg.Endpoints {
+		route.Sinks = append(route.Sinks, r.newSink(endpoint, cfg, reg))
+	}
+	if cfg.Fallback != "" {
+		route.Fallback = r.newSink(cfg.Fallback, cfg, reg)
+	}
+	return route
+}
+
+// Deliver отправляет показание всем адресатам маршрута; возвращаются
+// ошибки всех неудачных адресатов. Если все неудачи вызваны разомкнутыми
+// автоматами отключения и у маршрута есть резервный адресат, показание
+// отправляется ему; если все неудачные адресаты отклонили показание,
+// ошибка - ErrPermanent.
+func (r *Route) Deliver(ctx context.Context, msg Message) error {
+	msg.Route = r.Name
+	var failed []string
+	open, permanent := 0, 0
+	for _, sink := range r.Sinks {
+		if err := sink.Send(ctx, msg); err != nil {
+			switch {
+			case errors.Is(err, ErrCircuitOpen):
+				open++
+			case errors.Is(err, ErrPermanent):
+				permanent++
+			}
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	cause := errors.New(strings.Join(failed, "; "))
+	// Показание, которое отклонили все не принявшие его адресаты, не
+	// повторяется; при других ошибках повторяется всем адресатам
+	if permanent == len(failed) {
+		return fmt.Errorf("%w: %v", ErrPermanent, cause)
+	}
+	if open < len(failed) || r.Fallback == nil {
+		return cause
+	}
+	msg.Error = cause.Error()
+	if err := r.Fallback.Send(ctx, msg); err != nil {
+		return fmt.Errorf("%v; резервный адресат %s: %v", cause, r.Fallback, err)
+	}
+	log.Printf("Маршрут %s: адреса недоступны (%v), показание отправлено резервному адресату %s", r.Name, cause, r.Fallback)
+	return nil
+}
+
+// rule - правило маршрутизации по содержимому с разобранным условием
+type rule struct {
+	name   string
+	when   *filter.Filter
+	routes []*Route
+}
+
+// Router выбирает маршруты показания: по получателю и по правилам.
+// Таблицу можно заменить во время работы (Update, Reload, Watch); уже
+// выбранные маршруты не меняются.
+type Router struct {
+	mu         sync.RWMutex
+	routes     map[string]*Route
+	rules      []rule
+	def        *Route
+	notifiers  map[string]*Route // адресаты уведомлений о тревогах
+	unknown    string
+	modTime    time.Time     // время изменения файла последней загруженной таблицы
+	sinks      *sinkRegistry // адресаты с состоянием; переживают перезагрузку таблицы
+	client     *http.Client  // клиент адресатов http(s)
+	deadLetter DeadLetterFunc
+}
+
+// NewRouter создает маршрутизатор по проверенной таблице
+func NewRouter(table *config.RoutingTable) (*Router, error) {
+	r := &Router{client: &http.Client{}}
+	if err := r.Update(table); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Update заменяет таблицу маршрутов; таблица должна быть проверена
+// (config.LoadRoutingTable). При ошибке остается прежняя таблица.
+func (r *Router) Update(table *config.RoutingTable) error {
+	// Автоматы адресов, оставшихся в таблице, сохраняют состояние
+	settings := BreakerSettings{
+		Failures: table.Breaker.Failures,
+		Cooldown: table.Breaker.Cooldown.Duration,
+		Probes:   table.Breaker.Probes,
+	}
+	r.mu.RLock()
+	reg := newSinkRegistry(settings, r.sinks)
+	r.mu.RUnlock()
+
+	routes := make(map[string]*Route, len(table.Routes))
+	for recipient, cfg := range table.Routes {
+		routes[recipient] = r.newRoute(recipient, cfg, reg)
+	}
+	var def *Route
+	if table.Default != nil {
+		def = r.newRoute("default", *table.Default, reg)
+	}
+	notifiers := make(map[string]*Route, len(table.Notifiers))
+	for name, cfg := range table.Notifiers {
+		notifiers[name] = r.newRoute(name, cfg, reg)
+	}
+
+	rules := make([]rule, 0, len(table.Rules))
+	for _, cfg := range table.Rules {
+		when, err := filter.Compile(cfg.When)
+		if err != nil {
+			return fmt.Errorf("правило %s: %v", cfg.Name, err)
+		}
+		rl := rule{name: cfg.Name, when: when}
+		for _, target := range cfg.Targets {
+			route, ok := routes[target]
+			if !ok {
+				return fmt.Errorf("правило %s: нет маршрута %s", cfg.Name, target)
+			}
+			rl.routes = append(rl.routes, route)
+		}
+		if len(cfg.Endpoints) > 0 {
+			rl.routes = append(rl.routes, r.newRoute(cfg.Name, cfg.RouteConfig, reg))
+		}
+		rules = append(rules, rl)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes, r.rules, r.def, r.unknown = routes, rules, def, table.Unknown
+	r.notifiers = notifiers
+	// Прежний реестр больше не нужен, иначе он удерживал бы удаленных адресатов
+	reg.current = nil
+	r.sinks = reg
+	return nil
+}
+
+// SetDeadLetter задает, как маршруты с резервным адресатом dead_letter
+// помещают показания в очередь недоставленных
+func (r *Router) SetDeadLetter(deadLetter DeadLetterFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deadLetter = deadLetter
+}
+
+// Breakers возвращает состояние автоматов отключения по адресам
+func (r *Router) Breakers() []BreakerStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	stats := make([]BreakerStats, 0, len(r.sinks.breakers))
+	for _, b := range r.sinks.breakers {
+		stats = append(stats, b.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Endpoint < stats[j].Endpoint })
+	return stats
+}
+
+// ResetBreaker замыкает автомат адреса endpoint; false - такого адреса нет
+func (r *Router) ResetBreaker(endpoint string) bool {
+	r.mu.RLock()
+	b, ok := r.sinks.breakers[endpoint]
+	r.mu.RUnlock()
+	if ok {
+		b.Reset()
+	}
+	return ok
+}
+
+// MemorySink возвращает адресата в памяти по имени (memory:имя в таблице)
+func (r *Router) MemorySink(name string) (*MemorySink, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.sinks.memories[name]
+	return s, ok
+}
+
+// Resolve возвращает маршруты показания: маршрут получателя и маршруты
+// всех правил, под которые оно подходит, без повторов. Если маршрутов
+// нет, действует политика unknown: ошибка ErrRejected или ErrDeadLetter
+// либо маршрут default.
+func (r *Router) Resolve(data models.SensorData) ([]*Route, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var routes []*Route
+	seen := make(map[*Route]bool)
+	add := func(route *Route) {
+		if !seen[route] {
+			seen[route] = true
+			routes = append(routes, route)
+		}
+	}
+
+	if route, ok := r.routes[data.Meta.Recipient]; ok {
+		add(route)
+	}
+	for _, rl := range r.rules {
+		if rl.when.Match(data) {
+			for _, route := range rl.routes {
+				add(route)
+			}
+		}
+	}
+	if len(routes) > 0 {
+		return routes, nil
+	}
+
+	switch r.unknown {
+	case config.UnknownDefault:
+		return []*Route{r.def}, nil
+	case config.UnknownDeadLetter:
+		return nil, fmt.Errorf("%w: %q", ErrDeadLetter, data.Meta.Recipient)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrRejected, data.Meta.Recipient)
+}
+
+// Lookup возвращает маршрут по имени: получателя, правила, default или
+// адресата уведомлений
+func (r *Router) Lookup(name string) (*Route, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if route, ok := r.routes[name]; ok {
+		return route, true
+	}
+	for _, rl := range r.rules {
+		for _, route := range rl.routes {
+			if route.Name == name {
+				return route, true
+			}
+		}
+	}
+	if r.def != nil && r.def.Name == name {
+		return r.def, true
+	}
+	if route, ok := r.notifiers[name]; ok {
+		return route, true
+	}
+	return nil, false
+}
+
+// Rules возвращает имена правил маршрутизации по содержимому
+func (r *Router) Rules() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, len(r.rules))
+	for i, rl := range r.rules {
+		names[i] = rl.name
+	}
+	return names
+}
+
+// Recipients возвращает получателей, для которых есть маршруты
+func (r *Router) Recipients() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	recipients := make([]string, 0, len(r.routes))
+	for recipient := range r.routes {
+		recipients = append(recipients, recipient)
+	}
+	sort.Strings(recipients)
+	return recipients
+}
+
+// LoadRouter создает маршрутизатор по таблице из файла
+func LoadRouter(filename string) (*Router, error) {
+	r := &Router{client: &http.Client{}}
+	if err := r.Reload(filename); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload загружает таблицу из файла и заменяет текущую. При ошибке
+// остается прежняя таблица, а Watch ждет следующего изменения файла.
+func (r *Router) Reload(filename string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.modTime = info.ModTime()
+	r.mu.Unlock()
+
+	table, err := config.LoadRoutingTable(filename)
+	if err != nil {
+		return err
+	}
+	return r.Update(table)
+}
+
+// Watch проверяет файл таблицы каждые interval и перезагружает его при
+// изменении, а также по сигналу из reload (например, SIGHUP).
+// Работает до отмены ctx.
+func (r *Router) Watch(ctx context.Context, filename string, interval time.Duration, reload <-chan os.Signal) {
+	watchFile(ctx, filename, interval, reload, func() time.Time {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.modTime
+	}, func() { r.reload(filename) })
+}
+
+// watchFile вызывает load каждые interval, если время изменения файла
+// отличается от loaded(), и по каждому сигналу из reload, до отмены ctx
+func watchFile(ctx context.Context, filename string, interval time.Duration, reload <-chan os.Signal,
+	loaded func() time.Time, load func()) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			load()
+		case <-tick:
+			info, err := os.Stat(filename)
+			if err != nil {
+				log.Printf("Файл %s недоступен: %v", filename, err)
+				continue
+			}
+			if !info.ModTime().Equal(loaded()) {
+				load()
+			}
+		}
+	}
+}
+
+func (r *Router) reload(filename string) {
+	if err := r.Reload(filename); err != nil {
+		log.Printf("Таблица маршрутов не перезагружена, действует прежняя: %v", err)
+		return
+	}
+	log.Printf("Таблица маршрутов перезагружена из %s: получатели %v, правила %v", filename, r.Recipients(), r.Rules())
+}
@@ -0,0 +1,334 @@
+package collector
+
+import (
+	"big_go/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrPermanent - адресат отклонил показание (ответ 4xx, кроме 408 и 429):
+// повтор ничего не изменит, и показание переносится в недоставленные сразу
+var ErrPermanent = errors.New("адресат отклонил показание")
+
+// Message - закодированное показание для доставки по маршруту
+type Message struct {
+	Route       string
+	ID          string // идентификатор показания
+	ContentType string
+	Body        []byte
+	Error       string // почему показание отправлено резервному адресату
+}
+
+// Sink - адресат доставки показаний. Ошибка Send означает, что доставку
+// нужно повторить; адресат должен переносить повторы одного показания.
+type Sink interface {
+	Send(ctx context.Context, msg Message) error
+	String() string
+}
+
+// newSink создает адресата по его описанию в таблице маршрутов (см.
+// config.RouteConfig); адресаты с состоянием берутся из реестра роутера
+func (r *Router) newSink(target string, route config.RouteConfig, reg *sinkRegistry) Sink {
+	switch {
+	case target == config.FallbackDeadLetter:
+		return deadLetterSink{router: r}
+	case target == config.SinkStdout:
+		return stdoutSink
+	case strings.HasPrefix(target, config.SinkFilePrefix):
+		return FileSink(strings.TrimPrefix(target, config.SinkFilePrefix))
+	case strings.HasPrefix(target, config.SinkMemoryPrefix):
+		return reg.memory(strings.TrimPrefix(target, config.SinkMemoryPrefix))
+	}
+	return &WebhookSink{
+		URL:     target,
+		Timeout: route.Timeout.Duration,
+		Headers: route.Headers,
+		Client:  r.client,
+		Breaker: reg.breaker(target),
+	}
+}
+
+// WebhookSink отправляет показание запросом POST. Ответ со статусом вне
+// 2xx считается ошибкой; ответ 4xx, кроме 408 и 429, - ошибкой
+// ErrPermanent. Пока автомат отключения адреса разомкнут, запрос не
+// выполняется и возвращается ErrCircuitOpen.
+type WebhookSink struct {
+	URL     string
+	Timeout time.Duration
+	Headers map[string]string
+	Client  *http.Client
+	Breaker *Breaker // nil - без автомата
+}
+
+func (s *WebhookSink) String() string { return s.URL }
+
+func (s *WebhookSink) Send(ctx context.Context, msg Message) error {
+	if s.Breaker != nil {
+		if err := s.Breaker.Allow(); err != nil {
+			return fmt.Errorf("%s: %w", s.URL, err)
+		}
+	}
+	err := s.post(ctx, msg)
+	if s.Breaker != nil {
+		// Адрес, отклонивший показание, исправен: автомат не размыкается
+		if errors.Is(err, ErrPermanent) {
+			s.Breaker.Done(nil)
+		} else {
+			s.Breaker.Done(err)
+		}
+	}
+	return err
+}
+
+func (s *WebhookSink) post(ctx context.Context, msg Message) error {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(msg.Body))
+	if err != nil {
+		return fmt.Errorf("%s: %v", s.URL, err)
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", msg.ContentType)
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %v", s.URL, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode <= 499 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%s: статус %s: %w", s.URL, resp.Status, ErrPermanent)
+	}
+	return fmt.Errorf("%s: статус %s", s.URL, resp.Status)
+}
+
+// sinkRecord - показание или сводка в файле или потоке JSON Lines. Тело в
+// JSON (application/json и типы с суффиксом +json) встраивается как есть,
+// остальные форматы - в base64.
+type sinkRecord struct {
+	Time        time.Time       `json:"time"`
+	Route       string          `json:"route"`
+	ID          string          `json:"id,omitempty"`
+	ContentType string          `json:"content_type"`
+	Body        json.RawMessage `json:"body"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// marshalRecord возвращает строку JSON Lines с показанием
+func marshalRecord(msg Message) ([]byte, error) {
+	body := json.RawMessage(msg.Body)
+	if !isJSON(msg.ContentType) || !json.Valid(msg.Body) {
+		encoded, err := json.Marshal(msg.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = encoded
+	}
+	line, err := json.Marshal(sinkRecord{
+		Time:        time.Now(),
+		Route:       msg.Route,
+		ID:          msg.ID,
+		ContentType: msg.ContentType,
+		Body:        body,
+		Error:       msg.Error,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// isJSON сообщает, что тип содержимого - JSON
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// WriterSink пишет показания в поток строками JSON Lines
+type WriterSink struct {
+	Name string
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// NewWriterSink создает адресата, пишущего в w
+func NewWriterSink(name string, w io.Writer) *WriterSink {
+	return &WriterSink{Name: name, w: w}
+}
+
+// stdoutSink - общий адресат stdout: строки разных маршрутов не перемешиваются
+var stdoutSink = NewWriterSink(config.SinkStdout, os.Stdout)
+
+func (s *WriterSink) String() string { return s.Name }
+
+func (s *WriterSink) Send(_ context.Context, msg Message) error {
+	line, err := marshalRecord(msg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(line)
+	return err
+}
+
+// FileSink дописывает показания в файл JSON Lines; каталог создается при
+// первой записи
+type FileSink string
+
+func (s FileSink) String() string { return config.SinkFilePrefix + string(s) }
+
+func (s FileSink) Send(_ context.Context, msg Message) error {
+	line, err := marshalRecord(msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(string(s)), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(string(s), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	// Строка пишется одним вызовом, чтобы маршруты с общим файлом не перемешивали записи
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// memorySinkSize - сколько последних показаний хранит MemorySink
+const memorySinkSize = 1000
+
+// MemorySink хранит последние показания в памяти; их можно получить через
+// Messages, например на странице состояния коллектора
+type MemorySink struct {
+	Name     string
+	mu       sync.Mutex
+	messages []Message
+	total    uint64
+}
+
+// NewMemorySink создает адресата в памяти
+func NewMemorySink(name string) *MemorySink {
+	return &MemorySink{Name: name}
+}
+
+func (s *MemorySink) String() string { return config.SinkMemoryPrefix + s.Name }
+
+func (s *MemorySink) Send(_ context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	if len(s.messages) > memorySinkSize {
+		s.messages = append([]Message(nil), s.messages[len(s.messages)-memorySinkSize:]...)
+	}
+	s.total++
+	return nil
+}
+
+// Messages возвращает сохраненные показания, новые в конце, и общее число принятых
+func (s *MemorySink) Messages() ([]Message, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...), s.total
+}
+
+// DeadLetterFunc помещает показание в очередь недоставленных
+type DeadLetterFunc func(msg Message) error
+
+// deadLetterSink публикует показания в очередь недоставленных через
+// функцию, заданную Router.SetDeadLetter
+type deadLetterSink struct {
+	router *Router
+}
+
+func (deadLetterSink) String() string { return config.FallbackDeadLetter }
+
+func (s deadLetterSink) Send(_ context.Context, msg Message) error {
+	s.router.mu.RLock()
+	deadLetter := s.router.deadLetter
+	s.router.mu.RUnlock()
+	if deadLetter == nil {
+		return errors.New("очередь недоставленных не настроена")
+	}
+	return deadLetter(msg)
+}
+
+// sinkRegistry - адресаты с состоянием: автоматы отключения по адресам и
+// адресаты в памяти по именам. Они переживают перезагрузку таблицы.
+type sinkRegistry struct {
+	settings BreakerSettings
+	current  *sinkRegistry
+	breakers map[string]*Breaker
+	memories map[string]*MemorySink
+}
+
+func newSinkRegistry(settings BreakerSettings, current *sinkRegistry) *sinkRegistry {
+	return &sinkRegistry{
+		settings: settings,
+		current:  current,
+		breakers: make(map[string]*Breaker),
+		memories: make(map[string]*MemorySink),
+	}
+}
+
+// breaker возвращает автомат адреса, сохраняя состояние прежнего
+func (reg *sinkRegistry) breaker(endpoint string) *Breaker {
+	b, ok := reg.breakers[endpoint]
+	if ok {
+		return b
+	}
+	if reg.current != nil {
+		b, ok = reg.current.breakers[endpoint]
+	}
+	if ok {
+		b.Configure(reg.settings)
+	} else {
+		b = NewBreaker(endpoint, reg.settings)
+	}
+	reg.breakers[endpoint] = b
+	return b
+}
+
+// memory возвращает адресата в памяти, сохраняя накопленные показания
+func (reg *sinkRegistry) memory(name string) *MemorySink {
+	s, ok := reg.memories[name]
+	if ok {
+		return s
+	}
+	if reg.current != nil {
+		s, ok = reg.current.memories[name]
+	}
+	if !ok {
+		s = NewMemorySink(name)
+	}
+	reg.memories[name] = s
+	return s
+}
@@ -0,0 +1,198 @@
+package collector
+
+import (
+	"big_go/config"
+	"big_go/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Причины отклонения показания проверкой
+const (
+	ReasonTimestamp   = "timestamp"    // время не задано или вне допустимого сдвига
+	ReasonUnknownPost = "unknown_post" // адреса или поста нет в реестре
+	ReasonRecipient   = "recipient"    // получатель не совпадает с владельцем поста
+	ReasonRange       = "range"        // значение вне физически допустимого диапазона
+)
+
+// ErrInvalid - показание не прошло проверку (см. ValidationError)
+var ErrInvalid = errors.New("показание не прошло проверку")
+
+// ValidationError описывает, почему показание не прошло проверку
+type ValidationError struct {
+	Reason   string   // причина первого нарушения, одна из Reason*
+	Problems []string // все нарушения
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, strings.Join(e.Problems, "; "))
+}
+
+// Is позволяет проверять ошибку через errors.Is(err, ErrInvalid)
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// postKey - адрес и пост
+type postKey struct {
+	address int
+	post    int
+}
+
+// Validator проверяет показания по правилам config.ValidationConfig:
+// время показания, наличие поста в реестре и его владельца, физические
+// диапазоны значений. Реестр постов можно перечитать во время работы
+// (ReloadPosts, Watch).
+type Validator struct {
+	cfg *config.ValidationConfig
+	now func() time.Time
+
+	mu      sync.RWMutex
+	posts   map[postKey]string // пост -> получатель по реестру; nil - реестр не задан
+	modTime time.Time          // время изменения файла реестра при последней загрузке
+}
+
+// NewValidator создает проверку по правилам cfg; реестр постов читается из
+// cfg.TopologyFile
+func NewValidator(cfg *config.ValidationConfig) (*Validator, error) {
+	v := &Validator{cfg: cfg, now: time.Now}
+	if cfg.TopologyFile != "" {
+		if err := v.ReloadPosts(); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// LoadValidator загружает правила из файла и создает по ним проверку
+func LoadValidator(filename string) (*Validator, error) {
+	cfg, err := config.LoadValidation(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewValidator(cfg)
+}
+
+// ReloadPosts перечитывает реестр постов из cfg.TopologyFile. При ошибке
+// остается прежний реестр, а Watch ждет следующего изменения файла.
+func (v *Validator) ReloadPosts() error {
+	filename := v.cfg.TopologyFile
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	v.modTime = info.ModTime()
+	v.mu.Unlock()
+
+	topology, err := config.LoadTopology(filename)
+	if err != nil {
+		return err
+	}
+	posts := make(map[postKey]string)
+	for _, address := range topology.Addresses {
+		for _, post := range address.Posts {
+			posts[postKey{address.Address, post.PostID}] = post.Recipient
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.posts = posts
+	return nil
+}
+
+// Watch проверяет файл реестра постов каждые interval и перечитывает его
+// при изменении, а также по сигналу из reload (например, SIGHUP).
+// Работает до отмены ctx; без реестра сразу возвращается.
+func (v *Validator) Watch(ctx context.Context, interval time.Duration, reload <-chan os.Signal) {
+	filename := v.cfg.TopologyFile
+	if filename == "" {
+		return
+	}
+	watchFile(ctx, filename, interval, reload, func() time.Time {
+		v.mu.RLock()
+		defer v.mu.RUnlock()
+		return v.modTime
+	}, func() {
+		if err := v.ReloadPosts(); err != nil {
+			log.Printf("Реестр постов не перечитан, действует прежний: %v", err)
+			return
+		}
+		log.Printf("Реестр постов перечитан из %s: постов %d", filename, v.Posts())
+	})
+}
+
+// Posts возвращает число постов в реестре (0 - реестр не задан)
+func (v *Validator) Posts() int {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.posts)
+}
+
+// Check возвращает *ValidationError со всеми нарушениями или nil
+func (v *Validator) Check(data models.SensorData) error {
+	verr := &ValidationError{}
+	add := func(reason, format string, args ...interface{}) {
+		if verr.Reason == "" {
+			verr.Reason = reason
+		}
+		verr.Problems = append(verr.Problems, fmt.Sprintf(format, args...))
+	}
+
+	ts := data.Meta.Timestamp
+	now := v.now()
+	switch {
+	case ts.IsZero():
+		add(ReasonTimestamp, "время показания не задано")
+	case v.cfg.MaxAge.Duration > 0 && now.Sub(ts) > v.cfg.MaxAge.Duration:
+		add(ReasonTimestamp, "показание старше %s: %s", v.cfg.MaxAge.Duration, ts.Format(time.RFC3339))
+	case v.cfg.MaxFuture.Duration > 0 && ts.Sub(now) > v.cfg.MaxFuture.Duration:
+		add(ReasonTimestamp, "время показания опережает время приема больше чем на %s: %s",
+			v.cfg.MaxFuture.Duration, ts.Format(time.RFC3339))
+	}
+
+	v.mu.RLock()
+	posts := v.posts
+	v.mu.RUnlock()
+	if posts != nil {
+		recipient, ok := posts[postKey{data.Meta.Address, data.Meta.PostID}]
+		switch {
+		case !ok:
+			add(ReasonUnknownPost, "поста %d на адресе %d нет в реестре", data.Meta.PostID, data.Meta.Address)
+		case v.cfg.CheckRecipient && recipient != data.Meta.Recipient:
+			add(ReasonRecipient, "получатель %q, а пост принадлежит %q", data.Meta.Recipient, recipient)
+		}
+	}
+
+	for _, name := range data.Data.Names() {
+		r, ok := v.cfg.Ranges[name]
+		if !ok {
+			continue
+		}
+		if value := data.Data[name].Value; !r.Contains(value) {
+			add(ReasonRange, "%s = %g вне диапазона %s", name, value, formatRange(r))
+		}
+	}
+
+	if verr.Reason == "" {
+		return nil
+	}
+	return verr
+}
+
+func formatRange(r config.MetricRange) string {
+	bound := func(b *float64) string {
+		if b == nil {
+			return "..."
+		}
+		return fmt.Sprintf("%g", *b)
+	}
+	return "[" + bound(r.Min) + ", " + bound(r.Max) + "]"
+}
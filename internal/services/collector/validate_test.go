@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"big_go/config"
+	"big_go/internal/models"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidatorReloadPosts(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "topology.json")
+	writeTopology := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTopology(`{"interval": "2s", "metrics": ["temperature"], "addresses": [{"address": 1, "recipient": "User1", "posts": [{"post_id": 1}]}]}`)
+
+	v, err := NewValidator(&config.ValidationConfig{TopologyFile: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(post int) error {
+		return v.Check(models.SensorData{Meta: models.MetaData{
+			Recipient: "User1",
+			Address:   1,
+			PostID:    post,
+			Timestamp: time.Now(),
+		}})
+	}
+	reason := func(err error) string {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			return verr.Reason
+		}
+		return ""
+	}
+
+	if err := check(2); reason(err) != ReasonUnknownPost {
+		t.Fatalf("post 2 before reload: %v, want %s", err, ReasonUnknownPost)
+	}
+
+	// Пост добавлен в реестр во время работы
+	writeTopology(`{"interval": "2s", "metrics": ["temperature"], "addresses": [{"address": 1, "recipient": "User1", "posts": [{"post_id": 1}, {"post_id": 2}]}]}`)
+	if err := v.ReloadPosts(); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(2); err != nil {
+		t.Fatalf("post 2 after reload: %v", err)
+	}
+	if v.Posts() != 2 {
+		t.Errorf("posts = %d, want 2", v.Posts())
+	}
+
+	// Реестр с ошибкой не применяется
+	writeTopology(`{"addresses": [`)
+	if err := v.ReloadPosts(); err == nil {
+		t.Fatal("broken registry reloaded")
+	}
+	if err := check(2); err != nil {
+		t.Errorf("post 2 after a failed reload: %v", err)
+	}
+}
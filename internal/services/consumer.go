@@ -0,0 +1,253 @@
+package services
+
+import (
+	"big_go/config"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Состояния соединения потребителя
+const (
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+	StateDisconnected = "disconnected"
+	StateStopped      = "stopped"
+)
+
+// maxConsumerEvents - сколько последних смен состояния хранится в ConsumerStats
+const maxConsumerEvents = 20
+
+// ConsumerEvent - смена состояния соединения потребителя
+type ConsumerEvent struct {
+	Time  time.Time `json:"time"`
+	State string    `json:"state"`
+	Error string    `json:"error,omitempty"`
+}
+
+// ConsumerStats - состояние и счетчики потребителя
+type ConsumerStats struct {
+	State          string          `json:"state"`
+	Since          time.Time       `json:"since"` // время перехода в текущее состояние
+	Connects       uint64          `json:"connects"`
+	Reconnects     uint64          `json:"reconnects"`      // потери установленного соединения
+	FailedConnects uint64          `json:"failed_connects"` // неудачные попытки подключения
+	Deliveries     uint64          `json:"deliveries"`
+	LastError      string          `json:"last_error,omitempty"`
+	Events         []ConsumerEvent `json:"events"` // последние смены состояния, новые в конце
+}
+
+// Session - подключение, в котором обработчик получает сообщение. Через
+// него обработчик публикует сообщения в другие очереди с подтверждением
+// брокера.
+type Session struct {
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+// Publish публикует сообщение и ждет подтверждения брокера
+func (s *Session) Publish(exchange, key string, msg amqp.Publishing) error {
+	if err := s.ch.Publish(exchange, key, false, false, msg); err != nil {
+		return err
+	}
+	c, ok := <-s.confirms
+	if !ok {
+		return errors.New("канал закрыт до подтверждения")
+	}
+	if !c.Ack {
+		return errors.New("брокер отклонил сообщение")
+	}
+	return nil
+}
+
+// Handler обрабатывает доставку и сам подтверждает или возвращает ее
+type Handler func(s *Session, d amqp.Delivery)
+
+// Consumer получает сообщения очереди с ручным подтверждением. Он следит
+// за закрытием соединения и каналов; при разрыве переподключается с
+// экспоненциальной задержкой со случайным разбросом, заново объявляет
+// топологию, настраивает prefetch и возобновляет получение. Доставки, не
+// подтвержденные до разрыва, брокер выдаст повторно.
+type Consumer struct {
+	url      string
+	topology Topology
+	queue    string
+	prefetch int
+	minWait  time.Duration
+	maxWait  time.Duration
+
+	mu    sync.Mutex
+	stats ConsumerStats
+}
+
+// NewConsumer создает потребителя очереди queue
+func NewConsumer(cfg *config.RabbitMQConfig, topology Topology, queue string, prefetch int) *Consumer {
+	c := &Consumer{
+		url:      cfg.URL(),
+		topology: topology,
+		queue:    queue,
+		prefetch: prefetch,
+		minWait:  cfg.ReconnectMin.Duration,
+		maxWait:  cfg.ReconnectMax.Duration,
+	}
+	c.setState(StateConnecting, nil)
+	return c
+}
+
+// Stats возвращает текущее состояние и счетчики
+func (c *Consumer) Stats() ConsumerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Events = append([]ConsumerEvent(nil), c.stats.Events...)
+	return stats
+}
+
+// Run получает сообщения и передает их handle, пока не отменен ctx.
+// Сообщения обрабатываются по одному в порядке получения.
+func (c *Consumer) Run(ctx context.Context, handle Handler) {
+	defer c.setState(StateStopped, nil)
+
+	retry := NewBackoff(c.minWait, c.maxWait)
+	for ctx.Err() == nil {
+		// Во время повторных попыток состояние остается disconnected
+		if c.Stats().State != StateDisconnected {
+			c.setState(StateConnecting, nil)
+		}
+		conn, session, msgs, err := c.connect()
+		if err != nil {
+			c.mu.Lock()
+			c.stats.FailedConnects++
+			c.mu.Unlock()
+			wait := retry.Next()
+			c.setState(StateDisconnected, err)
+			log.Printf("Ошибка подключения потребителя к RabbitMQ: %v; повтор через %s", err, wait)
+			if !sleepContext(ctx, wait) {
+				return
+			}
+			continue
+		}
+		retry.Reset()
+
+		c.mu.Lock()
+		c.stats.Connects++
+		c.mu.Unlock()
+		c.setState(StateConnected, nil)
+		log.Printf("Потребитель подключен к RabbitMQ, очередь %s, prefetch %d", c.queue, c.prefetch)
+
+		err = c.serve(ctx, conn, session, msgs, handle)
+		// Закрытие соединения возвращает неподтвержденные доставки в очередь
+		conn.Close()
+		if ctx.Err() != nil {
+			return
+		}
+
+		c.mu.Lock()
+		c.stats.Reconnects++
+		c.mu.Unlock()
+		c.setState(StateDisconnected, err)
+		log.Printf("Соединение потребителя с RabbitMQ потеряно: %v; переподключение", err)
+	}
+}
+
+// connect устанавливает соединение, объявляет топологию, открывает канал
+// публикации с подтверждениями и начинает получение
+func (c *Consumer) connect() (*amqp.Connection, *Session, <-chan amqp.Delivery, error) {
+	conn, err := amqp.Dial(c.url)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fail := func(format string, err error) (*amqp.Connection, *Session, <-chan amqp.Delivery, error) {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf(format, err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fail("ошибка создания канала: %v", err)
+	}
+	if err := c.topology.Declare(ch); err != nil {
+		return fail("%v", err)
+	}
+	if err := ch.Qos(c.prefetch, 0, false); err != nil {
+		return fail("ошибка настройки prefetch: %v", err)
+	}
+
+	pub, err := conn.Channel()
+	if err != nil {
+		return fail("ошибка создания канала публикации: %v", err)
+	}
+	if err := pub.Confirm(false); err != nil {
+		return fail("ошибка включения подтверждений: %v", err)
+	}
+	session := &Session{ch: pub, confirms: pub.NotifyPublish(make(chan amqp.Confirmation, 1))}
+
+	msgs, err := ch.Consume(c.queue, "", false, false, false, false, nil)
+	if err != nil {
+		return fail("ошибка регистрации потребителя: %v", err)
+	}
+	return conn, session, msgs, nil
+}
+
+// serve передает доставки обработчику, пока соединение и каналы открыты
+func (c *Consumer) serve(ctx context.Context, conn *amqp.Connection, session *Session, msgs <-chan amqp.Delivery, handle Handler) error {
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	pubClosed := session.ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-connClosed:
+			return closeError("соединение закрыто", err)
+		case err := <-pubClosed:
+			return closeError("канал публикации закрыт", err)
+		case d, ok := <-msgs:
+			// Канал доставок закрывается вместе с каналом потребителя
+			if !ok {
+				return errors.New("канал доставок закрыт")
+			}
+			c.mu.Lock()
+			c.stats.Deliveries++
+			c.mu.Unlock()
+			handle(session, d)
+		}
+	}
+}
+
+// closeError описывает закрытие соединения или канала; err == nil при
+// штатном закрытии
+func closeError(what string, err *amqp.Error) error {
+	if err == nil {
+		return errors.New(what)
+	}
+	return fmt.Errorf("%s: %v", what, err)
+}
+
+// setState записывает смену состояния
+func (c *Consumer) setState(state string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	event := ConsumerEvent{Time: time.Now(), State: state}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	// Повторная ошибка того же рода не считается новым событием
+	if state == c.stats.State && (err == nil || event.Error == c.stats.LastError) {
+		return
+	}
+	if err != nil {
+		c.stats.LastError = event.Error
+	}
+	c.stats.State, c.stats.Since = state, event.Time
+	c.stats.Events = append(c.stats.Events, event)
+	if len(c.stats.Events) > maxConsumerEvents {
+		c.stats.Events = c.stats.Events[len(c.stats.Events)-maxConsumerEvents:]
+	}
+}
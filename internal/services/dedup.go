@@ -0,0 +1,115 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// DedupStats - счетчики окна дедупликации
+type DedupStats struct {
+	Tracked    int    `json:"tracked"`    // идентификаторов в окне
+	Duplicates uint64 `json:"duplicates"` // отброшено повторов
+}
+
+// dedupEntry - идентификатор в порядке поступления
+type dedupEntry struct {
+	id   string
+	seen time.Time
+}
+
+// Deduplicator помнит идентификаторы сообщений в течение окна window, но
+// не больше capacity штук: при переполнении забываются самые старые.
+// Повтор, пришедший позже окна, будет принят заново.
+type Deduplicator struct {
+	mu       sync.Mutex
+	window   time.Duration
+	capacity int
+	seen     map[string]time.Time
+	order    []dedupEntry // по возрастанию времени; голова - order[head]
+	head     int
+	dups     uint64
+	now      func() time.Time
+}
+
+// NewDeduplicator создает окно дедупликации; capacity <= 0 - без ограничения числа
+func NewDeduplicator(window time.Duration, capacity int) *Deduplicator {
+	return &Deduplicator{
+		window:   window,
+		capacity: capacity,
+		seen:     make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+// Seen запоминает идентификатор и сообщает, встречался ли он в окне.
+// Пустой идентификатор не отслеживается.
+func (d *Deduplicator) Seen(id string) bool {
+	if id == "" {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.expire(now)
+	if _, ok := d.seen[id]; ok {
+		d.dups++
+		return true
+	}
+	d.seen[id] = now
+	d.order = append(d.order, dedupEntry{id: id, seen: now})
+	if d.capacity > 0 && len(d.seen) > d.capacity {
+		d.evict()
+	}
+	return false
+}
+
+// Forget удаляет идентификатор из окна, например если сообщение не удалось
+// доставить и его повторная копия должна быть принята
+func (d *Deduplicator) Forget(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Запись в order остается и будет пропущена при вытеснении
+	delete(d.seen, id)
+}
+
+// Stats возвращает счетчики окна
+func (d *Deduplicator) Stats() DedupStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return DedupStats{Tracked: len(d.seen), Duplicates: d.dups}
+}
+
+// expire забывает идентификаторы старше окна
+func (d *Deduplicator) expire(now time.Time) {
+	for d.head < len(d.order) && now.Sub(d.order[d.head].seen) >= d.window {
+		d.pop()
+	}
+	// Уплотняем очередь, когда пройденная часть становится большой
+	if d.head > 1024 && d.head*2 > len(d.order) {
+		d.order = append([]dedupEntry(nil), d.order[d.head:]...)
+		d.head = 0
+	}
+}
+
+// evict забывает самый старый идентификатор, еще находящийся в окне
+func (d *Deduplicator) evict() {
+	for d.head < len(d.order) {
+		if d.pop() {
+			return
+		}
+	}
+}
+
+// pop снимает голову очереди и сообщает, был ли ее идентификатор в окне
+func (d *Deduplicator) pop() bool {
+	e := d.order[d.head]
+	d.order[d.head] = dedupEntry{}
+	d.head++
+	// Идентификатор мог быть забыт (Forget) и добавлен заново позже
+	if seen, ok := d.seen[e.id]; ok && seen.Equal(e.seen) {
+		delete(d.seen, e.id)
+		return true
+	}
+	return false
+}
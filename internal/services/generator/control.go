@@ -0,0 +1,273 @@
+package generator
+
+import (
+	"big_go/config"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Controller - HTTP API управления работающим генератором: пауза,
+// темп и расписание постов, добавление и удаление постов, разовые
+// показания, сценарии неисправностей и счетчики
+type Controller struct {
+	sched     *Scheduler
+	scenarios map[string][]config.ScenarioStep
+	extra     map[string]func() interface{}
+}
+
+// NewController создает API управления планировщиком. Кроме сценариев из
+// конфигурации доступны встроенные - по одному на каждый вид неисправности.
+func NewController(s *Scheduler, cfg *config.GeneratorConfig) *Controller {
+	c := &Controller{
+		sched:     s,
+		scenarios: make(map[string][]config.ScenarioStep),
+		extra:     make(map[string]func() interface{}),
+	}
+
+	for _, fault := range Faults {
+		count := 1
+		switch fault {
+		case FaultStuck:
+			count = cfg.Faults.StuckFor
+		case FaultDropout:
+			count = cfg.Faults.DropoutFor
+		}
+		c.scenarios[fault] = []config.ScenarioStep{{Fault: fault, Count: count}}
+	}
+	for name, steps := range cfg.Scenarios {
+		c.scenarios[name] = steps
+	}
+
+	return c
+}
+
+// AddStatus добавляет в ответ /status раздел name, например счетчики публикатора
+func (c *Controller) AddStatus(name string, fn func() interface{}) {
+	c.extra[name] = fn
+}
+
+// Register регистрирует маршруты API
+func (c *Controller) Register(r gin.IRouter) {
+	r.GET("/status", c.status)
+	r.POST("/pause", c.pause)
+	r.POST("/resume", c.resume)
+	r.PUT("/rate", c.setRate)
+
+	r.GET("/posts", c.listPosts)
+	r.POST("/posts", c.addPost)
+	r.PUT("/posts/:address/:post", c.setSchedule)
+	r.DELETE("/posts/:address/:post", c.removePost)
+	r.POST("/posts/:address/:post/trigger", c.trigger)
+
+	r.GET("/scenarios", c.listScenarios)
+	r.POST("/scenarios/:name", c.inject)
+}
+
+// status возвращает состояние и счетчики генератора
+func (c *Controller) status(ctx *gin.Context) {
+	status := gin.H{
+		"paused": c.sched.Paused(),
+		"target": c.sched.Target(),
+		"scale":  c.sched.Scale(),
+		"posts":  len(c.sched.gen.Posts()),
+		"stats":  c.sched.Stats(),
+	}
+	for name, fn := range c.extra {
+		status[name] = fn()
+	}
+	ctx.JSON(http.StatusOK, status)
+}
+
+func (c *Controller) pause(ctx *gin.Context) {
+	c.sched.Pause()
+	log.Println("Генерация приостановлена")
+	ctx.JSON(http.StatusOK, gin.H{"status": "paused"})
+}
+
+func (c *Controller) resume(ctx *gin.Context) {
+	c.sched.Resume()
+	log.Println("Генерация возобновлена")
+	ctx.JSON(http.StatusOK, gin.H{"status": "running"})
+}
+
+// setRate меняет целевой темп: {"target": 500}; 0 - ритм постов из топологии
+func (c *Controller) setRate(ctx *gin.Context) {
+	var req struct {
+		Target *float64 `json:"target"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Target == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "не задан target"})
+		return
+	}
+	if err := c.sched.SetTarget(*req.Target); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Целевой темп изменен: %.1f сообщений/с", *req.Target)
+	ctx.JSON(http.StatusOK, gin.H{"target": *req.Target, "scale": c.sched.Scale()})
+}
+
+func (c *Controller) listPosts(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, c.sched.Posts())
+}
+
+// addPost добавляет пост; незаданные параметры наследуются из топологии
+func (c *Controller) addPost(ctx *gin.Context) {
+	var req struct {
+		Address int `json:"address"`
+		config.PostTopology
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	post, err := c.sched.AddPost(req.Address, req.PostTopology)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Добавлен пост %d адреса %d", post.PostID, post.Address)
+	ctx.JSON(http.StatusCreated, post.Key())
+}
+
+// setSchedule меняет интервал и разброс поста: {"interval": "2s", "jitter": "500ms"}
+func (c *Controller) setSchedule(ctx *gin.Context) {
+	key, ok := postKey(ctx)
+	if !ok {
+		return
+	}
+	var req struct {
+		Interval config.Duration  `json:"interval"`
+		Jitter   *config.Duration `json:"jitter"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	p := c.sched.gen.Post(key)
+	if p == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrNoPost.Error()})
+		return
+	}
+	interval, jitter := p.Schedule()
+	if req.Interval.Duration != 0 {
+		interval = req.Interval.Duration
+	}
+	if req.Jitter != nil {
+		jitter = req.Jitter.Duration
+	}
+
+	if err := c.sched.SetSchedule(key, interval, jitter); err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Расписание поста %d адреса %d: интервал %s, разброс %s", key.PostID, key.Address, interval, jitter)
+	ctx.JSON(http.StatusOK, gin.H{"interval": config.NewDuration(interval), "jitter": config.NewDuration(jitter)})
+}
+
+func (c *Controller) removePost(ctx *gin.Context) {
+	key, ok := postKey(ctx)
+	if !ok {
+		return
+	}
+	if err := c.sched.RemovePost(key); err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Удален пост %d адреса %d", key.PostID, key.Address)
+	ctx.JSON(http.StatusOK, gin.H{"status": "removed"})
+}
+
+// trigger запрашивает у поста разовое показание
+func (c *Controller) trigger(ctx *gin.Context) {
+	key, ok := postKey(ctx)
+	if !ok {
+		return
+	}
+	if err := c.sched.Trigger(key); err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, gin.H{"status": "triggered"})
+}
+
+func (c *Controller) listScenarios(ctx *gin.Context) {
+	names := make([]string, 0, len(c.scenarios))
+	for name := range c.scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	scenarios := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		scenarios = append(scenarios, gin.H{"name": name, "steps": c.scenarios[name]})
+	}
+	ctx.JSON(http.StatusOK, scenarios)
+}
+
+// inject вносит сценарий в посты из тела {"posts": [{"address": 1, "post_id": 2}]};
+// без тела или с пустым списком - во все посты
+func (c *Controller) inject(ctx *gin.Context) {
+	name := ctx.Param("name")
+	steps, ok := c.scenarios[name]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("неизвестный сценарий %q", name)})
+		return
+	}
+
+	var req struct {
+		Posts []PostKey `json:"posts"`
+	}
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	count, err := c.sched.Inject(steps, req.Posts)
+	if err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error(), "posts": count})
+		return
+	}
+	log.Printf("Сценарий %s внесен в %d постов", name, count)
+	ctx.JSON(http.StatusAccepted, gin.H{"scenario": name, "posts": count})
+}
+
+// postKey разбирает адрес и номер поста из пути запроса
+func postKey(ctx *gin.Context) (PostKey, bool) {
+	address, err := strconv.Atoi(ctx.Param("address"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "некорректный адрес"})
+		return PostKey{}, false
+	}
+	post, err := strconv.Atoi(ctx.Param("post"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "некорректный номер поста"})
+		return PostKey{}, false
+	}
+	return PostKey{Address: address, PostID: post}, true
+}
+
+// errorStatus подбирает HTTP-статус для ошибки планировщика
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNoPost):
+		return http.StatusNotFound
+	case errors.Is(err, ErrPostBusy):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,251 @@
+package generator
+
+import (
+	"big_go/config"
+	"big_go/internal/codec"
+	"big_go/internal/models"
+	"strings"
+)
+
+// Виды неисправностей, которые вносит генератор
+const (
+	FaultSpike        = "spike"         // выброс значения одной метрики
+	FaultStuck        = "stuck"         // значения поста "залипают" на несколько показаний
+	FaultDropout      = "dropout"       // пост пропускает несколько показаний
+	FaultDuplicate    = "duplicate"     // сообщение публикуется повторно
+	FaultOutOfOrder   = "out_of_order"  // метка времени старше предыдущего показания
+	FaultFuture       = "future"        // метка времени из будущего
+	FaultMissingField = "missing_field" // в теле сообщения отсутствует поле
+	FaultMalformed    = "malformed"     // тело сообщения не является корректным JSON
+)
+
+// Faults - все виды неисправностей в порядке их внесения
+var Faults = []string{
+	FaultDropout, FaultStuck, FaultSpike, FaultOutOfOrder, FaultFuture,
+	FaultMissingField, FaultMalformed, FaultDuplicate,
+}
+
+// KnownFault сообщает, является ли fault известным видом неисправности
+func KnownFault(fault string) bool {
+	for _, f := range Faults {
+		if f == fault {
+			return true
+		}
+	}
+	return false
+}
+
+// Заголовки AMQP, которыми помечаются внесенные неисправности.
+// HeaderFaults содержит список через запятую, HeaderFaultPrefix+<вид> -
+// подробности (метрика, сдвиг времени, удаленное поле).
+const (
+	HeaderFaults      = "x-faults"
+	HeaderFaultPrefix = "x-fault-"
+)
+
+// Message представляет сообщение, готовое к публикации
+type Message struct {
+	Data        models.SensorData
+	ID          string // идентификатор показания, публикуется как message-id AMQP
+	Body        []byte
+	ContentType string
+	Headers     map[string]interface{}
+}
+
+// NewMessage кодирует показание кодеком c в сообщение без неисправностей
+func NewMessage(data models.SensorData, c codec.Codec) (Message, error) {
+	body, err := c.Encode(data)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{Data: data, ID: data.ID, Body: body, ContentType: c.ContentType(), Headers: map[string]interface{}{}}, nil
+}
+
+// Faults возвращает список неисправностей, внесенных в сообщение
+func (m Message) Faults() []string {
+	faults, _ := m.Headers[HeaderFaults].(string)
+	if faults == "" {
+		return nil
+	}
+	return strings.Split(faults, ",")
+}
+
+// tag помечает сообщение неисправностью
+func (m *Message) tag(fault, detail string) {
+	if m.Headers == nil {
+		m.Headers = map[string]interface{}{}
+	}
+	faults, _ := m.Headers[HeaderFaults].(string)
+	if faults != "" {
+		faults += ","
+	}
+	m.Headers[HeaderFaults] = faults + fault
+	if detail != "" {
+		m.Headers[HeaderFaultPrefix+fault] = detail
+	}
+}
+
+// faultState - состояние долгих неисправностей поста
+type faultState struct {
+	stuckLeft   int
+	stuckData   models.DataPoint
+	dropoutLeft int
+	forced      map[string]int // неисправности сценариев: вид -> число показаний
+}
+
+// take возвращает true, если сценарий требует внести неисправность в
+// текущее показание, и уменьшает остаток
+func (st *faultState) take(fault string) bool {
+	if st.forced[fault] <= 0 {
+		return false
+	}
+	st.forced[fault]--
+	return true
+}
+
+// takeAll забирает весь остаток долгой неисправности сценария
+func (st *faultState) takeAll(fault string) int {
+	n := st.forced[fault]
+	delete(st.forced, fault)
+	return n
+}
+
+// Injector вносит неисправности в показания с заданными вероятностями
+// (если cfg.Enabled) и по сценариям, назначенным посту через Generator.Force.
+// Случайные решения и состояние долгих неисправностей принадлежат посту,
+// поэтому при заданном зерне неисправности воспроизводятся, а посты
+// могут обрабатываться параллельно.
+type Injector struct {
+	cfg config.FaultConfig
+}
+
+// NewInjector создает инжектор неисправностей
+func NewInjector(cfg config.FaultConfig) *Injector {
+	return &Injector{cfg: cfg}
+}
+
+// chance возвращает true с вероятностью prob; без включенных случайных
+// неисправностей случайность поста не расходуется
+func (in *Injector) chance(p *Post, prob float64) bool {
+	return in.cfg.Enabled && prob > 0 && p.rand.Float64() < prob
+}
+
+// hit решает, вносить ли неисправность fault в текущее показание
+func (in *Injector) hit(p *Post, fault string, prob float64) bool {
+	// Случайное решение принимается всегда, чтобы сценарий не сдвигал
+	// последовательность случайных чисел поста
+	random := in.chance(p, prob)
+	return p.faults.take(fault) || random
+}
+
+// Apply вносит неисправности в показание поста и возвращает сообщения для
+// публикации: ни одного при пропуске, два при дублировании
+func (in *Injector) Apply(p *Post, data models.SensorData, c codec.Codec) ([]Message, error) {
+	st := &p.faults
+
+	// Пропуск показаний
+	if st.dropoutLeft > 0 {
+		st.dropoutLeft--
+		return nil, nil
+	}
+	if n := st.takeAll(FaultDropout); n > 0 {
+		st.dropoutLeft = n - 1
+		return nil, nil
+	}
+	if in.chance(p, in.cfg.Dropout) {
+		st.dropoutLeft = in.cfg.DropoutFor - 1
+		return nil, nil
+	}
+
+	// Метки неисправностей накапливаются до сериализации показания
+	msg := Message{Headers: map[string]interface{}{}}
+
+	// Залипание значений
+	if st.stuckLeft > 0 {
+		st.stuckLeft--
+		data.Data = st.stuckData.Clone()
+		msg.tag(FaultStuck, "")
+	} else if n := st.takeAll(FaultStuck); n > 0 {
+		st.stuckLeft = n - 1
+		st.stuckData = data.Data.Clone()
+		msg.tag(FaultStuck, "")
+	} else if in.chance(p, in.cfg.Stuck) {
+		st.stuckLeft = in.cfg.StuckFor - 1
+		st.stuckData = data.Data.Clone()
+		msg.tag(FaultStuck, "")
+	}
+
+	// Выброс значения
+	if in.hit(p, FaultSpike, in.cfg.Spike) && len(p.Metrics) > 0 {
+		metric := p.Metrics[p.rand.Intn(len(p.Metrics))]
+		if m, ok := data.Data[metric]; ok {
+			m.Value *= in.cfg.SpikeFactor
+			data.Data[metric] = m
+			msg.tag(FaultSpike, metric)
+		}
+	}
+
+	// Искажение меток времени
+	if in.hit(p, FaultOutOfOrder, in.cfg.OutOfOrder) {
+		interval, _ := p.Schedule()
+		shift := -in.cfg.OutOfOrderShift.Duration - interval
+		data.Meta.Timestamp = data.Meta.Timestamp.Add(shift)
+		msg.tag(FaultOutOfOrder, shift.String())
+	}
+	if in.hit(p, FaultFuture, in.cfg.Future) {
+		data.Meta.Timestamp = data.Meta.Timestamp.Add(in.cfg.FutureShift.Duration)
+		msg.tag(FaultFuture, in.cfg.FutureShift.Duration.String())
+	}
+
+	body, err := c.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+	msg.Data, msg.ID, msg.Body, msg.ContentType = data, data.ID, body, c.ContentType()
+
+	// Искажение тела сообщения
+	if in.hit(p, FaultMissingField, in.cfg.MissingField) {
+		field, body, err := in.dropField(data, p, c)
+		if err != nil {
+			return nil, err
+		}
+		msg.Body = body
+		msg.tag(FaultMissingField, field)
+	}
+	if in.hit(p, FaultMalformed, in.cfg.Malformed) {
+		msg.Body = msg.Body[:len(msg.Body)/2]
+		msg.tag(FaultMalformed, "truncated")
+	}
+
+	messages := []Message{msg}
+
+	// Повторная публикация с тем же идентификатором
+	if in.hit(p, FaultDuplicate, in.cfg.Duplicate) {
+		dup := Message{Data: msg.Data, ID: msg.ID, Body: msg.Body, ContentType: msg.ContentType, Headers: make(map[string]interface{}, len(msg.Headers)+1)}
+		for k, v := range msg.Headers {
+			dup.Headers[k] = v
+		}
+		dup.tag(FaultDuplicate, "")
+		messages = append(messages, dup)
+	}
+
+	return messages, nil
+}
+
+// dropField кодирует показание без случайного поля метаданных или метрики
+func (in *Injector) dropField(data models.SensorData, p *Post, c codec.Codec) (string, []byte, error) {
+	fields := []string{"meta.recipient", "meta.post_id", "meta.address", "meta.timestamp"}
+	for _, metric := range p.Metrics {
+		fields = append(fields, "data."+metric)
+	}
+	field := fields[p.rand.Intn(len(fields))]
+
+	doc := codec.Document(data)
+	parts := strings.SplitN(field, ".", 2)
+	if section, ok := doc[parts[0]].(map[string]interface{}); ok {
+		delete(section, parts[1])
+	}
+
+	body, err := c.Encode(doc)
+	return field, body, err
+}
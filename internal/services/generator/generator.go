@@ -1,58 +1,406 @@
 package generator
 
 import (
+	"big_go/config"
+	"big_go/internal/codec"
 	"big_go/internal/models"
+	"fmt"
+	"hash/fnv"
 	"math/rand"
+	"sync"
 	"time"
 )
 
-const post_numbers = 4
-const address_numbers = 10
-const recipient_numbers = 2
+// Post представляет один пост топологии и его расписание
+type Post struct {
+	Address   int
+	PostID    int
+	Recipient string
+	Metrics   []string
+
+	mu       sync.Mutex // защищает расписание, которое меняется через API управления
+	interval time.Duration
+	jitter   time.Duration
+
+	rand    *rand.Rand        // собственный источник случайности поста
+	ids     *rand.Rand        // случайная часть идентификаторов показаний
+	sched   *rand.Rand        // разброс интервалов
+	signals map[string]Signal // модели сигналов по метрикам
+	units   map[string]string // единицы измерения метрик
+	order   []string          // порядок расчета: сначала независимые метрики
+	faults  faultState        // состояние долгих неисправностей
+}
+
+// Options задает воспроизводимость генерации
+type Options struct {
+	Seed      int64     // зерно генерации; 0 - выбрать случайно
+	StartTime time.Time // начало виртуальных часов; нулевое - текущее время
+}
+
+// PostKey идентифицирует пост: номер поста уникален в пределах адреса
+type PostKey struct {
+	Address int `json:"address"`
+	PostID  int `json:"post_id"`
+}
 
 // Generator представляет генератор данных
 type Generator struct {
-	rand *rand.Rand
+	seed     int64
+	epoch    time.Time // начало генерации по виртуальным часам
+	topology *config.Topology
+	faults   *Injector   // nil, если неисправности не вносятся
+	codec    codec.Codec // формат тела сообщений
+
+	mu    sync.RWMutex // защищает состав постов
+	posts []*Post
+}
+
+// NewGenerator создает новый экземпляр генератора для заданной топологии.
+// При одинаковых Seed и StartTime посты топологии выдают одни и те же
+// показания, включая метки времени и идентификаторы: виртуальное время
+// показания - StartTime плюс смещение по расписанию поста, оно не зависит
+// от того, когда измерение выполнено на самом деле.
+func NewGenerator(topology *config.Topology, opts Options) (*Generator, error) {
+	now := time.Now()
+	g := &Generator{
+		seed:     opts.Seed,
+		epoch:    opts.StartTime,
+		topology: topology,
+		codec:    codec.Default(),
+	}
+	if g.seed == 0 {
+		g.seed = now.UnixNano()
+	}
+	if g.epoch.IsZero() {
+		g.epoch = now
+	}
+
+	for _, a := range topology.Addresses {
+		for _, p := range a.Posts {
+			post, err := g.newPost(a.Address, p)
+			if err != nil {
+				return nil, err
+			}
+			g.posts = append(g.posts, post)
+		}
+	}
+
+	if len(g.posts) == 0 {
+		return nil, fmt.Errorf("топология не содержит постов")
+	}
+
+	return g, nil
+}
+
+// newPost создает пост по его описанию в топологии
+func (g *Generator) newPost(address int, p config.PostTopology) (*Post, error) {
+	post := &Post{
+		Address:   address,
+		PostID:    p.PostID,
+		Recipient: p.Recipient,
+		Metrics:   p.Metrics,
+		interval:  p.Interval.Duration,
+		jitter:    p.Jitter.Duration,
+		rand:      rand.New(rand.NewSource(postSeed(g.seed, address, p.PostID))),
+		// Отдельные источники, чтобы идентификаторы и пересчеты расписания
+		// (команды управления, смена темпа) не сдвигали значения показаний
+		ids:   rand.New(rand.NewSource(postSeed(g.seed, address, p.PostID) ^ idSeedSalt)),
+		sched: rand.New(rand.NewSource(postSeed(g.seed, address, p.PostID) ^ schedSeedSalt)),
+	}
+	if err := post.initSignals(p.Signals); err != nil {
+		return nil, fmt.Errorf("адрес %d, пост %d: %v", address, p.PostID, err)
+	}
+	return post, nil
+}
+
+// AddPost добавляет пост во время работы. Незаданные параметры
+// наследуются от адреса топологии (если он есть) и от топологии в целом.
+func (g *Generator) AddPost(address int, p config.PostTopology) (*Post, error) {
+	if p.PostID <= 0 {
+		return nil, fmt.Errorf("номер поста должен быть положительным")
+	}
+
+	// Наследование: топология -> адрес -> пост, как при загрузке топологии
+	parent := config.AddressTopology{
+		Address:  address,
+		Interval: g.topology.Interval,
+		Jitter:   g.topology.Jitter,
+		Metrics:  g.topology.Metrics,
+		Signals:  g.topology.Signals,
+	}
+	for _, a := range g.topology.Addresses {
+		if a.Address == address {
+			parent = a
+			break
+		}
+	}
+	if p.Recipient == "" {
+		p.Recipient = parent.Recipient
+	}
+	if p.Recipient == "" {
+		return nil, fmt.Errorf("не задан получатель поста")
+	}
+	if p.Interval.Duration == 0 {
+		p.Interval = parent.Interval
+	}
+	if p.Interval.Duration <= 0 {
+		return nil, fmt.Errorf("интервал поста должен быть положительным")
+	}
+	if p.Jitter.Duration == 0 {
+		p.Jitter = parent.Jitter
+	}
+	if len(p.Metrics) == 0 {
+		p.Metrics = parent.Metrics
+	}
+	signals := make(map[string]config.SignalConfig, len(parent.Signals)+len(p.Signals))
+	for metric, cfg := range parent.Signals {
+		signals[metric] = cfg
+	}
+	for metric, cfg := range p.Signals {
+		signals[metric] = cfg
+	}
+	p.Signals = signals
+
+	post, err := g.newPost(address, p)
+	if err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, existing := range g.posts {
+		if existing.Key() == post.Key() {
+			return nil, fmt.Errorf("пост %d адреса %d уже существует", p.PostID, address)
+		}
+	}
+	g.posts = append(g.posts, post)
+	return post, nil
+}
+
+// RemovePost удаляет пост; возвращает false, если поста нет
+func (g *Generator) RemovePost(key PostKey) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, p := range g.posts {
+		if p.Key() == key {
+			g.posts = append(g.posts[:i:i], g.posts[i+1:]...)
+			return true
+		}
+	}
+	return false
 }
 
-// NewGenerator создает новый экземпляр генератора
-func NewGenerator() *Generator {
-	return &Generator{
-		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+// Post возвращает пост по ключу или nil
+func (g *Generator) Post(key PostKey) *Post {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, p := range g.posts {
+		if p.Key() == key {
+			return p
+		}
 	}
+	return nil
 }
 
-// GenerateData генерирует случайные данные датчиков
-func (g *Generator) GenerateData() models.SensorData {
-	// Определяем получателя (User1 или User2)
-	recipient := "User1"
-	if g.rand.Intn(recipient_numbers) == 1 {
-		recipient = "User2"
+// initSignals создает модели сигналов поста и определяет порядок их расчета
+func (p *Post) initSignals(configs map[string]config.SignalConfig) error {
+	p.signals = make(map[string]Signal, len(p.Metrics))
+	p.units = make(map[string]string, len(p.Metrics))
+
+	var dependent []string
+	for _, metric := range p.Metrics {
+		cfg, ok := configs[metric]
+		if !ok {
+			if cfg, ok = defaultSignals[metric]; !ok {
+				return fmt.Errorf("для метрики %q не задана модель сигнала", metric)
+			}
+		}
+		p.units[metric] = cfg.Unit
+		if cfg.Unit == "" {
+			p.units[metric] = models.MetricUnits[metric]
+		}
+		if cfg.Model == ModelCorrelated && cfg.Source != "" && !containsMetric(p.Metrics, cfg.Source) {
+			return fmt.Errorf("метрика %s: источник %s не измеряется постом", metric, cfg.Source)
+		}
+
+		signal, err := NewSignal(cfg)
+		if err != nil {
+			return fmt.Errorf("метрика %s: %v", metric, err)
+		}
+		p.signals[metric] = signal
+
+		if cfg.Model == ModelCorrelated {
+			src, ok := configs[cfg.Source]
+			if !ok {
+				src = defaultSignals[cfg.Source]
+			}
+			if src.Model == ModelCorrelated {
+				return fmt.Errorf("метрика %s: источник %s сам является коррелированным", metric, cfg.Source)
+			}
+			dependent = append(dependent, metric)
+		} else {
+			p.order = append(p.order, metric)
+		}
+	}
+	p.order = append(p.order, dependent...)
+
+	return nil
+}
+
+// idSeedSalt и schedSeedSalt отличают зерна идентификаторов и расписания
+// поста от зерна его показаний
+const (
+	idSeedSalt    = 0x5bd1e995
+	schedSeedSalt = 0x27d4eb2f
+)
+
+// postSeed выводит зерно поста из общего зерна, чтобы последовательность
+// показаний поста не зависела от порядка и числа остальных постов
+func postSeed(seed int64, address, postID int) int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%d/%d", seed, address, postID)
+	return int64(h.Sum64())
+}
+
+// Seed возвращает зерно генерации; с ним запуск можно повторить
+func (g *Generator) Seed() int64 {
+	return g.seed
+}
+
+// Posts возвращает текущие посты генератора
+func (g *Generator) Posts() []*Post {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return append([]*Post(nil), g.posts...)
+}
+
+// Key возвращает ключ поста
+func (p *Post) Key() PostKey {
+	return PostKey{Address: p.Address, PostID: p.PostID}
+}
+
+// Schedule возвращает интервал измерений поста и его разброс
+func (p *Post) Schedule() (interval, jitter time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.interval, p.jitter
+}
+
+// SetSchedule меняет интервал измерений поста и его разброс
+func (p *Post) SetSchedule(interval, jitter time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("интервал должен быть положительным")
+	}
+	if jitter < 0 {
+		return fmt.Errorf("разброс не может быть отрицательным")
 	}
+	p.mu.Lock()
+	p.interval, p.jitter = interval, jitter
+	p.mu.Unlock()
+	return nil
+}
 
-	// Генерируем случайный номер поста (1-10)
-	postID := g.rand.Intn(post_numbers) + 1
+// delay возвращает интервал до следующего измерения поста с учетом разброса
+func (p *Post) delay() time.Duration {
+	interval, jitter := p.Schedule()
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(p.sched.Int63n(int64(jitter)+1))
+}
 
-	// Генерируем случайный адрес
-	address := g.rand.Intn(address_numbers) + 1
+// clock возвращает виртуальное время на смещении offset от начала генерации
+func (g *Generator) clock(offset time.Duration) time.Time {
+	return g.epoch.Add(offset)
+}
 
+// GenerateData генерирует данные датчиков поста по его моделям сигналов
+// для момента at по виртуальным часам. Показание содержит только метрики,
+// которые сообщает пост.
+func (g *Generator) GenerateData(p *Post, at time.Time) models.SensorData {
 	// Создаем метаданные
 	meta := models.MetaData{
-		Recipient: recipient,
-		PostID:    postID,
-		Address:   address,
-		Timestamp: time.Now(),
+		Recipient: p.Recipient,
+		PostID:    p.PostID,
+		Address:   p.Address,
+		Timestamp: at,
 	}
 
-	// Генерируем данные измерений
-	data := models.DataPoint{
-		Temperature: 22.0 + g.rand.Float64()*3.0,   // от 20 до +25 градусов
-		Pressure:    740.0 + g.rand.Float64()*40.0, // от 740 до 780 мм.рт.ст.
-		Humidity:    40.0 + g.rand.Float64()*40.0,  // от 40 до 80%
+	// Рассчитываем метрики; коррелированные - после своих источников
+	values := make(map[string]float64, len(p.order))
+	for _, metric := range p.order {
+		values[metric] = p.signals[metric].Next(at, p.rand, values)
 	}
 
+	data := make(models.DataPoint, len(values))
+	for metric, value := range values {
+		data[metric] = models.Metric{Value: value, Unit: p.units[metric]}
+	}
+
+	// rand.Rand не возвращает ошибок чтения
+	id, _ := models.NewID(at, p.ids)
+
 	return models.SensorData{
-		Meta: meta,
-		Data: data,
+		SchemaVersion: models.SchemaVersion,
+		ID:            id,
+		Meta:          meta,
+		Data:          data,
+	}
+}
+
+// ConfigureFaults задает параметры неисправностей. Случайные неисправности
+// вносятся с заданными вероятностями, только если cfg.Enabled; сценарии
+// (Force) используют параметры cfg в любом случае.
+func (g *Generator) ConfigureFaults(cfg config.FaultConfig) {
+	g.faults = NewInjector(cfg)
+}
+
+// Force вносит неисправность fault в count ближайших показаний поста.
+// Вызывается из горутины поста (см. Scheduler.Inject).
+func (g *Generator) Force(p *Post, fault string, count int) error {
+	if g.faults == nil {
+		return fmt.Errorf("параметры неисправностей не заданы")
+	}
+	if !KnownFault(fault) {
+		return fmt.Errorf("неизвестная неисправность %q", fault)
+	}
+	if count < 1 {
+		count = 1
+	}
+	if p.faults.forced == nil {
+		p.faults.forced = make(map[string]int)
+	}
+	p.faults.forced[fault] += count
+	return nil
+}
+
+// Emit генерирует показание поста для момента at по виртуальным часам и
+// возвращает сообщения
+// для публикации. С включенными неисправностями сообщений может быть
+// ноль или несколько.
+func (g *Generator) Emit(p *Post, at time.Time) ([]Message, error) {
+	data := g.GenerateData(p, at)
+	if g.faults == nil {
+		msg, err := NewMessage(data, g.codec)
+		if err != nil {
+			return nil, err
+		}
+		return []Message{msg}, nil
+	}
+	return g.faults.Apply(p, data, g.codec)
+}
+
+// SetCodec задает формат тела сообщений (по умолчанию JSON)
+func (g *Generator) SetCodec(c codec.Codec) {
+	g.codec = c
+}
+
+// containsMetric проверяет, входит ли метрика в список
+func containsMetric(metrics []string, metric string) bool {
+	for _, m := range metrics {
+		if m == metric {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,170 @@
+package generator
+
+import (
+	"big_go/config"
+	"big_go/internal/codec"
+	"big_go/internal/models"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Record - одна строка записи опубликованного потока (JSONL)
+type Record struct {
+	Offset      config.Duration        `json:"offset"` // время от начала записи
+	ContentType string                 `json:"content_type"`
+	MessageID   string                 `json:"message_id,omitempty"`
+	Headers     map[string]interface{} `json:"headers,omitempty"`
+	Body        json.RawMessage        `json:"body,omitempty"`        // корректный JSON сохраняется как есть
+	BodyRaw     []byte                 `json:"body_base64,omitempty"` // двоичное или искаженное тело - в base64
+}
+
+// Message возвращает сообщение записи
+func (r Record) Message() Message {
+	msg := Message{ID: r.MessageID, Headers: r.Headers, Body: r.BodyRaw, ContentType: r.ContentType}
+	if len(r.Body) > 0 {
+		msg.Body = r.Body
+	}
+	if msg.Headers == nil {
+		msg.Headers = map[string]interface{}{}
+	}
+	return msg
+}
+
+// Recorder записывает опубликованные сообщения в JSONL с относительным временем
+type Recorder struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	start time.Time
+}
+
+// NewRecorder создает запись; время отсчитывается от момента создания
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		w:     bufio.NewWriter(w),
+		start: time.Now(),
+	}
+}
+
+// Write добавляет в запись сообщение, опубликованное в момент at
+func (r *Recorder) Write(msg Message, at time.Time) error {
+	rec := Record{
+		Offset:      config.NewDuration(at.Sub(r.start)),
+		ContentType: msg.ContentType,
+		MessageID:   msg.ID,
+		Headers:     msg.Headers,
+	}
+	if json.Valid(msg.Body) {
+		rec.Body = msg.Body
+	} else {
+		rec.BodyRaw = msg.Body
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации записи: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	// Сбрасываем буфер сразу, чтобы запись не терялась при аварийной остановке
+	return r.w.Flush()
+}
+
+// IDRenewer назначает сообщениям повторной публикации новые
+// идентификаторы, чтобы коллектор не отбросил их как повторы уже
+// принятых. Копии одного показания в записи (неисправность duplicate)
+// получают одинаковый новый идентификатор и остаются повторами.
+type IDRenewer struct {
+	ids map[string]string // прежний идентификатор -> новый
+}
+
+// NewIDRenewer создает замену идентификаторов
+func NewIDRenewer() *IDRenewer {
+	return &IDRenewer{ids: make(map[string]string)}
+}
+
+// Renew заменяет идентификатор сообщения в message-id и в теле. Тело,
+// которое не разбирается (неисправность malformed), не меняется.
+func (r *IDRenewer) Renew(msg Message) (Message, error) {
+	if msg.ID == "" {
+		return msg, nil
+	}
+	id, ok := r.ids[msg.ID]
+	if !ok {
+		var err error
+		if id, err = models.NewID(time.Now(), rand.Reader); err != nil {
+			return msg, err
+		}
+		r.ids[msg.ID] = id
+	}
+	msg.ID = id
+
+	c, err := codec.ForContentType(msg.ContentType)
+	if err != nil {
+		return msg, nil
+	}
+	doc, err := c.DecodeDocument(msg.Body)
+	if err != nil {
+		return msg, nil
+	}
+	if _, ok := doc["id"].(string); !ok {
+		return msg, nil
+	}
+	doc["id"] = id
+	body, err := c.Encode(doc)
+	if err != nil {
+		return msg, fmt.Errorf("сообщение %s: %v", id, err)
+	}
+	msg.Body = body
+	return msg, nil
+}
+
+// Replay республикует запись. speed задает ускорение: 1 - в реальном
+// времени, N - в N раз быстрее, 0 - без пауз. publish вызывается для
+// каждого сообщения по порядку.
+func Replay(ctx context.Context, r io.Reader, speed float64, publish func(Message) error) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	start := time.Now()
+	count := 0 // опубликовано сообщений
+	line := 0  // прочитано строк, включая пустые
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return count, fmt.Errorf("строка %d: %v", line, err)
+		}
+
+		if speed > 0 {
+			due := start.Add(time.Duration(float64(rec.Offset.Duration) / speed))
+			select {
+			case <-ctx.Done():
+				return count, ctx.Err()
+			case <-time.After(time.Until(due)):
+			}
+		} else if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+
+		if err := publish(rec.Message()); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, scanner.Err()
+}
@@ -0,0 +1,26 @@
+package generator
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReplayReportsLineNumber(t *testing.T) {
+	stream := `{"offset": "0s", "content_type": "application/json", "message_id": "a", "body": {}}
+
+{"offset": "0s", "content_type": "application/json", "message_id": "b", "body": {}}
+not json
+`
+	var ids []string
+	count, err := Replay(context.Background(), strings.NewReader(stream), 0, func(msg Message) error {
+		ids = append(ids, msg.ID)
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "строка 4:") {
+		t.Errorf("Replay error = %v, want an error on line 4", err)
+	}
+	if count != 2 || len(ids) != 2 {
+		t.Errorf("published %d messages %v, want 2", count, ids)
+	}
+}
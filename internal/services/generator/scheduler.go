@@ -0,0 +1,590 @@
+package generator
+
+import (
+	"big_go/config"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Emission - результат одного измерения поста
+type Emission struct {
+	Post     *Post
+	At       time.Time // момент измерения по виртуальным часам
+	Messages []Message
+	Err      error
+}
+
+// Stats - счетчики генератора
+type Stats struct {
+	Readings uint64 `json:"readings"` // показаний по расписанию и разовых
+	Messages uint64 `json:"messages"` // сообщений после внесения неисправностей
+	Skipped  uint64 `json:"skipped"`  // показаний, пропущенных из-за неисправности
+	Errors   uint64 `json:"errors"`   // ошибок формирования сообщений
+	Sent     uint64 `json:"sent"`     // сообщений, принятых публикатором
+	Failed   uint64 `json:"failed"`   // сообщений, которые не удалось опубликовать
+}
+
+// PostInfo - состояние поста для API управления
+type PostInfo struct {
+	PostKey
+	Recipient string          `json:"recipient"`
+	Metrics   []string        `json:"metrics"`
+	Interval  config.Duration `json:"interval"`
+	Jitter    config.Duration `json:"jitter"`
+	Readings  uint64          `json:"readings"`
+}
+
+// command выполняется в горутине поста, которой принадлежат его модели
+// сигналов, случайность и состояние неисправностей
+type command struct {
+	fn         func(ctx context.Context, r *runner)
+	reschedule bool // пересчитать время следующего измерения
+}
+
+// runner - горутина поста. Расписание ведется смещениями от начала
+// генерации и принадлежит горутине поста.
+type runner struct {
+	readings uint64 // первым полем: атомарный счетчик требует выравнивания
+
+	post   *Post
+	cmds   chan command
+	cancel context.CancelFunc
+
+	last time.Duration // предыдущее измерение (или запуск поста)
+	next time.Duration // следующее измерение
+	lag  time.Duration // отставание от расписания, которое не наверстывается
+}
+
+// Scheduler запускает по горутине на каждый пост и управляет общим темпом:
+// масштабирует интервалы постов под целевой темп, ограничивает его сверху,
+// плавно разгоняется на старте и создает периодические всплески. Во время
+// работы посты можно добавлять и удалять, генерацию - приостанавливать.
+type Scheduler struct {
+	stats Stats // первым полем: атомарные счетчики требуют выравнивания
+
+	gen *Generator
+
+	mu      sync.Mutex
+	cfg     config.RateConfig
+	scale   float64 // множитель темпа постов для достижения cfg.Target
+	limiter *limiter
+	start   time.Time
+	ctx     context.Context
+	out     chan<- Emission
+	runners map[PostKey]*runner
+	wg      sync.WaitGroup
+	stopped bool
+	resume  chan struct{} // не nil, пока генерация приостановлена
+}
+
+// rampSteps - на сколько ступеней делится разгон: в пределах ступени
+// множитель темпа постоянен
+const rampSteps = 20
+
+// ErrPostBusy возвращается, если очередь команд поста заполнена
+var ErrPostBusy = errors.New("пост не успевает обрабатывать команды")
+
+// ErrNoPost возвращается для неизвестного поста
+var ErrNoPost = errors.New("пост не найден")
+
+// NewScheduler создает планировщик для генератора
+func NewScheduler(g *Generator, cfg config.RateConfig) *Scheduler {
+	s := &Scheduler{
+		gen:     g,
+		cfg:     cfg,
+		scale:   1,
+		runners: make(map[PostKey]*runner),
+	}
+	s.rescale()
+	return s
+}
+
+// rescale пересчитывает множитель темпа постов под целевой темп.
+// Вызывается под s.mu или до запуска.
+func (s *Scheduler) rescale() {
+	if s.cfg.Target <= 0 {
+		s.scale = 1
+		return
+	}
+
+	// Собственный суммарный темп постов при их текущих интервалах
+	natural := 0.0
+	for _, p := range s.gen.Posts() {
+		interval, jitter := p.Schedule()
+		natural += float64(time.Second) / float64(interval+jitter/2)
+	}
+	s.scale = 1
+	if natural > 0 {
+		s.scale = s.cfg.Target / natural
+	}
+	if s.limiter == nil {
+		s.limiter = newLimiter(s.cfg.Burst)
+	}
+}
+
+// Scale возвращает множитель темпа постов, рассчитанный для целевого темпа
+func (s *Scheduler) Scale() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scale
+}
+
+// Target возвращает целевой темп; 0 - посты работают в своем ритме
+func (s *Scheduler) Target() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg.Target
+}
+
+// SetTarget меняет целевой темп и пересчитывает расписание всех постов
+func (s *Scheduler) SetTarget(target float64) error {
+	if target < 0 {
+		return fmt.Errorf("целевой темп не может быть отрицательным")
+	}
+	s.mu.Lock()
+	s.cfg.Target = target
+	s.rescale()
+	s.mu.Unlock()
+
+	s.rescheduleAll()
+	return nil
+}
+
+// multiplier возвращает множитель темпа на смещении at от начала генерации
+// с учетом разгона и всплесков, а также сколько он остается неизменным
+// (0 - до конца работы)
+func multiplier(cfg config.RateConfig, at time.Duration) (m float64, steady time.Duration) {
+	m = 1.0
+
+	if ramp := cfg.RampUp.Duration; ramp > 0 && at < ramp {
+		step := ramp / rampSteps
+		if step <= 0 {
+			step = ramp
+		}
+		n := at / step
+		m *= cfg.RampFrom + (1-cfg.RampFrom)*float64(n*step)/float64(ramp)
+		steady = (n+1)*step - at
+	}
+
+	for _, b := range cfg.Bursts {
+		if b.Every.Duration <= 0 || b.Factor <= 0 {
+			continue
+		}
+		phase := at % b.Every.Duration
+		edge := b.Every.Duration - phase
+		if phase < b.Duration.Duration {
+			m *= b.Factor
+			edge = b.Duration.Duration - phase
+		}
+		if steady == 0 || edge < steady {
+			steady = edge
+		}
+	}
+
+	return m, steady
+}
+
+// Run запускает посты и передает их показания в out, пока не отменен ctx.
+// После остановки всех постов канал out закрывается.
+func (s *Scheduler) Run(ctx context.Context, out chan<- Emission) {
+	s.mu.Lock()
+	s.start, s.ctx, s.out = time.Now(), ctx, out
+	for _, p := range s.gen.Posts() {
+		s.startPost(p, 0)
+	}
+	s.mu.Unlock()
+
+	<-ctx.Done()
+
+	// Новые посты больше не запускаются
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	close(out)
+}
+
+// startPost запускает горутину поста, расписание которого начинается
+// со смещения from. Вызывается под s.mu.
+func (s *Scheduler) startPost(p *Post, from time.Duration) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	r := &runner{post: p, cmds: make(chan command, 16), cancel: cancel, last: from}
+	s.runners[p.Key()] = r
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.runPost(ctx, r)
+	}()
+}
+
+// runPost - цикл измерений одного поста
+func (s *Scheduler) runPost(ctx context.Context, r *runner) {
+	r.next = s.advance(r.post, r.last)
+	timer := time.NewTimer(s.until(r))
+	defer timer.Stop()
+
+	for {
+		// На паузе таймер не обслуживается, но команды выполняются
+		var tick <-chan time.Time
+		resume := s.paused()
+		if resume == nil {
+			tick = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-resume:
+			continue
+		case c := <-r.cmds:
+			if c.fn != nil {
+				c.fn(ctx, r)
+			}
+			if c.reschedule {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				r.next = s.advance(r.post, r.last)
+				timer.Reset(s.until(r))
+			}
+			continue
+		case <-tick:
+		}
+
+		s.mu.Lock()
+		lim, target, cfg := s.limiter, s.cfg.Target, s.cfg
+		s.mu.Unlock()
+		if lim != nil && target > 0 {
+			m, _ := multiplier(cfg, r.next)
+			if err := lim.Wait(ctx, target*m); err != nil {
+				return
+			}
+		}
+
+		// Виртуальное время берется из расписания, а не из реальных часов:
+		// задержки и отставание не меняют метки времени и идентификаторы
+		if !s.emit(ctx, r, s.gen.clock(r.next)) {
+			return
+		}
+
+		// Следующее измерение; накопленное отставание не наверстывается
+		r.last = r.next
+		r.next = s.advance(r.post, r.last)
+		if behind := time.Since(s.start) - (r.next + r.lag); behind > 0 {
+			r.lag += behind
+		}
+		timer.Reset(s.until(r))
+	}
+}
+
+// emit формирует показание поста и передает его в out
+func (s *Scheduler) emit(ctx context.Context, r *runner, at time.Time) bool {
+	messages, err := s.gen.Emit(r.post, at)
+
+	atomic.AddUint64(&r.readings, 1)
+	atomic.AddUint64(&s.stats.Readings, 1)
+	switch {
+	case err != nil:
+		atomic.AddUint64(&s.stats.Errors, 1)
+	case len(messages) == 0:
+		atomic.AddUint64(&s.stats.Skipped, 1)
+	default:
+		atomic.AddUint64(&s.stats.Messages, uint64(len(messages)))
+	}
+
+	select {
+	case s.out <- Emission{Post: r.post, At: at, Messages: messages, Err: err}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// advance возвращает смещение следующего измерения поста после измерения
+// на смещении from. Интервал поста (с разбросом) проходится с темпом
+// планировщика, который меняется при разгоне и всплесках, поэтому интервал,
+// начатый в начале разгона, тоже ускоряется. Разброс выбирается один раз
+// на интервал.
+func (s *Scheduler) advance(p *Post, from time.Duration) time.Duration {
+	s.mu.Lock()
+	scale, cfg := s.scale, s.cfg
+	s.mu.Unlock()
+
+	work := float64(p.delay()) / scale // остаток интервала при множителе 1
+	for {
+		m, steady := multiplier(cfg, from)
+		if steady <= 0 || float64(steady)*m >= work {
+			return from + time.Duration(work/m)
+		}
+		work -= float64(steady) * m
+		from += steady
+	}
+}
+
+// until возвращает, сколько ждать следующего измерения поста
+func (s *Scheduler) until(r *runner) time.Duration {
+	return time.Until(s.start.Add(r.next + r.lag))
+}
+
+// paused возвращает канал, который закроется при возобновлении, или nil
+func (s *Scheduler) paused() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resume
+}
+
+// Pause приостанавливает измерения по расписанию всех постов
+func (s *Scheduler) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resume == nil {
+		s.resume = make(chan struct{})
+	}
+}
+
+// Resume возобновляет измерения по расписанию
+func (s *Scheduler) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resume != nil {
+		close(s.resume)
+		s.resume = nil
+	}
+}
+
+// Paused сообщает, приостановлена ли генерация
+func (s *Scheduler) Paused() bool {
+	return s.paused() != nil
+}
+
+// Stats возвращает счетчики генератора
+func (s *Scheduler) Stats() Stats {
+	return Stats{
+		Readings: atomic.LoadUint64(&s.stats.Readings),
+		Messages: atomic.LoadUint64(&s.stats.Messages),
+		Skipped:  atomic.LoadUint64(&s.stats.Skipped),
+		Errors:   atomic.LoadUint64(&s.stats.Errors),
+		Sent:     atomic.LoadUint64(&s.stats.Sent),
+		Failed:   atomic.LoadUint64(&s.stats.Failed),
+	}
+}
+
+// Published учитывает результат публикации сообщения
+func (s *Scheduler) Published(err error) {
+	if err != nil {
+		atomic.AddUint64(&s.stats.Failed, 1)
+	} else {
+		atomic.AddUint64(&s.stats.Sent, 1)
+	}
+}
+
+// Posts возвращает состояние постов, упорядоченное по адресу и номеру
+func (s *Scheduler) Posts() []PostInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := make([]PostInfo, 0, len(s.runners))
+	for _, p := range s.gen.Posts() {
+		interval, jitter := p.Schedule()
+		info := PostInfo{
+			PostKey:   p.Key(),
+			Recipient: p.Recipient,
+			Metrics:   p.Metrics,
+			Interval:  config.NewDuration(interval),
+			Jitter:    config.NewDuration(jitter),
+		}
+		if r, ok := s.runners[p.Key()]; ok {
+			info.Readings = atomic.LoadUint64(&r.readings)
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Address != infos[j].Address {
+			return infos[i].Address < infos[j].Address
+		}
+		return infos[i].PostID < infos[j].PostID
+	})
+	return infos
+}
+
+// AddPost добавляет пост в генератор и, если планировщик работает,
+// запускает его
+func (s *Scheduler) AddPost(address int, p config.PostTopology) (*Post, error) {
+	post, err := s.gen.AddPost(address, p)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx != nil && !s.stopped {
+		s.startPost(post, time.Since(s.start))
+	}
+	s.rescale()
+	return post, nil
+}
+
+// RemovePost останавливает и удаляет пост
+func (s *Scheduler) RemovePost(key PostKey) error {
+	if !s.gen.RemovePost(key) {
+		return ErrNoPost
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.runners[key]; ok {
+		r.cancel()
+		delete(s.runners, key)
+	}
+	s.rescale()
+	return nil
+}
+
+// SetSchedule меняет интервал и разброс поста; следующее измерение
+// пересчитывается сразу
+func (s *Scheduler) SetSchedule(key PostKey, interval, jitter time.Duration) error {
+	p := s.gen.Post(key)
+	if p == nil {
+		return ErrNoPost
+	}
+	if err := p.SetSchedule(interval, jitter); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.rescale()
+	s.mu.Unlock()
+
+	s.rescheduleAll()
+	return nil
+}
+
+// Trigger формирует разовое показание поста вне расписания (в том числе на паузе)
+func (s *Scheduler) Trigger(key PostKey) error {
+	return s.send(key, command{fn: func(ctx context.Context, r *runner) {
+		s.emit(ctx, r, s.gen.clock(time.Since(s.start)-r.lag))
+	}})
+}
+
+// Inject назначает постам шаги сценария неисправностей. Пустой список
+// ключей означает все посты. Возвращает число постов, получивших сценарий.
+func (s *Scheduler) Inject(steps []config.ScenarioStep, keys []PostKey) (int, error) {
+	if s.gen.faults == nil {
+		return 0, fmt.Errorf("параметры неисправностей не заданы")
+	}
+	for _, step := range steps {
+		if !KnownFault(step.Fault) {
+			return 0, fmt.Errorf("неизвестная неисправность %q", step.Fault)
+		}
+	}
+	if len(keys) == 0 {
+		for _, p := range s.gen.Posts() {
+			keys = append(keys, p.Key())
+		}
+	}
+
+	count := 0
+	for _, key := range keys {
+		err := s.send(key, command{fn: func(_ context.Context, r *runner) {
+			for _, step := range steps {
+				if err := s.gen.Force(r.post, step.Fault, step.Count); err != nil {
+					log.Printf("Ошибка сценария для поста %d адреса %d: %v", r.post.PostID, r.post.Address, err)
+				}
+			}
+		}})
+		if err != nil {
+			return count, fmt.Errorf("адрес %d, пост %d: %w", key.Address, key.PostID, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// send передает команду горутине поста без ожидания
+func (s *Scheduler) send(key PostKey, c command) error {
+	s.mu.Lock()
+	r, ok := s.runners[key]
+	s.mu.Unlock()
+	if !ok {
+		return ErrNoPost
+	}
+
+	select {
+	case r.cmds <- c:
+		return nil
+	default:
+		return ErrPostBusy
+	}
+}
+
+// rescheduleAll пересчитывает следующее измерение всех постов
+func (s *Scheduler) rescheduleAll() {
+	s.mu.Lock()
+	keys := make([]PostKey, 0, len(s.runners))
+	for key := range s.runners {
+		keys = append(keys, key)
+	}
+	s.mu.Unlock()
+
+	for _, key := range keys {
+		// При переполненной очереди команд новое расписание применится
+		// со следующим измерением поста
+		s.send(key, command{reschedule: true})
+	}
+}
+
+// limiter ограничивает общий темп: выдает разрешения не чаще rate в секунду,
+// допуская пачку до burst сообщений после простоя
+type limiter struct {
+	mu    sync.Mutex
+	next  time.Time
+	burst int
+}
+
+func newLimiter(burst int) *limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{burst: burst}
+}
+
+// Wait ожидает разрешения на одно сообщение при темпе rate
+func (l *limiter) Wait(ctx context.Context, rate float64) error {
+	if rate <= 0 {
+		return nil
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+
+	l.mu.Lock()
+	now := time.Now()
+	if earliest := now.Add(-time.Duration(l.burst-1) * interval); l.next.Before(earliest) {
+		l.next = earliest
+	}
+	at := l.next
+	l.next = l.next.Add(interval)
+	l.mu.Unlock()
+
+	wait := time.Until(at)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,205 @@
+package generator
+
+import (
+	"big_go/config"
+	"big_go/internal/models"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Signal - модель временного ряда одной метрики поста.
+// Каждый пост владеет собственными экземплярами моделей, поэтому
+// состояние (текущее значение блуждания, начало дрейфа) не смешивается.
+type Signal interface {
+	// Next возвращает значение метрики в момент t. values содержит значения
+	// метрик поста, уже рассчитанные на этом шаге.
+	Next(t time.Time, r *rand.Rand, values map[string]float64) float64
+}
+
+// Модели сигналов
+const (
+	ModelRandomWalk = "random_walk"
+	ModelSine       = "sine"
+	ModelDrift      = "drift"
+	ModelGaussian   = "gaussian"
+	ModelCorrelated = "correlated"
+)
+
+// defaultSignals - модели метрик, если в топологии они не заданы
+var defaultSignals = map[string]config.SignalConfig{
+	// Суточный цикл от 20 до 25 градусов с максимумом в 15:00 UTC
+	models.MetricTemperature: {
+		Model:     ModelSine,
+		Base:      22.5,
+		Amplitude: 2.5,
+		Period:    config.NewDuration(24 * time.Hour),
+		Peak:      config.NewDuration(15 * time.Hour),
+		StdDev:    0.1,
+		Min:       20.0,
+		Max:       25.0,
+	},
+	// Медленное блуждание от 740 до 780 мм.рт.ст.
+	models.MetricPressure: {
+		Model: ModelRandomWalk,
+		Base:  760.0,
+		Step:  0.5,
+		Min:   740.0,
+		Max:   780.0,
+	},
+	// Влажность падает при росте температуры, от 40 до 80%
+	models.MetricHumidity: {
+		Model:     ModelCorrelated,
+		Source:    models.MetricTemperature,
+		Base:      60.0,
+		Reference: 22.5,
+		Factor:    -6.0,
+		StdDev:    1.0,
+		Min:       40.0,
+		Max:       80.0,
+	},
+	// Концентрация CO2 в помещении: блуждание от 400 до 1500 ppm
+	models.MetricCO2: {
+		Model: ModelRandomWalk,
+		Base:  600.0,
+		Step:  15.0,
+		Min:   400.0,
+		Max:   1500.0,
+	},
+	// Скорость ветра: шум вокруг 4 м/с
+	models.MetricWindSpeed: {
+		Model:  ModelGaussian,
+		Base:   4.0,
+		StdDev: 1.5,
+		Min:    0.0,
+		Max:    30.0,
+	},
+	// Напряжение батареи медленно падает на 0.01 В в час
+	models.MetricBatteryVoltage: {
+		Model:  ModelDrift,
+		Base:   3.7,
+		Rate:   -0.01,
+		StdDev: 0.005,
+		Min:    3.0,
+		Max:    4.2,
+	},
+	// Освещенность: суточный цикл с максимумом в полдень UTC
+	models.MetricIlluminance: {
+		Model:     ModelSine,
+		Base:      5000.0,
+		Amplitude: 5000.0,
+		Period:    config.NewDuration(24 * time.Hour),
+		Peak:      config.NewDuration(12 * time.Hour),
+		StdDev:    50.0,
+		Min:       0.0,
+		Max:       10000.0,
+	},
+}
+
+// NewSignal создает модель сигнала по конфигурации
+func NewSignal(cfg config.SignalConfig) (Signal, error) {
+	var s Signal
+	switch cfg.Model {
+	case ModelRandomWalk:
+		if cfg.Min >= cfg.Max {
+			return nil, fmt.Errorf("модель %s требует min < max", cfg.Model)
+		}
+		if cfg.Base < cfg.Min || cfg.Base > cfg.Max {
+			return nil, fmt.Errorf("модель %s: base %g вне границ %g..%g", cfg.Model, cfg.Base, cfg.Min, cfg.Max)
+		}
+		s = &randomWalk{value: cfg.Base, step: cfg.Step, min: cfg.Min, max: cfg.Max}
+	case ModelSine:
+		if cfg.Period.Duration <= 0 {
+			cfg.Period = config.NewDuration(24 * time.Hour)
+		}
+		s = &sine{base: cfg.Base, amplitude: cfg.Amplitude, period: cfg.Period.Duration, peak: cfg.Peak.Duration}
+	case ModelDrift:
+		s = &drift{base: cfg.Base, rate: cfg.Rate}
+	case ModelGaussian, "":
+		s = constant(cfg.Base)
+	case ModelCorrelated:
+		if cfg.Source == "" {
+			return nil, fmt.Errorf("модель %s требует source", cfg.Model)
+		}
+		s = &correlated{source: cfg.Source, base: cfg.Base, reference: cfg.Reference, factor: cfg.Factor}
+	default:
+		return nil, fmt.Errorf("неизвестная модель сигнала %q", cfg.Model)
+	}
+
+	return &shaped{signal: s, stddev: cfg.StdDev, min: cfg.Min, max: cfg.Max}, nil
+}
+
+// shaped добавляет к модели шум и ограничивает результат границами
+type shaped struct {
+	signal   Signal
+	stddev   float64
+	min, max float64
+}
+
+func (s *shaped) Next(t time.Time, r *rand.Rand, values map[string]float64) float64 {
+	v := s.signal.Next(t, r, values)
+	if s.stddev > 0 {
+		v += r.NormFloat64() * s.stddev
+	}
+	if s.min < s.max {
+		v = math.Max(s.min, math.Min(s.max, v))
+	}
+	return v
+}
+
+// randomWalk - случайное блуждание в границах min..max
+type randomWalk struct {
+	value    float64
+	step     float64
+	min, max float64
+}
+
+func (w *randomWalk) Next(t time.Time, r *rand.Rand, values map[string]float64) float64 {
+	w.value += (r.Float64()*2 - 1) * w.step
+	w.value = math.Max(w.min, math.Min(w.max, w.value))
+	return w.value
+}
+
+// sine - периодический (по умолчанию суточный) цикл
+type sine struct {
+	base, amplitude float64
+	period, peak    time.Duration
+}
+
+func (s *sine) Next(t time.Time, r *rand.Rand, values map[string]float64) float64 {
+	phase := time.Duration(t.UnixNano()%int64(s.period)) - s.peak
+	return s.base + s.amplitude*math.Cos(2*math.Pi*float64(phase)/float64(s.period))
+}
+
+// drift - линейный дрейф от момента первого измерения поста
+type drift struct {
+	base  float64
+	rate  float64 // единиц в час
+	start time.Time
+}
+
+func (d *drift) Next(t time.Time, r *rand.Rand, values map[string]float64) float64 {
+	if d.start.IsZero() {
+		d.start = t
+	}
+	return d.base + d.rate*t.Sub(d.start).Hours()
+}
+
+// constant - постоянное значение; в сочетании с шумом shaped дает
+// модель gaussian
+type constant float64
+
+func (c constant) Next(t time.Time, r *rand.Rand, values map[string]float64) float64 {
+	return float64(c)
+}
+
+// correlated - значение, линейно зависящее от другой метрики поста
+type correlated struct {
+	source                  string
+	base, reference, factor float64
+}
+
+func (c *correlated) Next(t time.Time, r *rand.Rand, values map[string]float64) float64 {
+	return c.base + c.factor*(values[c.source]-c.reference)
+}
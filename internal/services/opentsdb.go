@@ -0,0 +1,408 @@
+package services
+
+import (
+	"big_go/config"
+	"big_go/internal/models"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ErrTSDBBufferFull возвращается OpentsdbWriter.Save, когда буфер записи заполнен
+var ErrTSDBBufferFull = errors.New("буфер записи в OpenTSDB заполнен")
+
+// OpentsdbPoint - точка данных OpenTSDB
+type OpentsdbPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"` // мс
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// Line возвращает точку командой put протокола telnet
+func (p OpentsdbPoint) Line() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "put %s %d %s", p.Metric, p.Timestamp, strconv.FormatFloat(p.Value, 'f', -1, 64))
+	keys := make([]string, 0, len(p.Tags))
+	for k := range p.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%s", k, p.Tags[k])
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// OpentsdbPoints преобразует показание в точки, по одной на измерение:
+// метрика <prefix>.<имя>, теги address, post и recipient
+func OpentsdbPoints(prefix string, data models.SensorData) []OpentsdbPoint {
+	tags := map[string]string{
+		"address": strconv.Itoa(data.Meta.Address),
+		"post":    strconv.Itoa(data.Meta.PostID),
+	}
+	if data.Meta.Recipient != "" {
+		tags["recipient"] = tsdbName(data.Meta.Recipient)
+	}
+	names := data.Data.Names()
+	points := make([]OpentsdbPoint, 0, len(names))
+	for _, name := range names {
+		points = append(points, OpentsdbPoint{
+			Metric:    tsdbName(prefix + "." + name),
+			Timestamp: data.Meta.Timestamp.UnixMilli(),
+			Value:     data.Data[name].Value,
+			Tags:      tags,
+		})
+	}
+	return points
+}
+
+// OpentsdbRollupPoints переводит сводку в точки <prefix>.<метрика>.<статистика>
+// (count, min, max, avg, last) со временем начала окна и тегами window,
+// address, post (0 - сводка по адресу), recipient и, для частей окна,
+// закрытого раньше срока, part
+func OpentsdbRollupPoints(prefix string, rollup models.Rollup) []OpentsdbPoint {
+	tags := map[string]string{
+		"window":  tsdbName(rollup.Window),
+		"address": strconv.Itoa(rollup.Address),
+		"post":    strconv.Itoa(rollup.PostID),
+	}
+	if rollup.Recipient != "" {
+		tags["recipient"] = tsdbName(rollup.Recipient)
+	}
+	if rollup.Part > 0 {
+		tags["part"] = strconv.Itoa(rollup.Part)
+	}
+	names := make([]string, 0, len(rollup.Metrics))
+	for name := range rollup.Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	points := make([]OpentsdbPoint, 0, 5*len(names))
+	for _, name := range names {
+		m := rollup.Metrics[name]
+		for _, stat := range []struct {
+			name  string
+			value float64
+		}{
+			{"count", float64(m.Count)},
+			{"min", m.Min},
+			{"max", m.Max},
+			{"avg", m.Avg},
+			{"last", m.Last},
+		} {
+			points = append(points, OpentsdbPoint{
+				Metric:    tsdbName(prefix + "." + name + "." + stat.name),
+				Timestamp: rollup.Start.UnixMilli(),
+				Value:     stat.value,
+				Tags:      tags,
+			})
+		}
+	}
+	return points
+}
+
+// tsdbName заменяет символы, недопустимые в именах метрик и тегов
+// OpenTSDB, подчеркиванием
+func tsdbName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_./", r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// OpentsdbOptions - параметры пакетной записи в OpenTSDB
+type OpentsdbOptions struct {
+	BatchSize     int           // наибольший пакет точек
+	FlushInterval time.Duration // наибольшее время ожидания неполного пакета
+	Buffer        int           // сколько точек ждет записи; при переполнении новые показания отбрасываются
+	RetryMin      time.Duration // задержка повтора после ошибки OpenTSDB
+	RetryMax      time.Duration
+}
+
+// OpentsdbStats - счетчики записи в OpenTSDB
+type OpentsdbStats struct {
+	Protocol  string    `json:"protocol"`
+	Buffered  int       `json:"buffered"`
+	Written   uint64    `json:"written"`  // отправлено точек
+	Batches   uint64    `json:"batches"`  // отправлено пакетов
+	Rejected  uint64    `json:"rejected"` // точки, которые OpenTSDB отклонил как некорректные
+	Dropped   uint64    `json:"dropped"`  // отброшено точек из-за переполнения буфера
+	Failures  uint64    `json:"failures"` // неудачные попытки отправки пакета
+	LastError string    `json:"last_error,omitempty"`
+	LastWrite time.Time `json:"last_write,omitempty"`
+}
+
+// OpentsdbWriter записывает каждое измерение показаний в OpenTSDB точкой
+// данных (см. OpentsdbPoints) по HTTP (POST /api/put) или по протоколу
+// telnet (команды put). Как и repository.Writer, Save не ждет OpenTSDB:
+// точки копятся в буфере и отправляются пакетами, когда набирается пакет
+// или проходит FlushInterval. Пока OpenTSDB недоступен, пакет повторяется с
+// нарастающей задержкой. Точки, отклоненные OpenTSDB как некорректные, не
+// повторяются.
+type OpentsdbWriter struct {
+	cfg    *config.OpentsdbConfig
+	opts   OpentsdbOptions
+	client *http.Client
+	conn   net.Conn      // соединение telnet; используется только из Run
+	closed chan struct{} // закрывается readTelnet, когда соединение conn закрыто
+
+	mu    sync.Mutex
+	buf   []OpentsdbPoint
+	stats OpentsdbStats
+	wake  chan struct{}
+}
+
+// NewOpentsdbWriter создает пакетную запись в OpenTSDB, описанный cfg
+func NewOpentsdbWriter(cfg *config.OpentsdbConfig, opts OpentsdbOptions) (*OpentsdbWriter, error) {
+	if cfg.Protocol != config.OpentsdbHTTP && cfg.Protocol != config.OpentsdbTelnet {
+		return nil, fmt.Errorf("неизвестный протокол OpenTSDB %q: допустимы %s и %s",
+			cfg.Protocol, config.OpentsdbHTTP, config.OpentsdbTelnet)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	return &OpentsdbWriter{
+		cfg:    cfg,
+		opts:   opts,
+		client: &http.Client{Timeout: cfg.Timeout.Duration},
+		stats:  OpentsdbStats{Protocol: cfg.Protocol},
+		wake:   make(chan struct{}, 1),
+	}, nil
+}
+
+// Save ставит измерения показания в очередь записи
+func (w *OpentsdbWriter) Save(data models.SensorData) error {
+	return w.enqueue(OpentsdbPoints(w.cfg.MetricPrefix, data))
+}
+
+// SaveRollup ставит точки сводки в очередь записи (см. OpentsdbRollupPoints)
+func (w *OpentsdbWriter) SaveRollup(rollup models.Rollup) error {
+	return w.enqueue(OpentsdbRollupPoints(w.cfg.MetricPrefix, rollup))
+}
+
+// enqueue добавляет точки в буфер целиком или отбрасывает их
+func (w *OpentsdbWriter) enqueue(points []OpentsdbPoint) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.opts.Buffer > 0 && len(w.buf)+len(points) > w.opts.Buffer {
+		w.stats.Dropped += uint64(len(points))
+		return ErrTSDBBufferFull
+	}
+	w.buf = append(w.buf, points...)
+	if len(w.buf) >= w.opts.BatchSize {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Stats возвращает счетчики записи
+func (w *OpentsdbWriter) Stats() OpentsdbStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.Buffered = len(w.buf)
+	return stats
+}
+
+// Run отправляет точки до отмены ctx; при остановке пытается отправить
+// остаток буфера
+func (w *OpentsdbWriter) Run(ctx context.Context) {
+	defer w.disconnect()
+	retry := NewBackoff(w.opts.RetryMin, w.opts.RetryMax)
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			w.drain()
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+
+		for w.Stats().Buffered > 0 {
+			err := w.flush(ctx)
+			if err == nil {
+				retry.Reset()
+				continue
+			}
+			d := retry.Next()
+			w.mu.Lock()
+			w.stats.Failures++
+			w.stats.LastError = err.Error()
+			w.mu.Unlock()
+			log.Printf("Ошибка записи в OpenTSDB: %v; повтор через %s", err, d)
+			if !sleepContext(ctx, d) {
+				w.drain()
+				return
+			}
+		}
+	}
+}
+
+// drain пытается отправить остаток буфера при остановке
+func (w *OpentsdbWriter) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for w.Stats().Buffered > 0 {
+		if err := w.flush(ctx); err != nil {
+			log.Printf("Точки не записаны в OpenTSDB при остановке (%d точек): %v", w.Stats().Buffered, err)
+			return
+		}
+	}
+}
+
+// flush отправляет один пакет из начала буфера
+func (w *OpentsdbWriter) flush(ctx context.Context) error {
+	w.mu.Lock()
+	n := len(w.buf)
+	if n > w.opts.BatchSize {
+		n = w.opts.BatchSize
+	}
+	batch := w.buf[:n]
+	w.mu.Unlock()
+
+	var rejected int
+	var err error
+	if w.cfg.Protocol == config.OpentsdbTelnet {
+		err = w.sendTelnet(batch)
+	} else {
+		rejected, err = w.sendHTTP(ctx, batch)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.buf = append(w.buf[:0:0], w.buf[n:]...)
+	w.stats.Written += uint64(n)
+	w.stats.Batches++
+	w.stats.Rejected += uint64(rejected)
+	w.stats.LastWrite = time.Now()
+	w.stats.LastError = ""
+	w.mu.Unlock()
+	return nil
+}
+
+// sendHTTP отправляет пакет запросом POST /api/put и возвращает число
+// отклоненных точек. Ответ 400 означает, что часть точек некорректна:
+// остальные записаны, и пакет не повторяется.
+func (w *OpentsdbWriter) sendHTTP(ctx context.Context, batch []OpentsdbPoint) (int, error) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return 0, err
+	}
+	url := "http://" + w.cfg.Addr() + "/api/put?summary"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return 0, nil
+	case resp.StatusCode == http.StatusBadRequest:
+		var summary struct {
+			Failed int `json:"failed"`
+		}
+		if json.Unmarshal(respBody, &summary) != nil || summary.Failed == 0 {
+			summary.Failed = len(batch)
+		}
+		log.Printf("OpenTSDB отклонил %d точек из %d: %s", summary.Failed, len(batch), bytes.TrimSpace(respBody))
+		return summary.Failed, nil
+	}
+	return 0, fmt.Errorf("%s: статус %s: %s", url, resp.Status, bytes.TrimSpace(respBody))
+}
+
+// sendTelnet пишет пакет командами put в соединение telnet, открывая его
+// при необходимости. OpenTSDB отвечает только на ошибочные команды; ответы
+// читает readTelnet. Соединение, которое OpenTSDB закрыл, заменяется новым
+// до записи.
+func (w *OpentsdbWriter) sendTelnet(batch []OpentsdbPoint) error {
+	if w.conn != nil {
+		select {
+		case <-w.closed:
+			w.disconnect()
+		default:
+		}
+	}
+	if w.conn == nil {
+		conn, err := net.DialTimeout("tcp", w.cfg.Addr(), w.cfg.Timeout.Duration)
+		if err != nil {
+			return err
+		}
+		w.conn, w.closed = conn, make(chan struct{})
+		go w.readTelnet(conn, w.closed)
+	}
+
+	var b bytes.Buffer
+	for _, p := range batch {
+		b.WriteString(p.Line())
+	}
+	if w.cfg.Timeout.Duration > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.cfg.Timeout.Duration))
+	}
+	if _, err := w.conn.Write(b.Bytes()); err != nil {
+		w.disconnect()
+		return err
+	}
+	return nil
+}
+
+// readTelnet учитывает ошибки, которые OpenTSDB пишет в ответ на
+// некорректные команды put. Когда OpenTSDB закрывает соединение, оно
+// закрывается и с нашей стороны, а closed сообщает sendTelnet, что
+// следующий пакет нужно писать в новое соединение.
+func (w *OpentsdbWriter) readTelnet(conn net.Conn, closed chan struct{}) {
+	defer close(closed)
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		log.Printf("OpenTSDB отклонил точку: %s", line)
+		w.mu.Lock()
+		w.stats.Rejected++
+		w.mu.Unlock()
+	}
+}
+
+// disconnect закрывает соединение telnet
+func (w *OpentsdbWriter) disconnect() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn, w.closed = nil, nil
+	}
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"big_go/config"
+	"big_go/internal/models"
+	"bufio"
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testReading - показание с двумя измерениями
+func testReading() models.SensorData {
+	return models.SensorData{
+		Meta: models.MetaData{
+			Recipient: "user 1",
+			Address:   3,
+			PostID:    7,
+			Timestamp: time.UnixMilli(1700000000123),
+		},
+		Data: map[string]models.Metric{
+			"temperature": {Value: 21.5, Unit: "C"},
+			"humidity":    {Value: 40, Unit: "%"},
+		},
+	}
+}
+
+// newTestWriter создает запись в OpenTSDB по адресу addr ("хост:порт")
+func newTestWriter(t *testing.T, protocol, addr string, opts OpentsdbOptions) *OpentsdbWriter {
+	t.Helper()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.OpentsdbConfig{Protocol: protocol, MetricPrefix: "big_go", Timeout: config.NewDuration(time.Second)}
+	cfg.Host = host
+	if cfg.Port, err = strconv.Atoi(port); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewOpentsdbWriter(cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// waitFor ждет, пока выполнится cond
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались: %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOpentsdbPointLine(t *testing.T) {
+	p := OpentsdbPoint{
+		Metric:    "big_go.temperature",
+		Timestamp: 1700000000123,
+		Value:     21.5,
+		Tags:      map[string]string{"recipient": "user_1", "address": "3", "post": "7"},
+	}
+	want := "put big_go.temperature 1700000000123 21.5 address=3 post=7 recipient=user_1\n"
+	if got := p.Line(); got != want {
+		t.Errorf("Line() = %q, want %q", got, want)
+	}
+}
+
+func TestOpentsdbPoints(t *testing.T) {
+	points := OpentsdbPoints("big_go", testReading())
+	if len(points) != 2 {
+		t.Fatalf("got %d points, want 2", len(points))
+	}
+	for _, p := range points {
+		if p.Metric != "big_go.temperature" && p.Metric != "big_go.humidity" {
+			t.Errorf("unexpected metric %q", p.Metric)
+		}
+		if p.Timestamp != 1700000000123 {
+			t.Errorf("%s: timestamp %d, want 1700000000123", p.Metric, p.Timestamp)
+		}
+		if p.Tags["recipient"] != "user_1" || p.Tags["address"] != "3" || p.Tags["post"] != "7" {
+			t.Errorf("%s: tags %v", p.Metric, p.Tags)
+		}
+	}
+}
+
+// tsdbServer - сервер /api/put, отвечающий по очереди статусами statuses
+// (после них - 204) и запоминающий принятые пакеты
+type tsdbServer struct {
+	mu       sync.Mutex
+	statuses []int
+	body     string // тело ответа с ошибкой
+	batches  [][]OpentsdbPoint
+}
+
+func (s *tsdbServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/api/put" {
+		http.NotFound(w, r)
+		return
+	}
+	var batch []OpentsdbPoint
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	s.batches = append(s.batches, batch)
+	status := http.StatusNoContent
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	s.mu.Unlock()
+	w.WriteHeader(status)
+	if status != http.StatusNoContent {
+		w.Write([]byte(s.body))
+	}
+}
+
+func (s *tsdbServer) received() [][]OpentsdbPoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]OpentsdbPoint(nil), s.batches...)
+}
+
+func TestOpentsdbWriterHTTP(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		body     string
+		rejected uint64
+	}{
+		{name: "written", statuses: []int{http.StatusOK}},
+		{name: "summary", statuses: []int{http.StatusBadRequest}, body: `{"failed": 1, "success": 1}`, rejected: 1},
+		{name: "no summary", statuses: []int{http.StatusBadRequest}, body: `bad request`, rejected: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &tsdbServer{statuses: tt.statuses, body: tt.body}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			w := newTestWriter(t, config.OpentsdbHTTP, strings.TrimPrefix(ts.URL, "http://"), OpentsdbOptions{})
+			if err := w.Save(testReading()); err != nil {
+				t.Fatal(err)
+			}
+			// 400 - часть точек некорректна: пакет не повторяется
+			if err := w.flush(context.Background()); err != nil {
+				t.Fatalf("flush: %v", err)
+			}
+
+			stats := w.Stats()
+			if stats.Buffered != 0 || stats.Written != 2 || stats.Batches != 1 {
+				t.Errorf("stats %+v, want 2 points written in 1 batch", stats)
+			}
+			if stats.Rejected != tt.rejected {
+				t.Errorf("rejected %d, want %d", stats.Rejected, tt.rejected)
+			}
+			if got := srv.received(); len(got) != 1 || len(got[0]) != 2 {
+				t.Errorf("server received %v, want one batch of 2 points", got)
+			}
+		})
+	}
+}
+
+func TestOpentsdbWriterHTTPRetry(t *testing.T) {
+	srv := &tsdbServer{
+		statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError},
+		body:     "try later",
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	w := newTestWriter(t, config.OpentsdbHTTP, strings.TrimPrefix(ts.URL, "http://"), OpentsdbOptions{
+		FlushInterval: 10 * time.Millisecond,
+		RetryMin:      10 * time.Millisecond,
+		RetryMax:      20 * time.Millisecond,
+	})
+	if err := w.Save(testReading()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	waitFor(t, "запись после повторов", func() bool { return w.Stats().Written == 2 })
+	cancel()
+	<-done
+
+	stats := w.Stats()
+	if stats.Failures != 2 || stats.Batches != 1 || stats.Rejected != 0 || stats.LastError != "" {
+		t.Errorf("stats %+v, want 2 failures and 1 batch", stats)
+	}
+	// Повторяется тот же пакет
+	got := srv.received()
+	if len(got) != 3 {
+		t.Fatalf("server received %d batches, want 3", len(got))
+	}
+	for i, batch := range got {
+		if len(batch) != 2 || batch[0].Metric != got[0][0].Metric {
+			t.Errorf("batch %d = %v, want a retry of %v", i, batch, got[0])
+		}
+	}
+}
+
+func TestOpentsdbWriterTelnet(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Сервер отвечает ошибкой на команды put с нечисловым значением, как
+	// OpenTSDB, и передает принятые команды в lines
+	lines := make(chan string, 100)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			line := scanner.Text()
+			lines <- line
+			if fields := strings.Fields(line); len(fields) < 4 || fields[3] == "NaN" {
+				conn.Write([]byte("put: invalid value: " + line + "\n"))
+			}
+		}
+	}()
+
+	w := newTestWriter(t, config.OpentsdbTelnet, ln.Addr().String(), OpentsdbOptions{})
+	defer w.disconnect()
+	points := OpentsdbPoints("big_go", testReading())
+	bad := points[0]
+	bad.Metric, bad.Value = "big_go.pressure", math.NaN()
+	points = append(points, bad)
+	if err := w.enqueue(points); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	for i, p := range points {
+		select {
+		case line := <-lines:
+			if want := strings.TrimSuffix(p.Line(), "\n"); line != want {
+				t.Errorf("line %d = %q, want %q", i, line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("server received %d of %d lines", i, len(points))
+		}
+	}
+	waitFor(t, "учет отклоненной точки", func() bool { return w.Stats().Rejected == 1 })
+	if stats := w.Stats(); stats.Written != 3 || stats.Batches != 1 {
+		t.Errorf("stats %+v, want 3 points written in 1 batch", stats)
+	}
+}
+
+func TestOpentsdbWriterTelnetReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Сервер закрывает первое соединение после первой команды, как
+	// перезапущенный OpenTSDB, и передает команды каждого соединения в lines
+	lines := make(chan string, 100)
+	go func() {
+		for first := true; ; first = false {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+				if first {
+					break
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	w := newTestWriter(t, config.OpentsdbTelnet, ln.Addr().String(), OpentsdbOptions{BatchSize: 1})
+	defer w.disconnect()
+	points := OpentsdbPoints("big_go", testReading())
+	if err := w.enqueue(points); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatalf("first flush: %v", err)
+	}
+	<-lines
+	closed := w.closed
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("соединение, закрытое сервером, не замечено")
+	}
+
+	// Следующий пакет пишется в новое соединение с первой попытки
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatalf("flush after the server closed the connection: %v", err)
+	}
+	select {
+	case line := <-lines:
+		if want := strings.TrimSuffix(points[1].Line(), "\n"); line != want {
+			t.Errorf("line = %q, want %q", line, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not receive the second batch")
+	}
+	if stats := w.Stats(); stats.Written != 2 || stats.Batches != 2 {
+		t.Errorf("stats %+v, want 2 points written in 2 batches", stats)
+	}
+}
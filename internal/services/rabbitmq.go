@@ -0,0 +1,458 @@
+package services
+
+import (
+	"big_go/config"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// SensorDataQueue - очередь, через которую генератор передает показания коллектору
+const SensorDataQueue = "sensor_data"
+
+// Точка обмена и очередь недоставленных сообщений: коллектор публикует в
+// DeadLetterExchange сообщения, которые не может разобрать или доставить
+const (
+	DeadLetterExchange = "sensor_data.dlx"
+	DeadLetterQueue    = "sensor_data.dead_letter"
+)
+
+// Заголовки, которыми коллектор помечает недоставленные сообщения
+const (
+	HeaderDeadLetterReason = "x-dead-letter-reason" // DeadLetter*
+	HeaderDeadLetterError  = "x-dead-letter-error"  // текст последней ошибки
+	HeaderDeliveryAttempts = "x-delivery-attempts"  // число попыток доставки
+	HeaderOriginalQueue    = "x-original-queue"     // очередь, из которой получено сообщение
+	HeaderRoute            = "x-route"              // маршрут коллектора, по которому не удалась доставка
+)
+
+// Причины, по которым сообщение попадает в очередь недоставленных
+const (
+	DeadLetterMalformed        = "malformed"         // тело не разбирается
+	DeadLetterUnknownRecipient = "unknown_recipient" // получателя нет в таблице маршрутов
+	DeadLetterRetriesExhausted = "retries_exhausted" // доставка не удалась за отведенные попытки
+	DeadLetterCircuitOpen      = "circuit_open"      // адреса маршрута отключены автоматом
+)
+
+// ErrBufferFull возвращается, когда буфер неподтвержденных сообщений заполнен
+var ErrBufferFull = errors.New("буфер публикации заполнен")
+
+// QueueSpec описывает объявляемую очередь
+type QueueSpec struct {
+	Name    string
+	Durable bool
+	Args    amqp.Table
+}
+
+// ExchangeSpec описывает объявляемую точку обмена
+type ExchangeSpec struct {
+	Name    string
+	Kind    string
+	Durable bool
+}
+
+// BindingSpec описывает привязку очереди к точке обмена
+type BindingSpec struct {
+	Queue    string
+	Exchange string
+	Key      string
+}
+
+// Topology - объекты RabbitMQ, которые объявляются при каждом подключении
+type Topology struct {
+	Exchanges []ExchangeSpec
+	Queues    []QueueSpec
+	Bindings  []BindingSpec
+}
+
+// SensorDataTopology возвращает топологию очереди показаний
+func SensorDataTopology() Topology {
+	return Topology{
+		Queues: []QueueSpec{{Name: SensorDataQueue, Durable: true}},
+	}
+}
+
+// CollectorTopology возвращает очереди, которые использует коллектор
+func CollectorTopology() Topology {
+	t := SensorDataTopology()
+	t.Exchanges = append(t.Exchanges, ExchangeSpec{Name: DeadLetterExchange, Kind: amqp.ExchangeFanout, Durable: true})
+	t.Queues = append(t.Queues,
+		QueueSpec{Name: DeadLetterQueue, Durable: true},
+	)
+	t.Bindings = append(t.Bindings, BindingSpec{Queue: DeadLetterQueue, Exchange: DeadLetterExchange})
+	return t
+}
+
+// Declare объявляет точки обмена, очереди и привязки
+func (t Topology) Declare(ch *amqp.Channel) error {
+	for _, e := range t.Exchanges {
+		if err := ch.ExchangeDeclare(e.Name, e.Kind, e.Durable, false, false, false, nil); err != nil {
+			return fmt.Errorf("ошибка объявления точки обмена %s: %v", e.Name, err)
+		}
+	}
+	for _, q := range t.Queues {
+		if _, err := ch.QueueDeclare(q.Name, q.Durable, false, false, false, q.Args); err != nil {
+			return fmt.Errorf("ошибка объявления очереди %s: %v", q.Name, err)
+		}
+	}
+	for _, b := range t.Bindings {
+		if err := ch.QueueBind(b.Queue, b.Key, b.Exchange, false, nil); err != nil {
+			return fmt.Errorf("ошибка привязки очереди %s к %s: %v", b.Queue, b.Exchange, err)
+		}
+	}
+	return nil
+}
+
+// Backoff - экспоненциальная задержка повторных попыток со случайным разбросом
+type Backoff struct {
+	min, max time.Duration
+	current  time.Duration
+}
+
+// NewBackoff создает задержку в пределах min..max
+func NewBackoff(min, max time.Duration) *Backoff {
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	if max < min {
+		max = min
+	}
+	return &Backoff{min: min, max: max}
+}
+
+// Next возвращает следующую задержку: удвоенную предыдущую в пределах
+// min..max, со случайным разбросом до половины значения
+func (b *Backoff) Next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else if b.current *= 2; b.current > b.max {
+		b.current = b.max
+	}
+	half := int64(b.current / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Reset сбрасывает задержку после успешного подключения
+func (b *Backoff) Reset() {
+	b.current = 0
+}
+
+// sleepContext ожидает d или отмены ctx
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// PublisherStats - счетчики публикатора
+type PublisherStats struct {
+	Accepted   uint64 // принято в буфер
+	Rejected   uint64 // отклонено из-за переполнения буфера
+	Confirmed  uint64 // подтверждено брокером
+	Nacked     uint64 // отклонено брокером и поставлено на повтор
+	Republish  uint64 // повторно отправлено после переподключения
+	Reconnects uint64
+	Buffered   int // ожидает отправки или подтверждения
+	Connected  bool
+}
+
+// pending - сообщение, ожидающее подтверждения брокера
+type pending struct {
+	msg  amqp.Publishing
+	sent bool // отправлялось ли уже сообщение
+}
+
+// Publisher публикует сообщения в очередь с подтверждениями брокера
+// (publisher confirms). Сообщения хранятся в ограниченном буфере до
+// подтверждения; при разрыве соединения публикатор переподключается с
+// экспоненциальной задержкой, заново объявляет топологию и повторно
+// отправляет все неподтвержденные сообщения в исходном порядке.
+type Publisher struct {
+	url        string
+	topology   Topology
+	exchange   string
+	routingKey string
+	capacity   int
+	maxWait    time.Duration
+	minWait    time.Duration
+
+	mu       sync.Mutex
+	queue    []*pending          // ожидают отправки
+	inflight map[uint64]*pending // отправлены, ждут подтверждения
+	stats    PublisherStats
+	wake     chan struct{}
+	space    chan struct{} // в буфере освободилось место (для PublishWait)
+}
+
+// NewPublisher создает публикатор для очереди routingKey
+func NewPublisher(cfg *config.RabbitMQConfig, topology Topology, routingKey string) *Publisher {
+	return &Publisher{
+		url:        cfg.URL(),
+		topology:   topology,
+		routingKey: routingKey,
+		capacity:   cfg.PublishBuffer,
+		minWait:    cfg.ReconnectMin.Duration,
+		maxWait:    cfg.ReconnectMax.Duration,
+		inflight:   make(map[uint64]*pending),
+		wake:       make(chan struct{}, 1),
+		space:      make(chan struct{}, 1),
+	}
+}
+
+// NewExchangePublisher создает публикатор в точку обмена exchange с ключом routingKey
+func NewExchangePublisher(cfg *config.RabbitMQConfig, topology Topology, exchange, routingKey string) *Publisher {
+	p := NewPublisher(cfg, topology, routingKey)
+	p.exchange = exchange
+	return p
+}
+
+// Publish помещает сообщение в буфер; отправка и подтверждение
+// выполняются в Run. Возвращает ErrBufferFull, если буфер заполнен.
+func (p *Publisher) Publish(msg amqp.Publishing) error {
+	if !p.add(msg) {
+		p.mu.Lock()
+		p.stats.Rejected++
+		p.mu.Unlock()
+		return ErrBufferFull
+	}
+	return nil
+}
+
+// PublishWait помещает сообщение в буфер, ожидая, пока в нем освободится
+// место; возвращает ошибку, только если ctx отменен
+func (p *Publisher) PublishWait(ctx context.Context, msg amqp.Publishing) error {
+	for !p.add(msg) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.space:
+		}
+	}
+	return nil
+}
+
+// add помещает сообщение в буфер; false - буфер заполнен
+func (p *Publisher) add(msg amqp.Publishing) bool {
+	p.mu.Lock()
+	if p.capacity > 0 && len(p.queue)+len(p.inflight) >= p.capacity {
+		p.mu.Unlock()
+		return false
+	}
+	if msg.DeliveryMode == 0 {
+		msg.DeliveryMode = amqp.Persistent
+	}
+	p.queue = append(p.queue, &pending{msg: msg})
+	p.stats.Accepted++
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Stats возвращает текущие счетчики
+func (p *Publisher) Stats() PublisherStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Buffered = len(p.queue) + len(p.inflight)
+	return stats
+}
+
+// Flush ожидает подтверждения всех сообщений буфера, но не дольше timeout.
+// Возвращает число неподтвержденных сообщений.
+func (p *Publisher) Flush(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if p.Stats().Buffered == 0 {
+			return 0
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return p.Stats().Buffered
+}
+
+// Run поддерживает соединение и отправляет сообщения, пока не отменен ctx
+func (p *Publisher) Run(ctx context.Context) {
+	retry := NewBackoff(p.minWait, p.maxWait)
+	for ctx.Err() == nil {
+		conn, ch, err := p.connect()
+		if err != nil {
+			wait := retry.Next()
+			log.Printf("Ошибка подключения к RabbitMQ: %v; повтор через %s", err, wait)
+			if !sleepContext(ctx, wait) {
+				return
+			}
+			continue
+		}
+		retry.Reset()
+
+		p.setConnected(true)
+		err = p.serve(ctx, ch, conn.NotifyClose(make(chan *amqp.Error, 1)))
+		p.setConnected(false)
+		conn.Close()
+
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Соединение с RabbitMQ потеряно: %v; переподключение", err)
+		p.mu.Lock()
+		p.stats.Reconnects++
+		p.mu.Unlock()
+	}
+}
+
+// connect устанавливает соединение, включает подтверждения и объявляет топологию
+func (p *Publisher) connect() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(p.url)
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("ошибка создания канала: %v", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("ошибка включения подтверждений: %v", err)
+	}
+	if err := p.topology.Declare(ch); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	log.Printf("Публикатор подключен к RabbitMQ, неподтвержденных сообщений: %d", p.Stats().Buffered)
+	return conn, ch, nil
+}
+
+// maxInflight ограничивает число отправленных, но не подтвержденных сообщений
+const maxInflight = 1000
+
+// serve отправляет сообщения по каналу ch и обрабатывает подтверждения,
+// пока канал не закроется
+func (p *Publisher) serve(ctx context.Context, ch *amqp.Channel, connClosed chan *amqp.Error) error {
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, maxInflight+1))
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	// После переподключения номера доставки начинаются заново
+	defer p.requeueInflight()
+	var tag uint64
+
+	for {
+		if err := p.sendPending(ch, &tag); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-connClosed:
+			return err
+		case err := <-chClosed:
+			return err
+		case c, ok := <-confirms:
+			if !ok {
+				return errors.New("канал подтверждений закрыт")
+			}
+			p.confirm(c)
+		case <-p.wake:
+		}
+	}
+}
+
+// sendPending отправляет ожидающие сообщения, пока не достигнут предел
+func (p *Publisher) sendPending(ch *amqp.Channel, tag *uint64) error {
+	for {
+		p.mu.Lock()
+		if len(p.queue) == 0 || len(p.inflight) >= maxInflight {
+			p.mu.Unlock()
+			return nil
+		}
+		m := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+
+		if err := ch.Publish(p.exchange, p.routingKey, false, false, m.msg); err != nil {
+			p.mu.Lock()
+			p.queue = append([]*pending{m}, p.queue...)
+			p.mu.Unlock()
+			return err
+		}
+
+		*tag++
+		p.mu.Lock()
+		if m.sent {
+			p.stats.Republish++
+		}
+		m.sent = true
+		p.inflight[*tag] = m
+		p.mu.Unlock()
+	}
+}
+
+// confirm обрабатывает подтверждение брокера
+func (p *Publisher) confirm(c amqp.Confirmation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m, ok := p.inflight[c.DeliveryTag]
+	if !ok {
+		return
+	}
+	delete(p.inflight, c.DeliveryTag)
+
+	if c.Ack {
+		p.stats.Confirmed++
+		select {
+		case p.space <- struct{}{}:
+		default:
+		}
+		return
+	}
+	// Брокер не принял сообщение - отправим его снова первым
+	p.stats.Nacked++
+	p.queue = append([]*pending{m}, p.queue...)
+}
+
+// requeueInflight возвращает неподтвержденные сообщения в начало очереди
+// отправки в порядке их исходной публикации
+func (p *Publisher) requeueInflight() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.inflight) == 0 {
+		return
+	}
+	tags := make([]uint64, 0, len(p.inflight))
+	for tag := range p.inflight {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	requeued := make([]*pending, 0, len(tags)+len(p.queue))
+	for _, tag := range tags {
+		requeued = append(requeued, p.inflight[tag])
+	}
+	p.queue = append(requeued, p.queue...)
+	p.inflight = make(map[uint64]*pending)
+}
+
+func (p *Publisher) setConnected(connected bool) {
+	p.mu.Lock()
+	p.stats.Connected = connected
+	p.mu.Unlock()
+}
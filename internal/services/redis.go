@@ -0,0 +1,421 @@
+package services
+
+import (
+	"big_go/config"
+	"big_go/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrCacheFull возвращается RedisCache.Save, когда буфер записи заполнен
+var ErrCacheFull = errors.New("буфер записи в Redis заполнен")
+
+// ErrNotCached возвращается, если показания нет в Redis или истек его TTL
+var ErrNotCached = errors.New("показание не найдено в Redis")
+
+// redisBatchSize - сколько показаний записывается в Redis одним конвейером
+const redisBatchSize = 100
+
+// redisSeenTTL - сколько помнится идентификатор записанного показания,
+// если ключи не истекают (ttl = 0)
+const redisSeenTTL = 24 * time.Hour
+
+// updateScript записывает одно показание: обновляет последние значения
+// поста (KEYS[1]) и адреса (KEYS[2]), если показание не старше сохраненного,
+// добавляет его в историю адресата (KEYS[3]) и при обновлении поста
+// публикует его в канал адресата. Повтор уже записанного показания
+// (отметка KEYS[4] существует) ничего не меняет.
+// ARGV: время показания в мс, показание в JSON, TTL в мс (0 - без
+// срока), длина истории (0 - без истории), канал, срок отметки в мс (0 -
+// показание без идентификатора, повтор не распознается).
+// Возвращает 1, если последнее значение поста обновлено, 0 - если
+// показание старше сохраненного, 2 - если это повтор.
+var updateScript = redis.NewScript(`
+local seen = tonumber(ARGV[6])
+if seen > 0 and not redis.call('SET', KEYS[4], '1', 'NX', 'PX', ARGV[6]) then
+	return 2
+end
+local ts = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[3])
+local fresh = 0
+for i = 1, 2 do
+	local current = tonumber(redis.call('HGET', KEYS[i], 'ts'))
+	if not current or current <= ts then
+		redis.call('HSET', KEYS[i], 'ts', ARGV[1], 'reading', ARGV[2])
+		if ttl > 0 then
+			redis.call('PEXPIRE', KEYS[i], ttl)
+		end
+		if i == 1 then
+			fresh = 1
+		end
+	end
+end
+local length = tonumber(ARGV[4])
+if length > 0 then
+	redis.call('LPUSH', KEYS[3], ARGV[2])
+	redis.call('LTRIM', KEYS[3], 0, length - 1)
+	if ttl > 0 then
+		redis.call('PEXPIRE', KEYS[3], ttl)
+	end
+end
+if fresh == 1 then
+	redis.call('PUBLISH', ARGV[5], ARGV[2])
+end
+return fresh
+`)
+
+// RedisCacheOptions - параметры записи в Redis
+type RedisCacheOptions struct {
+	Buffer   int           // сколько показаний ждет записи; при переполнении новые отбрасываются
+	RetryMin time.Duration // задержка повтора после ошибки Redis
+	RetryMax time.Duration
+}
+
+// RedisCacheStats - счетчики записи в Redis
+type RedisCacheStats struct {
+	Buffered   int       `json:"buffered"`
+	Written    uint64    `json:"written"`    // записано показаний
+	Stale      uint64    `json:"stale"`      // показания старше сохраненного: попали только в историю
+	Duplicates uint64    `json:"duplicates"` // повторы записанных показаний: пропущены
+	Published  uint64    `json:"published"`  // опубликовано уведомлений
+	Dropped    uint64    `json:"dropped"`    // отброшено из-за переполнения буфера
+	Failures   uint64    `json:"failures"`   // неудачные попытки записи пакета
+	LastError  string    `json:"last_error,omitempty"`
+	LastWrite  time.Time `json:"last_write,omitempty"`
+}
+
+// RedisCache хранит в Redis последнее показание каждого поста и адреса и
+// ограниченную историю последних показаний каждого адресата, а новые
+// показания публикует в канал адресата. Так пользовательские сервисы и
+// инструменты администратора получают текущее состояние без обращения к
+// коллектору.
+//
+// Ключи (prefix - config.RedisConfig.KeyPrefix):
+//
+//	<prefix>:post:<address>:<post_id>  хеш {ts, reading} - последнее показание поста
+//	<prefix>:address:<address>         хеш {ts, reading} - последнее показание адреса
+//	<prefix>:history:<recipient>       список показаний адресата, новые в начале
+//	<prefix>:readings:<recipient>      канал: показания, обновившие пост
+//	<prefix>:seen:<id>                 отметка записанного показания (на ttl или сутки)
+//
+// Запись идемпотентна: коллектор сохраняет показание при каждой повторной
+// доставке, но повтор не попадает в историю второй раз и не публикуется.
+//
+// Показания в JSON (models.SensorData). Save, как и repository.Writer, не
+// ждет Redis: показания копятся в буфере и записываются в Run; при
+// остановке Run пытается записать остаток буфера.
+type RedisCache struct {
+	client  *redis.Client
+	prefix  string
+	ttl     time.Duration
+	history int
+	opts    RedisCacheOptions
+
+	mu    sync.Mutex
+	buf   []models.SensorData
+	stats RedisCacheStats
+	wake  chan struct{}
+}
+
+// NewRedisCache создает кэш в Redis, описанном cfg. Соединение
+// устанавливается при первом обращении.
+func NewRedisCache(cfg *config.RedisConfig, opts RedisCacheOptions) *RedisCache {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr(),
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	return &RedisCache{
+		client:  client,
+		prefix:  cfg.KeyPrefix,
+		ttl:     cfg.TTL.Duration,
+		history: cfg.HistoryLength,
+		opts:    opts,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Close закрывает соединения с Redis
+func (r *RedisCache) Close() error {
+	return r.client.Close()
+}
+
+// Ping проверяет доступность Redis
+func (r *RedisCache) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+func (r *RedisCache) postKey(address, postID int) string {
+	return fmt.Sprintf("%s:post:%d:%d", r.prefix, address, postID)
+}
+
+func (r *RedisCache) addressKey(address int) string {
+	return fmt.Sprintf("%s:address:%d", r.prefix, address)
+}
+
+func (r *RedisCache) seenKey(id string) string {
+	return fmt.Sprintf("%s:seen:%s", r.prefix, id)
+}
+
+func (r *RedisCache) historyKey(recipient string) string {
+	return fmt.Sprintf("%s:history:%s", r.prefix, recipient)
+}
+
+// Channel возвращает канал уведомлений адресата
+func (r *RedisCache) Channel(recipient string) string {
+	return fmt.Sprintf("%s:readings:%s", r.prefix, recipient)
+}
+
+// Save ставит показание в очередь записи
+func (r *RedisCache) Save(data models.SensorData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.opts.Buffer > 0 && len(r.buf) >= r.opts.Buffer {
+		r.stats.Dropped++
+		return ErrCacheFull
+	}
+	r.buf = append(r.buf, data)
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Stats возвращает счетчики записи
+func (r *RedisCache) Stats() RedisCacheStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats
+	stats.Buffered = len(r.buf)
+	return stats
+}
+
+// Run записывает показания до отмены ctx. Пока Redis недоступен, пакет
+// повторяется с нарастающей задержкой, а показания копятся в буфере. При
+// остановке пытается записать остаток буфера.
+func (r *RedisCache) Run(ctx context.Context) {
+	retry := NewBackoff(r.opts.RetryMin, r.opts.RetryMax)
+	for {
+		select {
+		case <-ctx.Done():
+			r.drain()
+			return
+		case <-r.wake:
+		}
+
+		for r.Stats().Buffered > 0 {
+			err := r.flush(ctx)
+			if err == nil {
+				retry.Reset()
+				continue
+			}
+			if ctx.Err() != nil {
+				r.drain()
+				return
+			}
+			d := retry.Next()
+			r.mu.Lock()
+			r.stats.Failures++
+			r.stats.LastError = err.Error()
+			r.mu.Unlock()
+			log.Printf("Ошибка записи показаний в Redis: %v; повтор через %s", err, d)
+			if !sleepContext(ctx, d) {
+				r.drain()
+				return
+			}
+		}
+	}
+}
+
+// drain пытается записать остаток буфера при остановке
+func (r *RedisCache) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for r.Stats().Buffered > 0 {
+		if err := r.flush(ctx); err != nil {
+			log.Printf("Показания не записаны в Redis при остановке (%d показаний): %v", r.Stats().Buffered, err)
+			return
+		}
+	}
+}
+
+// flush записывает один пакет из начала буфера
+func (r *RedisCache) flush(ctx context.Context) error {
+	r.mu.Lock()
+	n := len(r.buf)
+	if n > redisBatchSize {
+		n = redisBatchSize
+	}
+	batch := r.buf[:n]
+	r.mu.Unlock()
+
+	seen := r.ttl
+	if seen <= 0 {
+		seen = redisSeenTTL
+	}
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.Cmd, 0, n)
+	for _, data := range batch {
+		reading, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		keys := []string{
+			r.postKey(data.Meta.Address, data.Meta.PostID),
+			r.addressKey(data.Meta.Address),
+			r.historyKey(data.Meta.Recipient),
+			r.seenKey(data.ID),
+		}
+		seenMs := seen.Milliseconds()
+		if data.ID == "" {
+			seenMs = 0
+		}
+		cmds = append(cmds, updateScript.Eval(ctx, pipe, keys,
+			data.Meta.Timestamp.UnixMilli(), reading, r.ttl.Milliseconds(), r.history,
+			r.Channel(data.Meta.Recipient), seenMs))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	var fresh, stale, duplicates uint64
+	for _, cmd := range cmds {
+		switch result, _ := cmd.Int(); result {
+		case 1:
+			fresh++
+		case 2:
+			duplicates++
+		default:
+			stale++
+		}
+	}
+
+	r.mu.Lock()
+	r.buf = append(r.buf[:0:0], r.buf[n:]...)
+	r.stats.Written += fresh + stale
+	r.stats.Published += fresh
+	r.stats.Stale += stale
+	r.stats.Duplicates += duplicates
+	r.stats.LastWrite = time.Now()
+	r.stats.LastError = ""
+	r.mu.Unlock()
+	return nil
+}
+
+// latest читает показание из хеша последнего значения
+func (r *RedisCache) latest(ctx context.Context, key string) (models.SensorData, error) {
+	var data models.SensorData
+	reading, err := r.client.HGet(ctx, key, "reading").Bytes()
+	if err == redis.Nil {
+		return data, ErrNotCached
+	}
+	if err != nil {
+		return data, err
+	}
+	err = json.Unmarshal(reading, &data)
+	return data, err
+}
+
+// LatestByPost возвращает последнее показание поста
+func (r *RedisCache) LatestByPost(ctx context.Context, address, postID int) (models.SensorData, error) {
+	return r.latest(ctx, r.postKey(address, postID))
+}
+
+// LatestByAddress возвращает последнее показание любого поста адреса
+func (r *RedisCache) LatestByAddress(ctx context.Context, address int) (models.SensorData, error) {
+	return r.latest(ctx, r.addressKey(address))
+}
+
+// LatestPosts возвращает последние показания всех постов, TTL которых не
+// истек, по адресам и номерам постов
+func (r *RedisCache) LatestPosts(ctx context.Context) ([]models.SensorData, error) {
+	var result []models.SensorData
+	iter := r.client.Scan(ctx, 0, r.prefix+":post:*", 1000).Iterator()
+	for iter.Next(ctx) {
+		data, err := r.latest(ctx, iter.Val())
+		if errors.Is(err, ErrNotCached) {
+			continue // ключ истек между SCAN и HGET
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Meta.Address != result[j].Meta.Address {
+			return result[i].Meta.Address < result[j].Meta.Address
+		}
+		return result[i].Meta.PostID < result[j].Meta.PostID
+	})
+	return result, nil
+}
+
+// History возвращает до limit последних показаний адресата, новые в
+// начале; limit <= 0 - всю сохраненную историю
+func (r *RedisCache) History(ctx context.Context, recipient string, limit int) ([]models.SensorData, error) {
+	readings, err := r.client.LRange(ctx, r.historyKey(recipient), 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]models.SensorData, 0, len(readings))
+	for _, reading := range readings {
+		var data models.SensorData
+		if err := json.Unmarshal([]byte(reading), &data); err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+	return result, nil
+}
+
+// Subscribe подписывается на новые показания адресата. Канал закрывается
+// после отмены ctx; при обрыве соединения подписка восстанавливается, а
+// показания, опубликованные за это время, теряются.
+func (r *RedisCache) Subscribe(ctx context.Context, recipient string) (<-chan models.SensorData, error) {
+	pubsub := r.client.Subscribe(ctx, r.Channel(recipient))
+	// Ждем подтверждения подписки, чтобы вернуть ошибку соединения
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	out := make(chan models.SensorData)
+	go func() {
+		defer close(out)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var data models.SensorData
+				if err := json.Unmarshal([]byte(msg.Payload), &data); err != nil {
+					log.Printf("Некорректное показание в канале %s: %v", msg.Channel, err)
+					continue
+				}
+				select {
+				case out <- data:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
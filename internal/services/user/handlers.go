@@ -0,0 +1,136 @@
+package user
+
+import (
+	"big_go/config"
+	"big_go/internal/codec"
+	"big_go/internal/models"
+	"big_go/internal/services"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// service - HTTP-обработчики пользовательского сервиса
+type service struct {
+	name      string
+	dashboard *Dashboard
+	dedup     *services.Deduplicator // идентификаторы принятых показаний
+}
+
+// Register регистрирует маршруты пользовательского сервиса: прием
+// показаний, сводок и тревог от коллектора (POST /data), последнее
+// показание (GET /data), сводки, тревоги и панель (GET /)
+func Register(r *gin.Engine, cfg *config.UserConfig, dashboard *Dashboard) {
+	s := &service{
+		name:      cfg.Name,
+		dashboard: dashboard,
+		// Повторная доставка не дублирует строки на панели
+		dedup: services.NewDeduplicator(cfg.DedupWindow.Duration, cfg.DedupCapacity),
+	}
+
+	r.POST("/data", s.receive)
+	r.GET("/data", s.latest)
+	// Полученные сводки; ?window=5m - только сводки этого окна
+	r.GET("/rollups", func(c *gin.Context) {
+		c.JSON(http.StatusOK, dashboard.Rollups(c.Query("window")))
+	})
+	// Полученные тревоги; ?state=firing - только сработавшие
+	r.GET("/alerts", func(c *gin.Context) {
+		c.JSON(http.StatusOK, dashboard.Alerts(c.Query("state")))
+	})
+	r.GET("/", s.index)
+
+	// Загрузка HTML шаблонов
+	r.LoadHTMLGlob(cfg.Templates)
+}
+
+// receive принимает данные от коллектора
+func (s *service) receive(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Сводки коллектора хранятся отдельно; повтор заменяет прежнюю копию
+	if c.ContentType() == codec.ContentTypeRollup {
+		var rollup models.Rollup
+		if err := json.Unmarshal(body, &rollup); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("%s получил сводку %s", s.name, rollup.ID())
+		s.dashboard.AddRollup(rollup)
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+		return
+	}
+
+	// Уведомления о тревогах: снятие заменяет срабатывание
+	if c.ContentType() == codec.ContentTypeAlert {
+		var alert models.Alert
+		if err := json.Unmarshal(body, &alert); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("%s получил тревогу %s (%s): %s", s.name, alert.Rule, alert.State, alert.Message)
+		s.dashboard.AddAlert(alert)
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+		return
+	}
+
+	// Формат тела - по Content-Type; сообщения старых версий схемы
+	// преобразуются к текущей
+	data, err := codec.Decode(c.ContentType(), body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Повтор подтверждается так же, как первая доставка, чтобы
+	// отправитель не повторял его снова
+	if s.dedup.Seen(data.ID) {
+		log.Printf("%s: повтор показания %s пропущен", s.name, data.ID)
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate", "id": data.ID})
+		return
+	}
+
+	log.Printf("%s получил данные: %+v", s.name, data)
+	// Подробное логирование полученных данных
+	LogData(s.name, data)
+
+	s.dashboard.Add(data)
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// latest отдает последнее показание в формате, выбранном по заголовку Accept
+func (s *service) latest(c *gin.Context) {
+	bodyCodec, err := codec.Negotiate(c.GetHeader("Accept"))
+	if err != nil {
+		c.JSON(http.StatusNotAcceptable, gin.H{"error": err.Error(), "supported": codec.ContentTypes()})
+		return
+	}
+	data, ok := s.dashboard.Latest()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "данных еще нет"})
+		return
+	}
+	body, err := bodyCodec.Encode(data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, bodyCodec.ContentType(), body)
+}
+
+// index отображает последние данные
+func (s *service) index(c *gin.Context) {
+	columns, rows := s.dashboard.Table()
+	c.HTML(http.StatusOK, "index.html", gin.H{
+		"title":   s.name + " Dashboard",
+		"columns": columns,
+		"data":    rows,
+		"alerts":  s.dashboard.Alerts(models.AlertFiring),
+	})
+}
@@ -0,0 +1,183 @@
+package user
+
+import (
+	"big_go/internal/models"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Column - столбец метрики на панели пользователя
+type Column struct {
+	Name string
+	Unit string
+}
+
+// Cell - значение метрики в строке панели
+type Cell struct {
+	Value   string // пусто, если пост метрику не сообщил
+	Quality string
+}
+
+// Row - одно показание на панели
+type Row struct {
+	Meta  models.MetaData
+	Cells []Cell // по одной на каждый столбец
+}
+
+// Dashboard хранит последние показания, сводки и тревоги, полученные
+// пользователем, и строит по показаниям таблицу из всех встретившихся метрик
+type Dashboard struct {
+	mu      sync.Mutex
+	limit   int
+	data    []models.SensorData
+	rollups []models.Rollup
+	alerts  []models.Alert
+}
+
+// NewDashboard создает панель, хранящую не больше limit показаний, limit
+// сводок и limit тревог
+func NewDashboard(limit int) *Dashboard {
+	return &Dashboard{limit: limit}
+}
+
+// Add добавляет показание, вытесняя самое старое
+func (d *Dashboard) Add(data models.SensorData) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.data = append(d.data, data)
+	if len(d.data) > d.limit {
+		d.data = d.data[len(d.data)-d.limit:]
+	}
+}
+
+// AddRollup добавляет сводку, вытесняя самую старую. Сводка с тем же
+// идентификатором (повторная доставка) заменяет прежнюю; части окна,
+// закрытого раньше срока, хранятся отдельно.
+func (d *Dashboard) AddRollup(rollup models.Rollup) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := rollup.ID()
+	for i, r := range d.rollups {
+		if r.ID() == id {
+			d.rollups[i] = rollup
+			return
+		}
+	}
+	d.rollups = append(d.rollups, rollup)
+	if len(d.rollups) > d.limit {
+		d.rollups = d.rollups[len(d.rollups)-d.limit:]
+	}
+}
+
+// Rollups возвращает полученные сводки окна window (пусто - всех окон)
+// в порядке получения
+func (d *Dashboard) Rollups(window string) []models.Rollup {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := []models.Rollup{}
+	for _, r := range d.rollups {
+		if window == "" || r.Window == window {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// AddAlert добавляет уведомление о тревоге, вытесняя самое старое.
+// Снятие тревоги заменяет ее срабатывание (у них один идентификатор).
+func (d *Dashboard) AddAlert(alert models.Alert) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := alert.ID()
+	for i, a := range d.alerts {
+		if a.ID() == id {
+			d.alerts[i] = alert
+			return
+		}
+	}
+	d.alerts = append(d.alerts, alert)
+	if len(d.alerts) > d.limit {
+		d.alerts = d.alerts[len(d.alerts)-d.limit:]
+	}
+}
+
+// Alerts возвращает полученные тревоги в состоянии state (пусто - во
+// всех), новые первыми
+func (d *Dashboard) Alerts(state string) []models.Alert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := []models.Alert{}
+	for i := len(d.alerts) - 1; i >= 0; i-- {
+		if state == "" || d.alerts[i].State == state {
+			result = append(result, d.alerts[i])
+		}
+	}
+	return result
+}
+
+// Latest возвращает последнее полученное показание
+func (d *Dashboard) Latest() (models.SensorData, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.data) == 0 {
+		return models.SensorData{}, false
+	}
+	return d.data[len(d.data)-1], true
+}
+
+// Table возвращает столбцы всех метрик, встретившихся в показаниях, и строки
+func (d *Dashboard) Table() ([]Column, []Row) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	units := make(map[string]string)
+	for _, data := range d.data {
+		for name, m := range data.Data {
+			if units[name] == "" {
+				units[name] = m.Unit
+			}
+		}
+	}
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	models.SortMetrics(names)
+
+	columns := make([]Column, len(names))
+	for i, name := range names {
+		columns[i] = Column{Name: name, Unit: units[name]}
+	}
+
+	rows := make([]Row, len(d.data))
+	for i, data := range d.data {
+		rows[i] = Row{Meta: data.Meta, Cells: make([]Cell, len(names))}
+		for j, name := range names {
+			if m, ok := data.Data[name]; ok {
+				rows[i].Cells[j] = Cell{Value: strconv.FormatFloat(m.Value, 'f', 2, 64), Quality: m.Quality}
+			}
+		}
+	}
+	return columns, rows
+}
+
+// LogData подробно логирует полученное показание
+func LogData(user string, data models.SensorData) {
+	log.Printf("%s получил данные:", user)
+	log.Printf("  Метаданные:")
+	log.Printf("    Получатель: %s", data.Meta.Recipient)
+	log.Printf("    ID поста: %d", data.Meta.PostID)
+	log.Printf("    Адрес: %d", data.Meta.Address)
+	log.Printf("    Временная метка: %s", data.Meta.Timestamp.Format(time.RFC3339))
+	log.Printf("  Данные измерений:")
+	for _, name := range data.Data.Names() {
+		m := data.Data[name]
+		if m.Quality != "" {
+			log.Printf("    %s: %.2f %s (%s)", name, m.Value, m.Unit, m.Quality)
+		} else {
+			log.Printf("    %s: %.2f %s", name, m.Value, m.Unit)
+		}
+	}
+}